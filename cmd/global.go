@@ -15,9 +15,7 @@ var version = "0.0.1"
 
 // GlobalOptions hold all global options for v3io-backup tool.
 type GlobalOptions struct {
-	Repo     string
-	Quiet    bool
-	LogLevel string
+	Quiet bool
 
 	ctx    context.Context
 	stdout io.Writer
@@ -40,9 +38,7 @@ func init() {
 	})
 
 	f := cmdRoot.GetCmd().PersistentFlags()
-	f.StringVarP(&globalOptions.Repo, "repo", "r", os.Getenv("V3IO_REPOSITORY"), "repository to backup to or restore from (default: $V3IO_REPOSITORY)")
 	f.BoolVarP(&globalOptions.Quiet, "quiet", "q", false, "do not output comprehensive progress report")
-	f.StringVar(&globalOptions.LogLevel, "log-level", "v", "set log level. Valid values error|warn|info|debug.")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
 
 	restoreTerminal()
@@ -63,10 +59,6 @@ func checkErrno(err error) error {
 	return err
 }
 
-func stdinIsTerminal() bool {
-	return terminal.IsTerminal(int(os.Stdin.Fd()))
-}
-
 func stdoutIsTerminal() bool {
 	return terminal.IsTerminal(int(os.Stdout.Fd()))
 }
@@ -0,0 +1,643 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package archiver
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/chunker"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/table"
+)
+
+// Approximate size of the blobs holding the encoded items of the NoSQL tables
+const tableBlobSize = 1024 * 1024
+
+// Options of a single backup run
+type Options struct {
+	Endpoint          string // describes where the data came from, recorded in the snapshot
+	Container         string
+	Paths             []string
+	Excludes          []string
+	Tags              []string
+	ModifiedAfterTime time.Time
+	StreamsFrom       time.Time            // back up the stream records that arrived after this time (ModifiedAfterTime when zero)
+	Parent            *repository.Snapshot // previous complete snapshot of the same paths, its unchanged sealed TSDB partitions are reused
+}
+
+// Stats counts the entries processed by the archiver
+type Stats struct {
+	Files       int64
+	Directories int64
+	Tables      int64
+	Items       int64
+	Partitions  int64 // unchanged TSDB partitions reused from the parent snapshot
+	Streams     int64
+	Records     int64
+	Bytes       int64
+}
+
+// Archiver backs up the entries of a data source into a repository
+type Archiver struct {
+	repo   *repository.Repository
+	ds     backend.DataSource
+	cfg    *config.Config
+	logger logger.Logger
+	stats  Stats
+	scans  chan struct{} // bounds the concurrent table segment and stream shard scans of all the workers
+}
+
+func New(repo *repository.Repository, ds backend.DataSource, cfg *config.Config, logger logger.Logger) *Archiver {
+	return &Archiver{
+		repo:   repo,
+		ds:     ds,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// Stats returns the counters of the last run
+func (a *Archiver) Stats() Stats {
+	return Stats{
+		Files:       atomic.LoadInt64(&a.stats.Files),
+		Directories: atomic.LoadInt64(&a.stats.Directories),
+		Tables:      atomic.LoadInt64(&a.stats.Tables),
+		Items:       atomic.LoadInt64(&a.stats.Items),
+		Partitions:  atomic.LoadInt64(&a.stats.Partitions),
+		Streams:     atomic.LoadInt64(&a.stats.Streams),
+		Records:     atomic.LoadInt64(&a.stats.Records),
+		Bytes:       atomic.LoadInt64(&a.stats.Bytes),
+	}
+}
+
+// Snapshot backs up the given paths and writes a snapshot document describing them.
+// The file contents are read and saved by ScannerParallelism concurrent workers. The items of
+// every NoSQL table are read in ScannerParallelism segments and the stream shards one by one,
+// with at most ScannerParallelism segment and shard scans running at once across all workers.
+func (a *Archiver) Snapshot(opts Options) (*repository.Snapshot, error) {
+	startTime := time.Now()
+
+	iter, err := a.ds.Scan(opts.Paths, opts.ModifiedAfterTime)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	parallelism := a.cfg.ScannerParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	a.scans = make(chan struct{}, parallelism)
+
+	streamsFrom := opts.StreamsFrom
+	if streamsFrom.IsZero() {
+		streamsFrom = opts.ModifiedAfterTime
+	}
+
+	scanPaths := opts.Paths
+	if len(scanPaths) == 0 {
+		scanPaths = a.cfg.BackupOptions.Paths
+	}
+
+	var (
+		tree     = repository.NewTreeBuilder(a.repo)
+		order    = newScanOrder(scanPaths)
+		tables   []*backend.FileInfo
+		schemas  = make(map[string]bool) // the directories whose schema was backed up
+		lock     sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+		jobs     = make(chan *backend.FileInfo, parallelism)
+	)
+
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+
+			// Chunking buffers are reused across the files of the worker
+			chnkr := chunker.New(nil, a.repo.Config().ChunkerSeed)
+			buf := make([]byte, 0, chunker.MinSize)
+
+			for fileInfo := range jobs {
+				var (
+					node *repository.Node
+					err  error
+				)
+				if fileInfo.Partition() != nil {
+					node, err = a.savePartition(fileInfo, opts, parallelism)
+				} else if fileInfo.IsTable() {
+					node, err = a.saveTable(fileInfo, opts.ModifiedAfterTime, parallelism)
+				} else if fileInfo.Stream() != nil {
+					node, err = a.saveStream(fileInfo, streamsFrom, parallelism)
+				} else {
+					node, err = a.saveFile(fileInfo, chnkr, &buf)
+				}
+				if os.IsPermission(errors.Cause(err)) {
+					a.logger.WarnWith("Skipping unreadable file", "path", fileInfo.Path(), "error", err)
+					lock.Lock()
+					if err := tree.Release(fileInfo.Path()); err != nil && firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+					continue
+				}
+
+				lock.Lock()
+				if err == nil {
+					err = tree.Add(fileInfo.Path(), node)
+				}
+				if err == nil && fileInfo.IsTable() {
+					tables = append(tables, fileInfo)
+				}
+				if err == nil && node.Schema != nil {
+					schemas[path.Dir(fileInfo.Path())] = true
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// The trees of the directories the scan is done with are saved as soon as their entries were added
+	for fileInfo := iter.Next(); fileInfo != nil && !failed(); fileInfo = iter.Next() {
+		lock.Lock()
+		var err error
+		for _, dir := range order.next(fileInfo) {
+			if err = tree.Finish(dir); err != nil {
+				break
+			}
+		}
+		isDir := fileInfo.IsDir() && !fileInfo.IsTable() && fileInfo.Stream() == nil
+		if err == nil && isDir {
+			atomic.AddInt64(&a.stats.Directories, 1)
+			err = tree.Add(fileInfo.Path(), &repository.Node{
+				Type:               repository.NodeTypeDir,
+				ModTime:            fileInfo.ModTime(),
+				ExtendedAttributes: fileInfo.ExtendedAttributes(),
+			})
+		} else if err == nil {
+			err = tree.Reserve(fileInfo.Path())
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		lock.Unlock()
+
+		if err == nil && !isDir {
+			jobs <- fileInfo
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if err := checkSchemas(schemas, tables); err != nil {
+		return nil, err
+	}
+
+	// Unchanged directories yield the same trees as in the previous snapshots, which are not stored again
+	treeID, err := tree.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot must only reference data that was stored
+	if err := a.repo.Flush(); err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		a.logger.WarnWith("Failed to get the hostname", "error", err)
+	}
+
+	sn := &repository.Snapshot{
+		Time:      startTime,
+		Hostname:  hostname,
+		Endpoint:  opts.Endpoint,
+		Container: opts.Container,
+		Paths:     opts.Paths,
+		Excludes:  opts.Excludes,
+		Tags:      opts.Tags,
+		Tree:      &treeID,
+	}
+	if opts.Parent != nil {
+		sn.Parent = opts.Parent.ID()
+	}
+	if !opts.ModifiedAfterTime.IsZero() {
+		// Only the modified entries were scanned, the snapshot can't stand for the complete state of the paths
+		modifiedAfter := opts.ModifiedAfterTime.UTC()
+		sn.ModifiedAfter = &modifiedAfter
+	}
+	if a.cfg.BuildInfo != nil {
+		sn.Version = a.cfg.BuildInfo.Version
+	}
+
+	if _, err := a.repo.SaveSnapshot(sn); err != nil {
+		return nil, err
+	}
+
+	return sn, nil
+}
+
+// saveFile splits the file contents into content-defined chunks, stores them and returns the node describing the file.
+// Chunks already stored in the repository (by previous snapshots or other files) are not stored again.
+func (a *Archiver) saveFile(fileInfo *backend.FileInfo, chnkr *chunker.Chunker, buf *[]byte) (*repository.Node, error) {
+	reader, err := a.ds.Open(fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	node := &repository.Node{
+		Type:               repository.NodeTypeFile,
+		ModTime:            fileInfo.ModTime(),
+		ExtendedAttributes: fileInfo.ExtendedAttributes(),
+	}
+
+	// Metadata files are small, their contents are kept for validation
+	var metadata []byte
+	if repository.IsMetadataFile(fileInfo.Name()) {
+		node.Type = repository.NodeTypeMetadata
+		metadata = []byte{}
+	}
+
+	chnkr.Reset(reader)
+	for {
+		chunk, err := chnkr.Next(*buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read '%s'.", fileInfo.Path())
+		}
+		*buf = chunk
+
+		id, err := a.repo.SaveBlob(repository.DataBlob, chunk)
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, id)
+		node.Size += uint64(len(chunk))
+		if metadata != nil {
+			metadata = append(metadata, chunk...)
+		}
+	}
+
+	if fileInfo.Name() == repository.SchemaFileName {
+		if node.Schema, err = repository.ParseSchema(metadata); err != nil {
+			return nil, errors.Wrapf(err, "Invalid schema '%s'.", fileInfo.Path())
+		}
+	}
+
+	atomic.AddInt64(&a.stats.Files, 1)
+	atomic.AddInt64(&a.stats.Bytes, int64(node.Size))
+	a.logger.DebugWith("Saved", "path", fileInfo.Path(), "size", node.Size, "blobs", len(node.Content))
+
+	return node, nil
+}
+
+// checkSchemas verifies that the schema of every backed up table was backed up as well (the schema of a TSDB
+// partition is the schema of the TSDB table holding it)
+func checkSchemas(schemas map[string]bool, tables []*backend.FileInfo) error {
+	for _, fileInfo := range tables {
+		tablePath := fileInfo.Path()
+		if fileInfo.Partition() != nil {
+			tablePath = path.Dir(tablePath)
+		}
+
+		if !schemas[tablePath] {
+			return errors.Errorf("The schema of table '%s' is missing - the table can't be restored without it.", tablePath)
+		}
+	}
+	return nil
+}
+
+// saveTable reads the items of the table with concurrent segment scans, stores them as encoded table blobs
+// and returns the node describing the table directory. The blobs of every segment are listed in scan order.
+func (a *Archiver) saveTable(fileInfo *backend.FileInfo, modifiedAfterTime time.Time, segments int) (*repository.Node, error) {
+	tableSource, ok := a.ds.(backend.TableSource)
+	if !ok {
+		return nil, errors.Errorf("Can't read table '%s' - the data source doesn't support tables.", fileInfo.Path())
+	}
+
+	var (
+		wg     sync.WaitGroup
+		blobs  = make([][]repository.ID, segments)
+		counts = make([]uint64, segments)
+		errs   = make([]error, segments)
+	)
+
+	wg.Add(segments)
+	for segment := 0; segment < segments; segment++ {
+		go func(segment int) {
+			defer wg.Done()
+
+			encoder := table.NewEncoder()
+			flush := func() error {
+				if encoder.Len() == 0 {
+					return nil
+				}
+				counts[segment] += uint64(encoder.Len())
+				id, err := a.saveEncoded(encoder)
+				if err != nil {
+					return err
+				}
+				blobs[segment] = append(blobs[segment], id)
+				return nil
+			}
+
+			err := a.scan(func() error {
+				return tableSource.ScanTable(fileInfo, segment, segments, modifiedAfterTime, func(items []map[string]interface{}) error {
+					for _, item := range items {
+						if err := encoder.Add(item); err != nil {
+							return errors.Wrapf(err, "Failed to encode an item of table '%s'.", fileInfo.Path())
+						}
+						if encoder.Size() >= tableBlobSize {
+							if err := flush(); err != nil {
+								return err
+							}
+						}
+					}
+					return nil
+				})
+			})
+			if err == nil {
+				err = flush()
+			}
+			errs[segment] = err
+		}(segment)
+	}
+	wg.Wait()
+
+	node := &repository.Node{
+		Type:               repository.NodeTypeDir,
+		ModTime:            fileInfo.ModTime(),
+		ExtendedAttributes: fileInfo.ExtendedAttributes(),
+	}
+	for segment := 0; segment < segments; segment++ {
+		if errs[segment] != nil {
+			return nil, errs[segment]
+		}
+		node.Items = append(node.Items, blobs[segment]...)
+		node.ItemCount += counts[segment]
+	}
+
+	atomic.AddInt64(&a.stats.Tables, 1)
+	atomic.AddInt64(&a.stats.Items, int64(node.ItemCount))
+	a.logger.DebugWith("Saved table", "path", fileInfo.Path(), "items", node.ItemCount, "blobs", len(node.Items))
+
+	return node, nil
+}
+
+// savePartition returns the node of the TSDB partition of the parent snapshot if the partition is sealed and
+// its items didn't change since, and otherwise reads the partition items like those of any other table.
+// Partitions are never reused when the data source can't summarize their items.
+func (a *Archiver) savePartition(fileInfo *backend.FileInfo, opts Options, segments int) (*repository.Node, error) {
+	info := fileInfo.Partition()
+	partition := &repository.Partition{
+		Start:    info.Start,
+		End:      info.End,
+		Sealed:   info.Sealed,
+		Complete: opts.ModifiedAfterTime.IsZero(),
+	}
+
+	// The stats are read before the items, so items modified meanwhile are read again by the next backup
+	if partitionSource, ok := a.ds.(backend.PartitionSource); ok {
+		stats, err := partitionSource.PartitionStats(fileInfo)
+		if err != nil {
+			return nil, err
+		}
+		partition.Stats = &repository.PartitionStats{ItemCount: stats.ItemCount, LatestModTime: stats.LatestModTime}
+	}
+
+	if opts.Parent != nil && opts.Parent.Tree != nil && partition.Sealed && partition.Stats != nil {
+		previous, err := a.repo.FindNode(*opts.Parent.Tree, fileInfo.Path())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to look up '%s' in the parent snapshot.", fileInfo.Path())
+		}
+		if previous != nil && previous.Partition != nil && previous.Partition.Unchanged(partition) {
+			atomic.AddInt64(&a.stats.Partitions, 1)
+			a.logger.DebugWith("Unchanged partition", "path", fileInfo.Path(), "items", previous.ItemCount)
+			node := *previous
+			return &node, nil
+		}
+	}
+
+	node, err := a.saveTable(fileInfo, opts.ModifiedAfterTime, segments)
+	if err != nil {
+		return nil, err
+	}
+	node.Partition = partition
+	return node, nil
+}
+
+// saveStream reads the records of the stream shards with up to 'parallelism' concurrent workers, stores them
+// as encoded table blobs and returns the node describing the stream
+func (a *Archiver) saveStream(fileInfo *backend.FileInfo, from time.Time, parallelism int) (*repository.Node, error) {
+	streamSource, ok := a.ds.(backend.StreamSource)
+	if !ok {
+		return nil, errors.Errorf("Can't read stream '%s' - the data source doesn't support streams.", fileInfo.Path())
+	}
+
+	info := fileInfo.Stream()
+	stream := &repository.Stream{
+		ShardCount:           info.ShardCount,
+		RetentionPeriodHours: info.RetentionPeriodHours,
+		Shards:               make([]repository.StreamShard, info.ShardCount),
+	}
+
+	var (
+		wg     sync.WaitGroup
+		errs   = make([]error, info.ShardCount)
+		shards = make(chan int)
+	)
+
+	if parallelism > info.ShardCount {
+		parallelism = info.ShardCount
+	}
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for shard := range shards {
+				errs[shard] = a.scan(func() error {
+					return a.saveShard(streamSource, fileInfo, shard, from, &stream.Shards[shard])
+				})
+			}
+		}()
+	}
+	for shard := 0; shard < info.ShardCount; shard++ {
+		shards <- shard
+	}
+	close(shards)
+	wg.Wait()
+
+	var records uint64
+	for shard := range stream.Shards {
+		if errs[shard] != nil {
+			return nil, errs[shard]
+		}
+		records += stream.Shards[shard].RecordCount
+	}
+
+	atomic.AddInt64(&a.stats.Streams, 1)
+	atomic.AddInt64(&a.stats.Records, int64(records))
+	a.logger.DebugWith("Saved stream", "path", fileInfo.Path(), "shards", info.ShardCount, "records", records)
+
+	return &repository.Node{
+		Type:    repository.NodeTypeStream,
+		ModTime: fileInfo.ModTime(),
+		Stream:  stream,
+	}, nil
+}
+
+// saveShard reads the records of a single stream shard and stores them, in sequence order
+func (a *Archiver) saveShard(streamSource backend.StreamSource, fileInfo *backend.FileInfo, shard int, from time.Time,
+	result *repository.StreamShard) error {
+
+	encoder := table.NewEncoder()
+	flush := func() error {
+		if encoder.Len() == 0 {
+			return nil
+		}
+		result.RecordCount += uint64(encoder.Len())
+		id, err := a.saveEncoded(encoder)
+		if err != nil {
+			return err
+		}
+		result.Records = append(result.Records, id)
+		return nil
+	}
+
+	err := streamSource.ScanStream(fileInfo, shard, from, func(records []backend.StreamRecord) error {
+		for i := range records {
+			if result.RecordCount == 0 && encoder.Len() == 0 {
+				result.FirstSequenceNumber = records[i].SequenceNumber
+			}
+			result.LastSequenceNumber = records[i].SequenceNumber
+
+			if err := encoder.Add(records[i].Item()); err != nil {
+				return errors.Wrapf(err, "Failed to encode a record of stream '%s'.", fileInfo.Path())
+			}
+			if encoder.Size() >= tableBlobSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// scan runs a table segment or stream shard scan once fewer than ScannerParallelism scans are running
+func (a *Archiver) scan(fn func() error) error {
+	a.scans <- struct{}{}
+	defer func() { <-a.scans }()
+	return fn()
+}
+
+// saveEncoded stores the items of the encoder as a single blob, and resets the encoder
+func (a *Archiver) saveEncoded(encoder *table.Encoder) (repository.ID, error) {
+	data := encoder.Encode()
+	id, err := a.repo.SaveBlob(repository.DataBlob, data)
+	if err != nil {
+		return repository.ID{}, err
+	}
+
+	atomic.AddInt64(&a.stats.Bytes, int64(len(data)))
+	encoder.Reset()
+	return id, nil
+}
+
+// scanOrder tells when the scan is done with a directory. The data sources list the directories breadth first,
+// in the order they were emitted, and emit the entries of a listed directory together. The entries of the
+// ancestors of the scanned paths are emitted apart, so these directories are only done once the scan ends.
+type scanOrder struct {
+	ancestors map[string]bool // the ancestors of the scanned paths
+	queue     []string        // the emitted directories whose entries weren't emitted yet, in scan order
+	queued    map[string]bool
+	current   string // the directory of the last emitted entry
+}
+
+func newScanOrder(paths []string) *scanOrder {
+	so := &scanOrder{ancestors: make(map[string]bool), queued: make(map[string]bool)}
+	for _, p := range paths {
+		for dir := path.Dir(path.Clean("/" + p)); !so.ancestors[dir]; dir = path.Dir(dir) {
+			so.ancestors[dir] = true
+		}
+	}
+	return so
+}
+
+// next returns the directories the scan is done with, once the given entry is emitted
+func (so *scanOrder) next(fileInfo *backend.FileInfo) []string {
+	var done []string
+	if dir := path.Dir(fileInfo.Path()); dir != so.current {
+		if so.current != "" && !so.ancestors[so.current] {
+			done = append(done, so.current)
+		}
+		so.current = dir
+
+		// The directories emitted before are listed already - some of them were empty or never listed
+		for so.queued[dir] {
+			queued := so.queue[0]
+			so.queue = so.queue[1:]
+			delete(so.queued, queued)
+			if queued != dir {
+				done = append(done, queued)
+			}
+		}
+	}
+
+	if fileInfo.IsDir() {
+		so.queue = append(so.queue, fileInfo.Path())
+		so.queued[fileInfo.Path()] = true
+	}
+	return done
+}
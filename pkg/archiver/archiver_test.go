@@ -0,0 +1,520 @@
+// +build unit
+
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/backend/local"
+	"v3io-backup/pkg/chunker"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/filter"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/restorer"
+	"v3io-backup/pkg/storage"
+	"v3io-backup/pkg/storage/location"
+	"v3io-backup/pkg/utils"
+)
+
+// testRepository is a repository initialized within a temporary directory, along with the "source"
+// directory next to it that is backed up by the tests
+type testRepository struct {
+	dir       string
+	sourceDir string
+	cfg       *config.Config
+	logger    logger.Logger
+	backend   storage.Backend
+	repo      *repository.Repository
+}
+
+// newTestRepository initializes a repository with the given configuration (and the defaults) within a new
+// temporary directory, which is removed by cleanup
+func newTestRepository(tst *testing.T, cfg *config.Config) *testRepository {
+	dir, err := ioutil.TempDir("", "v3io-backup-e2e")
+	require.NoError(tst, err)
+
+	tr := &testRepository{dir: dir, sourceDir: filepath.Join(dir, "source"), cfg: config.WithDefaults(cfg)}
+	tr.logger, err = utils.NewLogger("error")
+	require.NoError(tst, err)
+
+	tr.backend, err = location.Open("local:"+filepath.Join(dir, "repo"), tr.cfg)
+	require.NoError(tst, err)
+	tr.repo, err = repository.Init(tr.backend, tr.cfg, "secret")
+	require.NoError(tst, err)
+
+	return tr
+}
+
+// source returns the local data source reading the source directory, which must be populated first
+func (tr *testRepository) source(tst *testing.T) *local.LocalDataSource {
+	source, err := local.NewDataSource(tr.sourceDir, tr.logger)
+	require.NoError(tst, err)
+	return source
+}
+
+func (tr *testRepository) cleanup() {
+	os.RemoveAll(tr.dir)
+}
+
+func TestBackupAndRestoreLocalDirectory(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{})
+	defer tr.cleanup()
+
+	sourceDir := tr.sourceDir
+	targetDir := filepath.Join(tr.dir, "target")
+	require.NoError(tst, os.MkdirAll(targetDir, 0755))
+
+	large := make([]byte, 8*chunker.MinSize+17)
+	rand.New(rand.NewSource(1)).Read(large)
+	contents := map[string][]byte{
+		"data/a.csv":          []byte("a,b,c\n1,2,3\n"),
+		"data/copy.csv":       []byte("a,b,c\n1,2,3\n"),
+		"data/empty":          {},
+		"data/sub/large.bin":  large,
+		"logs/app/server.log": []byte("log line\n"),
+	}
+	for name, data := range contents {
+		hostPath := filepath.Join(sourceDir, filepath.FromSlash(name))
+		require.NoError(tst, os.MkdirAll(filepath.Dir(hostPath), 0755))
+		require.NoError(tst, ioutil.WriteFile(hostPath, data, 0644))
+	}
+	require.NoError(tst, os.Symlink("a.csv", filepath.Join(sourceDir, "data", "link.csv")))
+	require.NoError(tst, os.Symlink("sub", filepath.Join(sourceDir, "data", "link-dir")))
+	require.NoError(tst, os.Symlink("missing", filepath.Join(sourceDir, "data", "dangling")))
+
+	// Links out of the source directory are skipped, whether relative or absolute
+	outside := filepath.Join(tr.dir, "outside.txt")
+	require.NoError(tst, ioutil.WriteFile(outside, []byte("secret"), 0644))
+	require.NoError(tst, os.Symlink(filepath.Join("..", "..", "outside.txt"), filepath.Join(sourceDir, "data", "outside-relative")))
+	require.NoError(tst, os.Symlink(outside, filepath.Join(sourceDir, "data", "outside-absolute")))
+
+	source := tr.source(tst)
+	arch := New(tr.repo, source, tr.cfg, tr.logger)
+	sn, err := arch.Snapshot(Options{Endpoint: "file://" + source.Root(), Paths: []string{"/"}})
+	require.NoError(tst, err)
+
+	stats := arch.Stats()
+	assert.Equal(tst, int64(len(contents)+1), stats.Files)
+	assert.Equal(tst, int64(4), stats.Directories)
+	assert.Equal(tst, int64(4), source.Skipped())
+
+	// Read the repository back from scratch
+	repo, err := repository.Open(tr.backend, tr.cfg, "secret")
+	require.NoError(tst, err)
+	require.NoError(tst, repo.LoadIndex())
+
+	id, err := repo.FindSnapshot(sn.ID().Str(), &repository.SnapshotFilter{})
+	require.NoError(tst, err)
+	sn, err = repo.LoadSnapshot(id)
+	require.NoError(tst, err)
+	assert.Equal(tst, "file://"+source.Root(), sn.Endpoint)
+
+	target, err := local.NewDataSource(targetDir, tr.logger)
+	require.NoError(tst, err)
+
+	res, err := restorer.New(repo, target, restorer.Options{TargetPath: "/", Parallelism: 2}, tr.logger)
+	require.NoError(tst, err)
+	require.NoError(tst, res.Restore(sn))
+
+	contents["data/link.csv"] = contents["data/a.csv"]
+	for name, data := range contents {
+		restored, err := ioutil.ReadFile(filepath.Join(targetDir, filepath.FromSlash(name)))
+		require.NoError(tst, err, name)
+		assert.True(tst, bytes.Equal(data, restored), name)
+	}
+
+	_, err = os.Lstat(filepath.Join(targetDir, "data", "link-dir"))
+	assert.True(tst, os.IsNotExist(err))
+	_, err = os.Lstat(filepath.Join(targetDir, "data", "dangling"))
+	assert.True(tst, os.IsNotExist(err))
+	_, err = os.Lstat(filepath.Join(targetDir, "data", "outside-relative"))
+	assert.True(tst, os.IsNotExist(err))
+	_, err = os.Lstat(filepath.Join(targetDir, "data", "outside-absolute"))
+	assert.True(tst, os.IsNotExist(err))
+}
+
+// tableSource serves the "/table" directory of a local data source as a NoSQL table, the "/events"
+// directory as a stream, and the subdirectories of "/tsdb" as TSDB partitions (the latest one unsealed)
+// whose items were all last modified at itemsModTime
+type tableSource struct {
+	*local.LocalDataSource
+	items        []map[string]interface{}
+	itemsModTime time.Time
+	records      [][]backend.StreamRecord
+	scanDelay    time.Duration
+	scans        int32 // running table and stream scans
+	scansPeak    int32
+}
+
+type tableIterator struct {
+	backend.FileInfoIterator
+}
+
+func (ts *tableSource) Scan(paths []string, modifiedAfterTime time.Time) (backend.FileInfoIterator, error) {
+	iter, err := ts.LocalDataSource.Scan(paths, modifiedAfterTime)
+	return &tableIterator{iter}, err
+}
+
+func (ts *tableSource) ScanTable(fileInfo *backend.FileInfo, segment, totalSegments int, modifiedAfterTime time.Time,
+	fn func(items []map[string]interface{}) error) error {
+	defer ts.startScan()()
+	for i := segment; i < len(ts.items); i += totalSegments {
+		if err := fn(ts.items[i : i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *tableSource) PartitionStats(fileInfo *backend.FileInfo) (*backend.PartitionStats, error) {
+	return &backend.PartitionStats{ItemCount: uint64(len(ts.items)), LatestModTime: ts.itemsModTime}, nil
+}
+
+func (ts *tableSource) ScanStream(fileInfo *backend.FileInfo, shard int, from time.Time,
+	fn func(records []backend.StreamRecord) error) error {
+	defer ts.startScan()()
+	return fn(ts.records[shard])
+}
+
+// startScan counts a running scan, keeping the peak count, and returns the function ending it
+func (ts *tableSource) startScan() func() {
+	scans := atomic.AddInt32(&ts.scans, 1)
+	for {
+		peak := atomic.LoadInt32(&ts.scansPeak)
+		if scans <= peak || atomic.CompareAndSwapInt32(&ts.scansPeak, peak, scans) {
+			break
+		}
+	}
+	time.Sleep(ts.scanDelay)
+	return func() { atomic.AddInt32(&ts.scans, -1) }
+}
+
+func (it *tableIterator) Next() *backend.FileInfo {
+	info := it.FileInfoIterator.Next()
+	if info != nil && info.Path() == "/table" {
+		return backend.NewTableInfo(info.Path(), info.BaseInfo(), nil)
+	}
+	if info != nil && info.IsDir() && filepath.Dir(info.Path()) == "/tsdb" {
+		start := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+		if info.Name() == "1" {
+			start = start.AddDate(0, 0, 1)
+		}
+		return backend.NewPartitionInfo(info.Path(), info.BaseInfo(), &backend.PartitionInfo{
+			Start:  start,
+			End:    start.AddDate(0, 0, 1),
+			Sealed: info.Name() == "0",
+		})
+	}
+	if info != nil && info.Path() == "/events" {
+		return backend.NewStreamInfo(info.Path(), info.BaseInfo(), &backend.StreamInfo{ShardCount: 2, RetentionPeriodHours: 24})
+	}
+	return info
+}
+
+// tableTarget collects the restored table items and stream records, dropping the files
+type tableTarget struct {
+	lock    sync.Mutex
+	items   map[string][]map[string]interface{}
+	streams map[string]int
+	records map[int][]backend.StreamRecord
+}
+
+type discardFile struct{}
+
+func (tt *tableTarget) MkDir(path string) error {
+	return nil
+}
+
+func (tt *tableTarget) Create(path string) (io.WriteCloser, error) {
+	return discardFile{}, nil
+}
+
+func (tt *tableTarget) PutItems(path string, items []map[string]interface{}) error {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	tt.items[path] = append(tt.items[path], items...)
+	return nil
+}
+
+func (tt *tableTarget) CreateStream(path string, shardCount, retentionPeriodHours int) error {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	tt.streams[path] = shardCount
+	return nil
+}
+
+func (tt *tableTarget) PutRecords(path string, shard int, records []backend.StreamRecord) error {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	tt.records[shard] = append(tt.records[shard], records...)
+	return nil
+}
+
+func (discardFile) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardFile) Close() error {
+	return nil
+}
+
+func TestBackupAndRestoreTablesAndStreams(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{ScannerParallelism: 3})
+	defer tr.cleanup()
+
+	sourceDir := tr.sourceDir
+	require.NoError(tst, os.MkdirAll(filepath.Join(sourceDir, "table"), 0755))
+	require.NoError(tst, ioutil.WriteFile(filepath.Join(sourceDir, "table", ".#schema"), []byte(`{"key": "id"}`), 0644))
+	require.NoError(tst, os.MkdirAll(filepath.Join(sourceDir, "events"), 0755))
+
+	arrivalTime := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	records := [][]backend.StreamRecord{
+		{
+			{SequenceNumber: 1, ArrivalTime: arrivalTime, PartitionKey: "a", Data: []byte("first")},
+			{SequenceNumber: 2, ArrivalTime: arrivalTime.Add(time.Second), ClientInfo: []byte("client"), Data: []byte("second")},
+		},
+		{
+			{SequenceNumber: 1, ArrivalTime: arrivalTime, Data: []byte("other shard")},
+		},
+	}
+
+	var items []map[string]interface{}
+	for i := 0; i < 100; i++ {
+		items = append(items, map[string]interface{}{
+			backend.ItemNameAttribute: fmt.Sprintf("item-%d", i),
+			"id":                      int64(i),
+			"value":                   float64(i) / 2,
+		})
+	}
+
+	arch := New(tr.repo, &tableSource{LocalDataSource: tr.source(tst), items: items, records: records}, tr.cfg, tr.logger)
+	sn, err := arch.Snapshot(Options{Paths: []string{"/"}})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(1), arch.Stats().Tables)
+	assert.Equal(tst, int64(len(items)), arch.Stats().Items)
+	assert.Equal(tst, int64(1), arch.Stats().Files)
+	assert.Equal(tst, int64(1), arch.Stats().Streams)
+	assert.Equal(tst, int64(3), arch.Stats().Records)
+
+	target := &tableTarget{
+		items:   make(map[string][]map[string]interface{}),
+		streams: make(map[string]int),
+		records: make(map[int][]backend.StreamRecord),
+	}
+	res, err := restorer.New(tr.repo, target, restorer.Options{TargetPath: "/restored", Parallelism: 2}, tr.logger)
+	require.NoError(tst, err)
+	require.NoError(tst, res.Restore(sn))
+
+	assert.Equal(tst, int64(len(items)), res.Stats().Items)
+	assert.ElementsMatch(tst, items, target.items["/restored/table"])
+	assert.Equal(tst, map[string]int{"/restored/events": 2}, target.streams)
+	assert.Equal(tst, records[0], target.records[0])
+	assert.Equal(tst, records[1], target.records[1])
+	assert.Equal(tst, int64(3), res.Stats().Records)
+}
+
+func TestUnchangedPartitionsAreReused(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{ScannerParallelism: 2})
+	defer tr.cleanup()
+
+	sourceDir := tr.sourceDir
+	for _, partition := range []string{"0", "1"} {
+		require.NoError(tst, os.MkdirAll(filepath.Join(sourceDir, "tsdb", partition), 0755))
+	}
+	require.NoError(tst, ioutil.WriteFile(filepath.Join(sourceDir, "tsdb", ".#schema"),
+		[]byte(`{"partitionSchemaInfo": {"partitionerInterval": "1d"}}`), 0644))
+
+	itemsModTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := &tableSource{LocalDataSource: tr.source(tst), itemsModTime: itemsModTime, items: []map[string]interface{}{
+		{backend.ItemNameAttribute: "metric", "_v": []byte{1, 2, 3}},
+	}}
+
+	arch := New(tr.repo, source, tr.cfg, tr.logger)
+	parent, err := arch.Snapshot(Options{Paths: []string{"/"}})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(2), arch.Stats().Tables)
+	assert.Equal(tst, int64(0), arch.Stats().Partitions)
+
+	// Only the unsealed partition is read again
+	arch = New(tr.repo, source, tr.cfg, tr.logger)
+	sn, err := arch.Snapshot(Options{Paths: []string{"/"}, Parent: parent})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(1), arch.Stats().Tables)
+	assert.Equal(tst, int64(1), arch.Stats().Partitions)
+	assert.Equal(tst, parent.ID(), sn.Parent)
+
+	node, err := tr.repo.FindNode(*sn.Tree, "/tsdb/0")
+	require.NoError(tst, err)
+	require.NotNil(tst, node.Partition)
+	assert.True(tst, node.Partition.Sealed)
+	assert.Equal(tst, uint64(1), node.ItemCount)
+	assert.Equal(tst, &repository.PartitionStats{ItemCount: 1, LatestModTime: itemsModTime}, node.Partition.Stats)
+	assert.False(tst, parent.Incremental())
+	assert.False(tst, sn.Incremental())
+
+	// A sealed partition whose items were modified (late samples) is read again, whatever its directory mtime
+	source.itemsModTime = itemsModTime.Add(time.Second)
+	arch = New(tr.repo, source, tr.cfg, tr.logger)
+	_, err = arch.Snapshot(Options{Paths: []string{"/"}, Parent: sn})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(2), arch.Stats().Tables)
+	assert.Equal(tst, int64(0), arch.Stats().Partitions)
+
+	source.itemsModTime = itemsModTime
+	source.items = append(source.items, map[string]interface{}{backend.ItemNameAttribute: "other", "_v": []byte{4}})
+	arch = New(tr.repo, source, tr.cfg, tr.logger)
+	_, err = arch.Snapshot(Options{Paths: []string{"/"}, Parent: sn})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(2), arch.Stats().Tables)
+	assert.Equal(tst, int64(0), arch.Stats().Partitions)
+
+	// A snapshot of the modified entries only is recorded as incremental
+	modifiedAfter := time.Now().Add(-time.Hour)
+	arch = New(tr.repo, source, tr.cfg, tr.logger)
+	sn, err = arch.Snapshot(Options{Paths: []string{"/"}, ModifiedAfterTime: modifiedAfter})
+	require.NoError(tst, err)
+	require.True(tst, sn.Incremental())
+	assert.True(tst, modifiedAfter.Equal(*sn.ModifiedAfter))
+}
+
+func TestMalformedSchemaFailsBackup(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{})
+	defer tr.cleanup()
+
+	sourceDir := tr.sourceDir
+	require.NoError(tst, os.MkdirAll(filepath.Join(sourceDir, "table"), 0755))
+	require.NoError(tst, ioutil.WriteFile(filepath.Join(sourceDir, "table", ".#schema"), []byte(`{"key": `), 0644))
+
+	_, err := New(tr.repo, &tableSource{LocalDataSource: tr.source(tst)}, tr.cfg, tr.logger).Snapshot(Options{Paths: []string{"/"}})
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "Invalid schema")
+}
+
+func TestExcludedEntriesAreNotBackedUp(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{})
+	defer tr.cleanup()
+
+	sourceDir := tr.sourceDir
+	for name, data := range map[string]string{
+		"data/a.csv":          "a,b,c\n",
+		"data/a.tmp":          "tmp",
+		"data/keep.tmp":       "keep",
+		"data/large.bin":      "0123456789",
+		"cache/x.csv":         "x",
+		"private/.nobackup":   "",
+		"private/secrets.txt": "secret",
+	} {
+		hostPath := filepath.Join(sourceDir, filepath.FromSlash(name))
+		require.NoError(tst, os.MkdirAll(filepath.Dir(hostPath), 0755))
+		require.NoError(tst, ioutil.WriteFile(hostPath, []byte(data), 0644))
+	}
+
+	entryFilter, err := filter.New(filter.Options{
+		Excludes:          []string{`\.tmp$`, "glob:cache/"},
+		Includes:          []string{"keep"},
+		ExcludeLargerThan: "8",
+		ExcludeIfPresent:  []string{".nobackup"},
+	})
+	require.NoError(tst, err)
+	source := tr.source(tst)
+	source.SetFilter(entryFilter)
+
+	sn, err := New(tr.repo, source, tr.cfg, tr.logger).Snapshot(Options{Paths: []string{"/"}, Excludes: entryFilter.Rules()})
+	require.NoError(tst, err)
+
+	var paths []string
+	require.NoError(tst, tr.repo.WalkTree(*sn.Tree, func(p string, node *repository.Node) error {
+		paths = append(paths, p)
+		return nil
+	}))
+	assert.Equal(tst, []string{"/data", "/data/a.csv", "/data/keep.tmp"}, paths)
+	assert.Equal(tst, []filter.RuleStats{
+		{Rule: `re:\.tmp$`, Excluded: 1},
+		{Rule: "glob:cache/", Excluded: 1},
+		{Rule: "larger than 8", Excluded: 1},
+		{Rule: "if present .nobackup", Excluded: 1},
+	}, entryFilter.Stats())
+}
+
+func TestScansAreBoundedAcrossWorkers(tst *testing.T) {
+	tr := newTestRepository(tst, &config.Config{ScannerParallelism: 3})
+	defer tr.cleanup()
+
+	for _, partition := range []string{"0", "1", "2", "3", "4"} {
+		require.NoError(tst, os.MkdirAll(filepath.Join(tr.sourceDir, "tsdb", partition), 0755))
+	}
+	require.NoError(tst, ioutil.WriteFile(filepath.Join(tr.sourceDir, "tsdb", ".#schema"),
+		[]byte(`{"partitionSchemaInfo": {"partitionerInterval": "1d"}}`), 0644))
+	require.NoError(tst, os.MkdirAll(filepath.Join(tr.sourceDir, "events"), 0755))
+
+	source := &tableSource{
+		LocalDataSource: tr.source(tst),
+		items:           []map[string]interface{}{{backend.ItemNameAttribute: "metric", "_v": []byte{1, 2, 3}}},
+		records:         make([][]backend.StreamRecord, 2),
+		scanDelay:       20 * time.Millisecond,
+	}
+
+	// Every worker reads a table or a stream of its own, yet the segment and shard scans are shared
+	arch := New(tr.repo, source, tr.cfg, tr.logger)
+	_, err := arch.Snapshot(Options{Paths: []string{"/"}})
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(5), arch.Stats().Tables)
+	assert.Equal(tst, int64(1), arch.Stats().Streams)
+	assert.True(tst, atomic.LoadInt32(&source.scansPeak) > 1)
+	assert.True(tst, atomic.LoadInt32(&source.scansPeak) <= 3, "peak scans %d", source.scansPeak)
+}
+
+// entryInfo describes a scanned entry of the scan order tests
+type entryInfo struct {
+	name  string
+	isDir bool
+}
+
+func (ei entryInfo) Name() string       { return ei.name }
+func (ei entryInfo) Size() int64        { return 0 }
+func (ei entryInfo) ModTime() time.Time { return time.Time{} }
+func (ei entryInfo) IsDir() bool        { return ei.isDir }
+func (ei entryInfo) Sys() interface{}   { return nil }
+
+func (ei entryInfo) Mode() os.FileMode {
+	if ei.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func TestScanOrder(tst *testing.T) {
+	entry := func(p string, isDir bool) *backend.FileInfo {
+		return backend.NewFileInfo(p, entryInfo{name: path.Base(p), isDir: isDir}, nil)
+	}
+
+	// A directory is done once the entries of another directory are emitted, and the directories emitted
+	// before a listed one are done, whether listed or not
+	order := newScanOrder([]string{"/data", "/logs/app"})
+	assert.Empty(tst, order.next(entry("/data/a.csv", false)))
+	assert.Empty(tst, order.next(entry("/data/empty", true)))
+	assert.Empty(tst, order.next(entry("/data/sub", true)))
+	assert.Equal(tst, []string{"/data"}, order.next(entry("/logs/app/server.log", false)))
+	assert.Equal(tst, []string{"/logs/app", "/data/empty"}, order.next(entry("/data/sub/x.bin", false)))
+
+	// The ancestors of the scanned paths are never done, as the scanned paths are emitted apart
+	order = newScanOrder([]string{"/users", "events/"})
+	assert.Empty(tst, order.next(entry("/users", true)))
+	assert.Empty(tst, order.next(entry("/users/.#schema", false)))
+	assert.Equal(tst, []string{"/users"}, order.next(entry("/events", true)))
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package local
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/filter"
+)
+
+// Number of directory entries read at once
+const readDirBatchSize = 1024
+
+// LocalDataSource reads the data from a directory tree of the local file system.
+// Paths are absolute within the root directory, i.e. "/a/b" refers to "<root>/a/b".
+//
+// Symbolic links to regular files within the root directory are followed and backed up as files. Symbolic links
+// to directories are not traversed (to avoid cycles), and dangling links and links out of the root are skipped. Entries which can't be read due to
+// missing permissions are skipped with a warning rather than failing the whole backup.
+type LocalDataSource struct {
+	root     string
+	realRoot string // the root directory with its symbolic links resolved
+	logger   logger.Logger
+	filter   *filter.Filter
+	skipped  int64
+}
+
+func NewDataSource(root string, logger logger.Logger) (*LocalDataSource, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve directory '%s'.", root)
+	}
+
+	ds := &LocalDataSource{root: absRoot, logger: logger}
+	if err := ds.Connect(); err != nil {
+		return nil, err
+	}
+	if ds.realRoot, err = filepath.EvalSymlinks(absRoot); err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve directory '%s'.", root)
+	}
+	return ds, nil
+}
+
+// Root returns the absolute path of the root directory
+func (lds *LocalDataSource) Root() string {
+	return lds.root
+}
+
+// SetFilter sets the filter applied to the entries below the scanned paths (the paths themselves are not filtered)
+func (lds *LocalDataSource) SetFilter(f *filter.Filter) {
+	lds.filter = f
+}
+
+// Skipped returns the number of entries skipped due to errors
+func (lds *LocalDataSource) Skipped() int64 {
+	return atomic.LoadInt64(&lds.skipped)
+}
+
+func (lds *LocalDataSource) Connect() error {
+	info, err := os.Stat(lds.root)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to access directory '%s'.", lds.root)
+	}
+	if !info.IsDir() {
+		return errors.Errorf("'%s' is not a directory.", lds.root)
+	}
+
+	lds.logger.Info("Connected to local directory '%s'", lds.root)
+	return nil
+}
+
+func (lds *LocalDataSource) Disconnect() error {
+	return nil
+}
+
+// ListDir recursively lists the given paths
+func (lds *LocalDataSource) ListDir(paths []string) (backend.FileInfoIterator, error) {
+	return lds.Scan(paths, time.Time{})
+}
+
+// Scan recursively lists the given paths and returns the entries modified after the given time.
+// A zero modifiedAfterTime returns all the entries.
+func (lds *LocalDataSource) Scan(paths []string, modifiedAfterTime time.Time) (backend.FileInfoIterator, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("Scan cannot continue without path. Path(s) not set.")
+	}
+
+	it := &walker{lds: lds, modifiedAfterTime: modifiedAfterTime}
+	for _, p := range paths {
+		p = cleanPath(p)
+		info, err := os.Lstat(lds.hostPath(p))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to access '%s'.", p)
+		}
+
+		if info.IsDir() {
+			it.pending = append(it.pending, p)
+		} else if fileInfo := lds.newFileInfo(p, info); fileInfo != nil && it.isModified(fileInfo) {
+			it.page = append(it.page, fileInfo)
+		}
+	}
+
+	return it, nil
+}
+
+// Open returns a reader of the file contents
+func (lds *LocalDataSource) Open(fileInfo *backend.FileInfo) (io.ReadCloser, error) {
+	file, err := os.Open(lds.hostPath(fileInfo.Path()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open '%s'.", fileInfo.Path())
+	}
+	return file, nil
+}
+
+// MkDir creates the directory (along with any missing parents)
+func (lds *LocalDataSource) MkDir(p string) error {
+	return os.MkdirAll(lds.hostPath(p), 0755)
+}
+
+// Create returns a writer of a new file at the given path, creating the missing parent directories
+func (lds *LocalDataSource) Create(p string) (io.WriteCloser, error) {
+	hostPath := lds.hostPath(p)
+	if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create the directory of '%s'.", p)
+	}
+	return os.Create(hostPath)
+}
+
+// hostPath maps the data source path to the local file system path
+func (lds *LocalDataSource) hostPath(p string) string {
+	return filepath.Join(lds.root, filepath.FromSlash(cleanPath(p)))
+}
+
+// newFileInfo describes the entry, resolving symbolic links. Returns nil for entries that should be skipped.
+func (lds *LocalDataSource) newFileInfo(p string, info os.FileInfo) *backend.FileInfo {
+	if info.Mode()&os.ModeSymlink != 0 {
+		targetPath, err := filepath.EvalSymlinks(lds.hostPath(p))
+		if err != nil {
+			lds.skip(p, "dangling symbolic link", err)
+			return nil
+		}
+		if rel, err := filepath.Rel(lds.realRoot, targetPath); err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+
+			lds.skip(p, "symbolic link out of the root directory", err)
+			return nil
+		}
+		target, err := os.Stat(targetPath)
+		if err != nil {
+			lds.skip(p, "dangling symbolic link", err)
+			return nil
+		}
+		if target.IsDir() {
+			lds.skip(p, "symbolic link to a directory", nil)
+			return nil
+		}
+		info = &linkInfo{FileInfo: target, name: info.Name()}
+	}
+
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		lds.skip(p, "not a regular file", nil)
+		return nil
+	}
+
+	return backend.NewFileInfo(p, info, nil)
+}
+
+// hasFile returns true if the directory holds a file (or a link to a file) with the given name
+func (lds *LocalDataSource) hasFile(dir string, name string) (bool, error) {
+	info, err := os.Stat(lds.hostPath(path.Join(dir, name)))
+	if err != nil {
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "Failed to access '%s'.", path.Join(dir, name))
+	}
+	return !info.IsDir(), nil
+}
+
+func (lds *LocalDataSource) skip(p string, reason string, err error) {
+	atomic.AddInt64(&lds.skipped, 1)
+	lds.logger.WarnWith("Skipping entry", "path", p, "reason", reason, "error", err)
+}
+
+// linkInfo describes the target of a symbolic link, under the name of the link
+type linkInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (li *linkInfo) Name() string {
+	return li.name
+}
+
+// walker lazily walks the directory trees, reading the directories in batches
+type walker struct {
+	lds               *LocalDataSource
+	modifiedAfterTime time.Time
+	pending           []string // directories waiting to be listed
+	dir               string   // directory being listed
+	dirFile           *os.File
+	page              []*backend.FileInfo
+	current           *backend.FileInfo
+	err               error
+}
+
+func (w *walker) Next() *backend.FileInfo {
+	for w.err == nil {
+		if len(w.page) > 0 {
+			w.current, w.page = w.page[0], w.page[1:]
+			return w.current
+		}
+
+		if !w.nextBatch() {
+			break
+		}
+	}
+
+	w.current = nil
+	return nil
+}
+
+func (w *walker) At() *backend.FileInfo {
+	return w.current
+}
+
+func (w *walker) Error() error {
+	return w.err
+}
+
+func (w *walker) Close() error {
+	w.pending = nil
+	w.page = nil
+	if w.dirFile != nil {
+		err := w.dirFile.Close()
+		w.dirFile = nil
+		return err
+	}
+	return nil
+}
+
+// nextBatch reads the next batch of entries, moving on to the next pending directory
+// when the current one is exhausted. Returns false when there is nothing left to list.
+func (w *walker) nextBatch() bool {
+	for w.dirFile == nil {
+		if len(w.pending) == 0 {
+			return false
+		}
+		w.dir, w.pending = w.pending[0], w.pending[1:]
+
+		dirFile, err := os.Open(w.lds.hostPath(w.dir))
+		if err != nil {
+			if os.IsPermission(err) {
+				w.lds.skip(w.dir, "permission denied", err)
+				continue
+			}
+			w.err = errors.Wrapf(err, "Failed to open directory '%s'.", w.dir)
+			return false
+		}
+		w.dirFile = dirFile
+	}
+
+	infos, err := w.dirFile.Readdir(readDirBatchSize)
+	if err != nil && err != io.EOF {
+		w.err = errors.Wrapf(err, "Failed to read directory '%s'.", w.dir)
+		return false
+	}
+	if len(infos) < readDirBatchSize {
+		w.dirFile.Close()
+		w.dirFile = nil
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	w.page = w.page[:0]
+	for _, info := range infos {
+		p := path.Join(w.dir, info.Name())
+		fileInfo := w.lds.newFileInfo(p, info)
+		if fileInfo == nil {
+			continue
+		}
+		excluded, err := w.lds.filter.Excluded(fileInfo, w.lds.hasFile)
+		if err != nil {
+			w.err = err
+			return false
+		}
+		if excluded {
+			continue
+		}
+		if fileInfo.IsDir() {
+			w.pending = append(w.pending, p)
+		}
+		if w.isModified(fileInfo) {
+			w.page = append(w.page, fileInfo)
+		}
+	}
+
+	return true
+}
+
+func (w *walker) isModified(fileInfo *backend.FileInfo) bool {
+	return w.modifiedAfterTime.IsZero() || fileInfo.ModTime().After(w.modifiedAfterTime)
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
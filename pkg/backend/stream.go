@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StreamInfo describes a stream - its records are read shard by shard with the StreamSource of the data source
+type StreamInfo struct {
+	ShardCount           int
+	RetentionPeriodHours int
+}
+
+// StreamRecord is a single record of a stream shard
+type StreamRecord struct {
+	SequenceNumber uint64
+	ArrivalTime    time.Time
+	ClientInfo     []byte
+	PartitionKey   string
+	Data           []byte
+}
+
+// StreamSource is a data source holding streams. ScanStream reads the records of a single shard of the stream,
+// starting at the records that arrived after the given time (the earliest record when zero), and passes them
+// to fn in batches, in sequence order.
+type StreamSource interface {
+	ScanStream(fileInfo *FileInfo, shard int, from time.Time, fn func(records []StreamRecord) error) error
+}
+
+// Attributes of the items describing the stream records (see StreamRecord.Item)
+const (
+	recordSequenceNumber = "sequence_number"
+	recordArrivalTime    = "arrival_time"
+	recordClientInfo     = "client_info"
+	recordPartitionKey   = "partition_key"
+	recordData           = "data"
+)
+
+// NewStreamInfo returns the description of the stream at the given path
+func NewStreamInfo(path string, baseInfo os.FileInfo, stream *StreamInfo) *FileInfo {
+	fi := NewFileInfo(path, baseInfo, nil)
+	fi.stream = stream
+	return fi
+}
+
+// Stream returns the description of the stream, nil if the entry is not a stream
+func (fi *FileInfo) Stream() *StreamInfo {
+	return fi.stream
+}
+
+// Item returns the record as a table item, so records can be stored like the items of the NoSQL tables
+func (sr *StreamRecord) Item() map[string]interface{} {
+	item := map[string]interface{}{
+		recordSequenceNumber: int64(sr.SequenceNumber),
+		recordArrivalTime:    sr.ArrivalTime.UnixNano(),
+		recordData:           sr.Data,
+	}
+	if len(sr.ClientInfo) > 0 {
+		item[recordClientInfo] = sr.ClientInfo
+	}
+	if sr.PartitionKey != "" {
+		item[recordPartitionKey] = sr.PartitionKey
+	}
+	return item
+}
+
+// StreamRecordFromItem returns the record described by the item (see StreamRecord.Item)
+func StreamRecordFromItem(item map[string]interface{}) (StreamRecord, error) {
+	sequenceNumber, ok := item[recordSequenceNumber].(int64)
+	if !ok {
+		return StreamRecord{}, errors.New("Stream record has no sequence number.")
+	}
+	arrivalTime, _ := item[recordArrivalTime].(int64)
+	data, _ := item[recordData].([]byte)
+	clientInfo, _ := item[recordClientInfo].([]byte)
+	partitionKey, _ := item[recordPartitionKey].(string)
+
+	return StreamRecord{
+		SequenceNumber: uint64(sequenceNumber),
+		ArrivalTime:    time.Unix(0, arrivalTime).UTC(),
+		ClientInfo:     clientInfo,
+		PartitionKey:   partitionKey,
+		Data:           data,
+	}, nil
+}
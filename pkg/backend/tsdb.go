@@ -0,0 +1,38 @@
+package backend
+
+import (
+	"os"
+	"time"
+)
+
+// PartitionInfo describes a partition of a TSDB table - a NoSQL table holding the samples of a time range
+type PartitionInfo struct {
+	Start  time.Time
+	End    time.Time // zero if unknown (the latest partition of a table with an unknown partitioning interval)
+	Sealed bool      // a newer partition exists, so no more samples are expected (late samples may still be written)
+}
+
+// PartitionStats summarizes the items of a TSDB partition
+type PartitionStats struct {
+	ItemCount     uint64
+	LatestModTime time.Time // the latest modification time of the items, zero if there are none
+}
+
+// PartitionSource is a data source telling whether a TSDB partition changed without reading its items.
+// PartitionStats counts the items of the partition and finds their latest modification time.
+type PartitionSource interface {
+	PartitionStats(fileInfo *FileInfo) (*PartitionStats, error)
+}
+
+// NewPartitionInfo returns the description of the TSDB partition directory at the given path.
+// Partitions are tables - their items are read with the TableSource of the data source.
+func NewPartitionInfo(path string, baseInfo os.FileInfo, partition *PartitionInfo) *FileInfo {
+	fi := NewTableInfo(path, baseInfo, nil)
+	fi.partition = partition
+	return fi
+}
+
+// Partition returns the description of the TSDB partition, nil if the entry is not a partition
+func (fi *FileInfo) Partition() *PartitionInfo {
+	return fi.partition
+}
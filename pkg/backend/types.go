@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// DataSource is a source of the data to backup
+type DataSource interface {
+	Connect() error
+	Disconnect() error
+	ListDir(paths []string) (FileInfoIterator, error)
+	Scan(paths []string, modifiedAfterTime time.Time) (FileInfoIterator, error)
+	Open(fileInfo *FileInfo) (io.ReadCloser, error)
+}
+
+// Attribute holding the name of a table item
+const ItemNameAttribute = "__name"
+
+// TableSource is a data source holding NoSQL tables, whose items are read in bulk rather than one by one.
+// ScanTable reads the items of a single segment (out of totalSegments) of the table, modified after the given time
+// (all the items when zero), and passes them to fn in batches. Every item holds its name in ItemNameAttribute.
+type TableSource interface {
+	ScanTable(fileInfo *FileInfo, segment, totalSegments int, modifiedAfterTime time.Time,
+		fn func(items []map[string]interface{}) error) error
+}
+
+type FileInfo struct {
+	path               string
+	baseInfo           os.FileInfo
+	extendedAttributes map[string]interface{}
+	table              bool
+	stream             *StreamInfo
+	partition          *PartitionInfo
+}
+
+// NewFileInfo returns the description of the entry at the given path (absolute, within the data source)
+func NewFileInfo(path string, baseInfo os.FileInfo, extendedAttributes map[string]interface{}) *FileInfo {
+	return &FileInfo{
+		path:               path,
+		baseInfo:           baseInfo,
+		extendedAttributes: extendedAttributes,
+	}
+}
+
+// NewTableInfo returns the description of the NoSQL table directory at the given path.
+// The items of a table are read with the TableSource of the data source.
+func NewTableInfo(path string, baseInfo os.FileInfo, extendedAttributes map[string]interface{}) *FileInfo {
+	fi := NewFileInfo(path, baseInfo, extendedAttributes)
+	fi.table = true
+	return fi
+}
+
+// Path returns the absolute path of the entry within the data source
+func (fi *FileInfo) Path() string {
+	return fi.path
+}
+
+func (fi *FileInfo) Name() string {
+	return fi.baseInfo.Name()
+}
+
+func (fi *FileInfo) Size() int64 {
+	return fi.baseInfo.Size()
+}
+
+func (fi *FileInfo) Mode() os.FileMode {
+	return fi.baseInfo.Mode()
+}
+
+func (fi *FileInfo) ModTime() time.Time {
+	return fi.baseInfo.ModTime()
+}
+
+func (fi *FileInfo) IsDir() bool {
+	return fi.baseInfo.IsDir()
+}
+
+func (fi *FileInfo) BaseInfo() os.FileInfo {
+	return fi.baseInfo
+}
+
+func (fi *FileInfo) ExtendedAttributes() map[string]interface{} {
+	return fi.extendedAttributes
+}
+
+// IsTable returns true if the entry is a NoSQL table directory
+func (fi *FileInfo) IsTable() bool {
+	return fi.table
+}
+
+// FileInfoIterator iterates over data source entries.
+// Next advances the iterator and returns the next entry, or nil when the iteration is over
+// (either exhausted or failed - check Error() to tell the difference).
+// At returns the entry the iterator currently points to.
+// Close releases the resources held by the iterator, it must be called when done iterating.
+type FileInfoIterator interface {
+	Next() *FileInfo
+	At() *FileInfo
+	Error() error
+	Close() error
+}
@@ -0,0 +1,98 @@
+package v3io
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
+)
+
+// Prefix of the system attributes (size, mtime, etc.) maintained by V3IO
+const systemAttributePrefix = "__"
+
+// getAttributes reads the user attributes of the named items of the given directory (modified after the given
+// time, any when zero) with GetItems, keyed by item name. The names are those of a listing page, so only the
+// items within the range of the names are read. The items without user attributes are left out.
+// The values keep the types decoded by V3IO - int, float64, string, []byte and bool.
+func (vds *V3ioDataSource) getAttributes(dir string, names []string, modifiedAfterTime time.Time) (map[string]map[string]interface{}, error) {
+	listed := make(map[string]bool, len(names))
+	from, to := names[0], names[0]
+	for _, name := range names {
+		listed[name] = true
+		if name < from {
+			from = name
+		}
+		if name > to {
+			to = name
+		}
+	}
+
+	// The range is left open on the side of a name that can't be quoted, the items out of the page are dropped anyway
+	var filters []string
+	if literal, ok := stringLiteral(from); ok {
+		filters = append(filters, backend.ItemNameAttribute+" >= "+literal)
+	}
+	if literal, ok := stringLiteral(to); ok {
+		filters = append(filters, backend.ItemNameAttribute+" <= "+literal)
+	}
+	if filter := modifiedAfterFilter(modifiedAfterTime); filter != "" {
+		filters = append(filters, "("+filter+")")
+	}
+
+	input := &v3io.GetItemsInput{
+		Path:           listingPath(dir),
+		AttributeNames: []string{backend.ItemNameAttribute, "*"},
+		Filter:         strings.Join(filters, " and "),
+		Limit:          getItemsLimit,
+	}
+
+	attributes := make(map[string]map[string]interface{}, len(names))
+	for {
+		items, last, nextMarker, err := vds.getItems(input)
+		if err != nil {
+			if v3ioUtils.IsNotExistsError(err) {
+				// Deleted since listed, the read of its objects will tell
+				return attributes, nil
+			}
+			return nil, errors.Wrapf(err, "Failed to read the attributes of the items of '%s'.", dir)
+		}
+		for _, item := range items {
+			name, _ := item[backend.ItemNameAttribute].(string)
+			delete(item, backend.ItemNameAttribute)
+			if listed[name] && len(item) > 0 {
+				attributes[name] = item
+			}
+		}
+		if last || nextMarker == "" {
+			return attributes, nil
+		}
+		input.Marker = nextMarker
+	}
+}
+
+// stringLiteral quotes the string for a filter expression. Returns false if the string can't be quoted.
+func stringLiteral(value string) (string, bool) {
+	switch {
+	case !strings.Contains(value, "'"):
+		return "'" + value + "'", true
+	case !strings.Contains(value, `"`):
+		return `"` + value + `"`, true
+	default:
+		return "", false
+	}
+}
+
+// SetAttributes writes the user attributes of the item at the given path (restored with Create)
+func (vds *V3ioDataSource) SetAttributes(path string, attributes map[string]interface{}) error {
+	err := vds.container.PutItemSync(&v3io.PutItemInput{
+		Path:       normalisePath(path),
+		Attributes: attributes,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write the attributes of '%s'.", path)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package v3io
+
+import (
+	"encoding/xml"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
+)
+
+// dirIterator walks the directory trees under the given paths one listing page at a time, skipping the
+// entries excluded by the filter of the data source (and the contents of the excluded directories).
+// Only the current page and the list of directories pending traversal are held in memory,
+// so arbitrary large containers can be iterated.
+type dirIterator struct {
+	vds     *V3ioDataSource
+	pending []string // directories waiting to be listed
+	dir     string   // directory being listed, empty when between directories
+	marker  string   // marker of the next page of the current directory
+	page    []*backend.FileInfo
+	current *backend.FileInfo
+	err     error
+}
+
+func newDirIterator(vds *V3ioDataSource, paths []string) *dirIterator {
+	it := &dirIterator{vds: vds}
+	for _, p := range paths {
+		it.pending = append(it.pending, normaliseDirPath(p))
+	}
+	return it
+}
+
+func (it *dirIterator) Next() *backend.FileInfo {
+	for it.err == nil {
+		if len(it.page) > 0 {
+			it.current, it.page = it.page[0], it.page[1:]
+			return it.current
+		}
+
+		if !it.nextPage() {
+			break
+		}
+	}
+
+	it.current = nil
+	return nil
+}
+
+func (it *dirIterator) At() *backend.FileInfo {
+	return it.current
+}
+
+func (it *dirIterator) Error() error {
+	return it.err
+}
+
+func (it *dirIterator) Close() error {
+	it.pending = nil
+	it.page = nil
+	it.dir = ""
+	return nil
+}
+
+// nextPage loads the next listing page, moving on to the next pending directory
+// when the current one is exhausted. Returns false when there is nothing left to list.
+func (it *dirIterator) nextPage() bool {
+	if it.dir == "" {
+		if len(it.pending) == 0 {
+			return false
+		}
+		it.dir, it.pending = it.pending[0], it.pending[1:]
+		it.marker = ""
+	}
+
+	result, err := it.vds.listPage(it.dir, it.marker, false)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.page = it.page[:0]
+	for _, prefix := range result.CommonPrefixes {
+		info := it.vds.newDirInfo(prefix)
+		if !it.add(info) {
+			return false
+		}
+	}
+	for _, content := range result.Contents {
+		if !it.add(it.vds.newObjectInfo(content)) {
+			return false
+		}
+	}
+
+	if isTruncated(result) {
+		it.marker = result.NextMarker
+	} else {
+		it.dir = ""
+	}
+
+	return true
+}
+
+// add adds the entry to the current page unless excluded by the filter of the data source, the directories
+// are listed later on. Returns false if the filter failed.
+func (it *dirIterator) add(info *backend.FileInfo) bool {
+	excluded, err := it.vds.filter.Excluded(info, it.vds.hasFile)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if excluded {
+		return true
+	}
+	if info.IsDir() {
+		it.pending = append(it.pending, info.Path())
+	}
+	it.page = append(it.page, info)
+	return true
+}
+
+// listPage reads a single page of the directory listing, starting after the given marker
+func (vds *V3ioDataSource) listPage(dir string, marker string, directoriesOnly bool) (*ListBucketResult, error) {
+	response, err := vds.container.GetContainerContentsSync(&v3io.GetContainerContentsInput{
+		Path:            listingPath(dir),
+		Marker:          marker,
+		DirectoriesOnly: directoriesOnly,
+	})
+	defer releaseResponse(response)
+
+	if err != nil {
+		if v3ioUtils.IsNotExistsError(err) {
+			return nil, errors.Errorf("Path '%s' not found in container '%s' at '%s'.", dir, vds.cfg.Container, vds.cfg.WebApiEndpoint)
+		}
+		return nil, errors.Wrapf(err, "Failed to list '%s/%s%s'.", vds.cfg.WebApiEndpoint, vds.cfg.Container, dir)
+	}
+
+	result := ListBucketResult{}
+	if err := xml.Unmarshal(response.Body(), &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse the listing of '%s/%s%s'.", vds.cfg.WebApiEndpoint, vds.cfg.Container, dir)
+	}
+
+	return &result, nil
+}
+
+func (vds *V3ioDataSource) newObjectInfo(content Contents) *backend.FileInfo {
+	objectPath := normalisePath(content.Key)
+	return backend.NewFileInfo(objectPath, &objectInfo{
+		name:    path.Base(objectPath),
+		size:    content.Size,
+		modTime: vds.parseLastModified(content.LastModified),
+	}, nil)
+}
+
+func (vds *V3ioDataSource) newDirInfo(prefix CommonPrefixes) *backend.FileInfo {
+	dirPath := normaliseDirPath(prefix.Prefix)
+	return backend.NewFileInfo(dirPath, &objectInfo{
+		name:    path.Base(dirPath),
+		modTime: vds.parseLastModified(prefix.LastModified),
+		isDir:   true,
+	}, nil)
+}
+
+func (vds *V3ioDataSource) parseLastModified(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	modTime, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		vds.logger.DebugWith("Failed to parse modification time", "value", value, "error", err)
+		return time.Time{}
+	}
+	return modTime
+}
+
+func isTruncated(result *ListBucketResult) bool {
+	return strings.EqualFold(strings.TrimSpace(result.IsTruncated), "true") && result.NextMarker != ""
+}
+
+func listingPath(dir string) string {
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+// normaliseDirPath returns the directory path with a leading slash and without a trailing one ("/" stays as is)
+func normaliseDirPath(dir string) string {
+	dir = normalisePath(strings.TrimSpace(dir))
+	if dir != "/" {
+		dir = strings.TrimRight(dir, "/")
+	}
+	if dir == "" {
+		return "/"
+	}
+	return dir
+}
@@ -0,0 +1,385 @@
+// +build unit
+
+package v3io
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	v3ioerrors "github.com/v3io/v3io-go/pkg/errors"
+	"github.com/valyala/fasthttp"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/filter"
+	"v3io-backup/pkg/utils"
+)
+
+// fakeObject is an object of the fake container along with its modification time and user attributes
+type fakeObject struct {
+	data       []byte
+	modTime    time.Time
+	attributes map[string]interface{}
+}
+
+// fakeContainer is a minimal in-memory stand-in of a V3IO container. The directory listings are split into
+// pages of pageSize entries, and the requests in failures (e.g. "GetItem /dir/object") fail with the given error.
+type fakeContainer struct {
+	v3io.Container // the other requests are not used by the data source
+	lock           sync.Mutex
+	objects        map[string]*fakeObject
+	streams        map[string]*v3io.DescribeStreamOutput
+	failures       map[string]error
+	pageSize       int
+	listings       int
+	itemRequests   int // GetItem and GetItems requests
+	itemsRead      int // items returned by GetItems
+}
+
+func newFakeContainer(pageSize int) *fakeContainer {
+	return &fakeContainer{
+		objects:  make(map[string]*fakeObject),
+		streams:  make(map[string]*v3io.DescribeStreamOutput),
+		failures: make(map[string]error),
+		pageSize: pageSize,
+	}
+}
+
+func (fc *fakeContainer) put(p string, data string, modTime time.Time) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.objects[p] = &fakeObject{data: []byte(data), modTime: modTime}
+}
+
+func newResponse(output interface{}, body []byte) *v3io.Response {
+	response := &v3io.Response{Output: output, HTTPResponse: fasthttp.AcquireResponse()}
+	response.HTTPResponse.SetBody(body)
+	return response
+}
+
+func notFound(p string) error {
+	return v3ioerrors.NewErrorWithStatusCode(errors.Errorf("'%s' not found", p), http.StatusNotFound)
+}
+
+// item returns the system attributes of the object along with its user attributes
+func (fc *fakeContainer) item(p string, object *fakeObject) v3io.Item {
+	item := v3io.Item{
+		"__name":        path.Base(p),
+		"__size":        len(object.data),
+		"__mtime_secs":  int(object.modTime.Unix()),
+		"__mtime_nsecs": object.modTime.Nanosecond(),
+	}
+	for name, value := range object.attributes {
+		item[name] = value
+	}
+	return item
+}
+
+// GetItemSync returns the attributes of the object
+func (fc *fakeContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.itemRequests++
+	if err := fc.failures["GetItem "+input.Path]; err != nil {
+		return nil, err
+	}
+	object, ok := fc.objects[input.Path]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	return newResponse(&v3io.GetItemOutput{Item: fc.item(input.Path, object)}, nil), nil
+}
+
+// Filter expressions of the data source supported by GetItemsSync
+var (
+	nameFromFilter = regexp.MustCompile(`__name >= '([^']*)'`)
+	nameToFilter   = regexp.MustCompile(`__name <= '([^']*)'`)
+	mtimeFilter    = regexp.MustCompile(`__mtime_secs > (\d+) or \(__mtime_secs == \d+ and __mtime_nsecs > (\d+)\)`)
+)
+
+// GetItemsSync returns the attributes of the objects of the directory, in name order, starting after the
+// marker. Only the name range and the modification time filters of the data source are supported.
+func (fc *fakeContainer) GetItemsSync(input *v3io.GetItemsInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.itemRequests++
+	if err := fc.failures["GetItems "+input.Path]; err != nil {
+		return nil, err
+	}
+
+	var from, to string
+	var modifiedAfter time.Time
+	rest := input.Filter
+	if match := nameFromFilter.FindStringSubmatch(rest); match != nil {
+		from, rest = match[1], strings.Replace(rest, match[0], "", 1)
+	}
+	if match := nameToFilter.FindStringSubmatch(rest); match != nil {
+		to, rest = match[1], strings.Replace(rest, match[0], "", 1)
+	}
+	if match := mtimeFilter.FindStringSubmatch(rest); match != nil {
+		seconds, _ := strconv.ParseInt(match[1], 10, 64)
+		nanoseconds, _ := strconv.ParseInt(match[2], 10, 64)
+		modifiedAfter, rest = time.Unix(seconds, nanoseconds), strings.Replace(rest, match[0], "", 1)
+	}
+	if strings.Trim(strings.Replace(rest, " and ", "", -1), " ()") != "" {
+		return nil, errors.Errorf("Unsupported filter '%s'", input.Filter)
+	}
+
+	var names []string
+	for objectPath, object := range fc.objects {
+		name := path.Base(objectPath)
+		if path.Dir(objectPath)+"/" == input.Path && name > input.Marker && name >= from && (to == "" || name <= to) &&
+			object.modTime.After(modifiedAfter) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	output := &v3io.GetItemsOutput{Last: true}
+	if len(names) > fc.pageSize {
+		names = names[:fc.pageSize]
+		output.Last = false
+		output.NextMarker = names[len(names)-1]
+	}
+	for _, name := range names {
+		output.Items = append(output.Items, fc.item(name, fc.objects[input.Path+name]))
+	}
+	fc.itemsRead += len(output.Items)
+	return newResponse(output, nil), nil
+}
+
+func (fc *fakeContainer) GetObjectSync(input *v3io.GetObjectInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	if err := fc.failures["GetObject "+input.Path]; err != nil {
+		return nil, err
+	}
+	object, ok := fc.objects[input.Path]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	return newResponse(nil, object.data), nil
+}
+
+// DescribeStreamSync describes the streams added to the container, other paths are not found
+func (fc *fakeContainer) DescribeStreamSync(input *v3io.DescribeStreamInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	if err := fc.failures["DescribeStream "+input.Path]; err != nil {
+		return nil, err
+	}
+	stream, ok := fc.streams[strings.TrimSuffix(input.Path, "/")]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	output := *stream
+	return newResponse(&output, nil), nil
+}
+
+// GetContainerContentsSync lists the objects and the sub-directories of the directory, in name order,
+// starting after the marker. The modification time of a directory is the latest of its objects.
+func (fc *fakeContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.listings++
+	if err := fc.failures["GetContainerContents "+input.Path]; err != nil {
+		return nil, err
+	}
+
+	dir := strings.TrimPrefix(input.Path, "/")
+	modTimes := make(map[string]time.Time)
+	isDir := make(map[string]bool)
+	for objectPath, object := range fc.objects {
+		key := strings.TrimPrefix(objectPath, "/")
+		if !strings.HasPrefix(key, dir) {
+			continue
+		}
+		if i := strings.Index(key[len(dir):], "/"); i >= 0 {
+			key = key[:len(dir)+i+1]
+			isDir[key] = true
+		} else {
+			isDir[key] = false
+		}
+		if object.modTime.After(modTimes[key]) {
+			modTimes[key] = object.modTime
+		}
+	}
+	if len(isDir) == 0 && dir != "" {
+		return nil, notFound(input.Path)
+	}
+
+	var keys []string
+	for key := range isDir {
+		if key > input.Marker && (isDir[key] || !input.DirectoriesOnly) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := ListBucketResult{IsTruncated: "false"}
+	if len(keys) > fc.pageSize {
+		keys = keys[:fc.pageSize]
+		result.IsTruncated = "true"
+		result.NextMarker = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		lastModified := modTimes[key].Format(time.RFC3339Nano)
+		if isDir[key] {
+			result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefixes{Prefix: key, LastModified: lastModified})
+		} else {
+			result.Contents = append(result.Contents, Contents{
+				Key:          key,
+				Size:         int64(len(fc.objects["/"+key].data)),
+				LastModified: lastModified,
+			})
+		}
+	}
+
+	body, err := xml.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(nil, body), nil
+}
+
+func newTestDataSource(tst *testing.T, container v3io.Container, cfg *config.Config) *V3ioDataSource {
+	logger, err := utils.NewLogger("error")
+	require.NoError(tst, err)
+
+	cfg.WebApiEndpoint = "http://localhost:8081"
+	cfg.Container = "bigdata"
+	vds, err := newV3ioDataSource(cfg, container, logger)
+	require.NoError(tst, err)
+	return vds
+}
+
+// paths returns the paths of the remaining entries of the iterator
+func paths(tst *testing.T, iter backend.FileInfoIterator) []string {
+	var result []string
+	for info := iter.Next(); info != nil; info = iter.Next() {
+		assert.Equal(tst, info, iter.At())
+		result = append(result, info.Path())
+	}
+	return result
+}
+
+func TestListDir(tst *testing.T) {
+	modTime := time.Date(2019, 5, 1, 12, 30, 0, 500, time.UTC)
+	container := newFakeContainer(2)
+	for _, p := range []string{"/a.txt", "/data/1.csv", "/data/2.csv", "/data/3.csv", "/data/sub/x.bin", "/logs/app.log"} {
+		container.put(p, p, modTime)
+	}
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	// The pages of every directory are read in turn, the sub-directories are listed after their parent
+	container.listings = 0
+	iter, err := vds.ListDir([]string{"/"})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{
+		"/data", "/a.txt", "/logs",
+		"/data/1.csv", "/data/2.csv", "/data/sub", "/data/3.csv",
+		"/logs/app.log",
+		"/data/sub/x.bin",
+	}, paths(tst, iter))
+	require.NoError(tst, iter.Error())
+	assert.Equal(tst, 6, container.listings)
+	assert.Nil(tst, iter.At())
+
+	iter, err = vds.ListDir([]string{"data/sub/"})
+	require.NoError(tst, err)
+	info := iter.Next()
+	require.NotNil(tst, info)
+	assert.Equal(tst, "/data/sub/x.bin", info.Path())
+	assert.Equal(tst, "x.bin", info.Name())
+	assert.Equal(tst, int64(len("/data/sub/x.bin")), info.Size())
+	assert.True(tst, modTime.Equal(info.ModTime()))
+	assert.False(tst, info.IsDir())
+	assert.Nil(tst, iter.Next())
+
+	// The configured backup paths are listed when none are given
+	vds.cfg.BackupOptions.Paths = []string{"/logs"}
+	iter, err = vds.ListDir(nil)
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{"/logs/app.log"}, paths(tst, iter))
+
+	vds.cfg.BackupOptions.Paths = nil
+	_, err = vds.ListDir(nil)
+	assert.Error(tst, err)
+}
+
+func TestListDirErrors(tst *testing.T) {
+	container := newFakeContainer(2)
+	container.put("/data/1.csv", "1", time.Now())
+	container.put("/logs/app.log", "log", time.Now())
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	iter, err := vds.ListDir([]string{"/missing"})
+	require.NoError(tst, err)
+	assert.Nil(tst, iter.Next())
+	require.Error(tst, iter.Error())
+	assert.Contains(tst, iter.Error().Error(), "not found")
+
+	// The iteration stops at the first failed listing
+	connectionReset := errors.New("connection reset")
+	container.failures["GetContainerContents /logs/"] = connectionReset
+	iter, err = vds.ListDir([]string{"/"})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{"/data", "/logs", "/data/1.csv"}, paths(tst, iter))
+	require.Error(tst, iter.Error())
+	assert.Contains(tst, iter.Error().Error(), "Failed to list")
+	assert.Equal(tst, connectionReset, errors.Cause(iter.Error()))
+	assert.Nil(tst, iter.Next())
+}
+
+func TestListDirFilter(tst *testing.T) {
+	container := newFakeContainer(2)
+	for _, p := range []string{"/a.txt", "/a.tmp", "/data/1.csv", "/data/cache/c.bin", "/logs/.nobackup", "/logs/app.log"} {
+		container.put(p, p, time.Now())
+	}
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	entryFilter, err := filter.New(filter.Options{
+		Excludes:         []string{`\.tmp$`, "glob:cache/"},
+		ExcludeIfPresent: []string{".nobackup"},
+	})
+	require.NoError(tst, err)
+	vds.SetFilter(entryFilter)
+
+	// The excluded directories are not listed at all
+	container.listings = 0
+	iter, err := vds.ListDir([]string{"/"})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{"/a.txt", "/data", "/data/1.csv"}, paths(tst, iter))
+	require.NoError(tst, iter.Error())
+	assert.Equal(tst, 3, container.listings)
+
+	// The listed paths themselves are not filtered
+	iter, err = vds.ListDir([]string{"/data/cache"})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{"/data/cache/c.bin"}, paths(tst, iter))
+
+	connectionReset := errors.New("connection reset")
+	container.failures["GetItem /data/.nobackup"] = connectionReset
+	iter, err = vds.ListDir([]string{"/"})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{"/a.txt"}, paths(tst, iter))
+	require.Error(tst, iter.Error())
+	assert.Contains(tst, iter.Error().Error(), "Failed to apply exclude rule")
+	assert.Equal(tst, connectionReset, errors.Cause(iter.Error()))
+}
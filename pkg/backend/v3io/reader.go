@@ -0,0 +1,77 @@
+package v3io
+
+import (
+	"io"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+)
+
+// Size of the ranges read from the V3IO objects
+const objectReadSize = 4 * 1024 * 1024
+
+// objectReader reads a V3IO object sequentially, one range at a time
+type objectReader struct {
+	vds    *V3ioDataSource
+	path   string
+	size   int64
+	offset int64
+	buf    []byte
+}
+
+// Open returns a reader of the object contents
+func (vds *V3ioDataSource) Open(fileInfo *backend.FileInfo) (io.ReadCloser, error) {
+	if fileInfo.IsDir() {
+		return nil, errors.Errorf("Can't read '%s' - it is a directory.", fileInfo.Path())
+	}
+	return &objectReader{vds: vds, path: fileInfo.Path(), size: fileInfo.Size()}, nil
+}
+
+func (or *objectReader) Read(p []byte) (int, error) {
+	if len(or.buf) == 0 {
+		if or.offset >= or.size {
+			return 0, io.EOF
+		}
+		if err := or.readRange(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, or.buf)
+	or.buf = or.buf[n:]
+	return n, nil
+}
+
+func (or *objectReader) readRange() error {
+	numBytes := or.size - or.offset
+	if numBytes > objectReadSize {
+		numBytes = objectReadSize
+	}
+
+	response, err := or.vds.container.GetObjectSync(&v3io.GetObjectInput{
+		Path:     or.path,
+		Offset:   int(or.offset),
+		NumBytes: int(numBytes),
+	})
+	defer releaseResponse(response)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read '%s' at offset %d.", or.path, or.offset)
+	}
+
+	body := response.Body()
+	if len(body) == 0 {
+		return errors.Errorf("Object '%s' was truncated while reading (expected %d bytes, got %d).", or.path, or.size, or.offset)
+	}
+
+	// The response is released once read, keep a copy of the data
+	or.buf = append(or.buf[:0], body...)
+	or.offset += int64(len(body))
+	return nil
+}
+
+func (or *objectReader) Close() error {
+	or.buf = nil
+	return nil
+}
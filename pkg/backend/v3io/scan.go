@@ -0,0 +1,324 @@
+package v3io
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"v3io-backup/pkg/backend"
+)
+
+// Number of listing pages a scan worker may read ahead of the consumer
+const scanPagesReadAhead = 4
+
+// scanIterator traverses the directory trees using a bounded pool of workers.
+// Directories are listed concurrently (up to the configured parallelism) but the entries
+// are emitted in a deterministic order - directory by directory, in the order they were discovered.
+type scanIterator struct {
+	vds               *V3ioDataSource
+	modifiedAfterTime time.Time
+	parallelism       int
+
+	jobs      chan *dirScan
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	pending   []*dirScan // discovered directories, not scheduled yet
+	scheduled []*dirScan // scheduled directory listings, in emission order
+	dir       *dirScan   // directory listing being consumed
+	page      []*backend.FileInfo
+	current   *backend.FileInfo
+	err       error
+}
+
+// dirScan is a single directory listed by a scan worker
+type dirScan struct {
+	dir    string
+	root   bool              // one of the scanned paths, not known to be a table yet
+	schema *backend.FileInfo // the schema file of a NoSQL (or TSDB) table directory, nil for other directories
+	tsdb   *tsdbSchema       // the partitioning of a TSDB table directory, nil for other directories
+	pages  chan *scanPage
+}
+
+type scanPage struct {
+	entries []*backend.FileInfo
+	subdirs []*dirScan
+	err     error
+}
+
+func newScanIterator(vds *V3ioDataSource, paths []string, modifiedAfterTime time.Time, parallelism int) *scanIterator {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	it := &scanIterator{
+		vds:               vds,
+		modifiedAfterTime: modifiedAfterTime,
+		parallelism:       parallelism,
+		jobs:              make(chan *dirScan, parallelism),
+		done:              make(chan struct{}),
+	}
+	for _, p := range paths {
+		it.pending = append(it.pending, &dirScan{dir: normaliseDirPath(p), root: true})
+	}
+
+	it.wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go it.worker()
+	}
+
+	return it
+}
+
+func (it *scanIterator) Next() *backend.FileInfo {
+	for it.err == nil {
+		if len(it.page) > 0 {
+			it.current, it.page = it.page[0], it.page[1:]
+			return it.current
+		}
+
+		if it.dir == nil {
+			it.schedule()
+			if len(it.scheduled) == 0 {
+				it.Close()
+				break
+			}
+			it.dir, it.scheduled = it.scheduled[0], it.scheduled[1:]
+		}
+
+		page, ok := <-it.dir.pages
+		if !ok {
+			it.dir = nil
+			continue
+		}
+		if page.err != nil {
+			it.err = page.err
+			it.Close()
+			break
+		}
+
+		it.pending = append(it.pending, page.subdirs...)
+		it.page = page.entries
+	}
+
+	it.current = nil
+	return nil
+}
+
+func (it *scanIterator) At() *backend.FileInfo {
+	return it.current
+}
+
+func (it *scanIterator) Error() error {
+	return it.err
+}
+
+// Close stops the workers. Safe to call more than once.
+func (it *scanIterator) Close() error {
+	it.closeOnce.Do(func() {
+		close(it.done)
+		it.wg.Wait()
+	})
+	return nil
+}
+
+// schedule hands pending directories over to the workers, keeping at most 'parallelism' listings in flight
+func (it *scanIterator) schedule() {
+	inFlight := len(it.scheduled)
+	if it.dir != nil {
+		inFlight++
+	}
+
+	for ; inFlight < it.parallelism && len(it.pending) > 0; inFlight++ {
+		job := it.pending[0]
+		job.pages = make(chan *scanPage, scanPagesReadAhead)
+		it.pending = it.pending[1:]
+		it.scheduled = append(it.scheduled, job)
+		it.jobs <- job
+	}
+}
+
+func (it *scanIterator) worker() {
+	defer it.wg.Done()
+
+	for {
+		select {
+		case <-it.done:
+			return
+		case job := <-it.jobs:
+			it.listDir(job)
+		}
+	}
+}
+
+// listDir reads all the pages of a single directory and passes the (filtered) entries to the consumer.
+// NoSQL tables (directories with a schema file) are emitted as a single table entry - their items are
+// read in bulk by the archiver, so only the schema file and the subdirectories of a table are listed.
+// The partitions of TSDB tables are emitted as table entries as well (see listPartitions).
+// Streams are emitted as a single stream entry, and their shards are not listed.
+func (it *scanIterator) listDir(job *dirScan) {
+	defer close(job.pages)
+
+	var entries []*backend.FileInfo
+	if job.root && job.dir != "/" {
+		probed, stream, err := it.probe(job.dir)
+		if err != nil {
+			it.sendPage(job, &scanPage{err: err})
+			return
+		}
+
+		baseInfo := &objectInfo{name: path.Base(job.dir), isDir: true}
+		if stream != nil {
+			it.sendPage(job, &scanPage{entries: []*backend.FileInfo{backend.NewStreamInfo(job.dir, baseInfo, stream)}})
+			return
+		}
+		job.schema, job.tsdb = probed.schema, probed.tsdb
+		if job.schema != nil && job.tsdb == nil {
+			entries = append(entries, backend.NewTableInfo(job.dir, baseInfo, nil))
+		}
+	}
+	// The schema is always backed up along with the table, as the items can't be restored without it
+	if job.schema != nil {
+		entries = append(entries, job.schema)
+	}
+
+	if job.tsdb != nil {
+		it.listPartitions(job, entries)
+		return
+	}
+
+	marker := ""
+	for {
+		page := &scanPage{entries: entries}
+		entries = nil
+
+		result, err := it.vds.listPage(job.dir, marker, job.schema != nil)
+		if err != nil {
+			page.err = err
+		} else {
+			err = it.addPrefixes(page, result.CommonPrefixes)
+		}
+		if err == nil && job.schema == nil {
+			err = it.addObjects(job, page, result.Contents)
+		}
+		if err != nil {
+			page.err = err
+		}
+
+		if !it.sendPage(job, page) || err != nil || !isTruncated(result) {
+			return
+		}
+		marker = result.NextMarker
+	}
+}
+
+// probe tells the NoSQL tables, the TSDB tables and the streams apart from the other directories.
+// Returns the scan of the directory, or the description of the stream if the directory is a stream.
+func (it *scanIterator) probe(dir string) (*dirScan, *backend.StreamInfo, error) {
+	schema, err := it.vds.schemaInfo(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if schema == nil {
+		stream, err := it.vds.describeStream(dir)
+		if err != nil || stream != nil {
+			return nil, stream, err
+		}
+		return &dirScan{dir: dir}, nil, nil
+	}
+
+	tsdb, err := it.vds.readTsdbSchema(schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &dirScan{dir: dir, schema: schema, tsdb: tsdb}, nil, nil
+}
+
+// addPrefixes adds the (not excluded) subdirectories of the listed directory, telling the tables and the streams apart
+func (it *scanIterator) addPrefixes(page *scanPage, prefixes []CommonPrefixes) error {
+	for _, prefix := range prefixes {
+		info := it.vds.newDirInfo(prefix)
+		excluded, err := it.vds.filter.Excluded(info, it.vds.hasFile)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+
+		subdir, stream, err := it.probe(info.Path())
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case stream != nil:
+			// The records are filtered by their arrival time when read
+			page.entries = append(page.entries, backend.NewStreamInfo(info.Path(), info.BaseInfo(), stream))
+			continue
+		case subdir.schema != nil && subdir.tsdb == nil:
+			// The items are filtered by their modification time when read
+			page.entries = append(page.entries, backend.NewTableInfo(info.Path(), info.BaseInfo(), nil))
+		case it.isModified(info):
+			page.entries = append(page.entries, info)
+		}
+		page.subdirs = append(page.subdirs, subdir)
+	}
+	return nil
+}
+
+// addObjects adds the modified (and not excluded) objects of the listed page, along with their user
+// attributes. The attributes of the objects of the page are read at once, rather than one item at a time.
+func (it *scanIterator) addObjects(job *dirScan, page *scanPage, contents []Contents) error {
+	var objects []*backend.FileInfo
+	for _, content := range contents {
+		info := it.vds.newObjectInfo(content)
+		if !it.isModified(info) {
+			continue
+		}
+		excluded, err := it.vds.filter.Excluded(info, it.vds.hasFile)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+		objects = append(objects, info)
+	}
+	if len(objects) == 0 || it.vds.cfg.BackupOptions.SkipItemAttributes {
+		page.entries = append(page.entries, objects...)
+		return nil
+	}
+
+	names := make([]string, len(objects))
+	for i, info := range objects {
+		names[i] = info.Name()
+	}
+	attributes, err := it.vds.getAttributes(job.dir, names, it.modifiedAfterTime)
+	if err != nil {
+		return err
+	}
+	for _, info := range objects {
+		if itemAttributes := attributes[info.Name()]; itemAttributes != nil {
+			info = backend.NewFileInfo(info.Path(), info.BaseInfo(), itemAttributes)
+		}
+		page.entries = append(page.entries, info)
+	}
+	return nil
+}
+
+// sendPage passes the page to the consumer, returns false if the iterator was closed
+func (it *scanIterator) sendPage(job *dirScan, page *scanPage) bool {
+	select {
+	case <-it.done:
+		return false
+	case job.pages <- page:
+		return true
+	}
+}
+
+func (it *scanIterator) isModified(info *backend.FileInfo) bool {
+	return it.modifiedAfterTime.IsZero() || info.ModTime().After(it.modifiedAfterTime)
+}
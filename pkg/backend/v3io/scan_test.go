@@ -0,0 +1,272 @@
+// +build unit
+
+package v3io
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/config"
+)
+
+// newScanContainer returns a container holding plain directories, a NoSQL table (/users), a TSDB table
+// partitioned by the hour (/metrics) and a stream (/events). Only /data/1.csv and /data/sub/x.bin were
+// modified after the returned time.
+func newScanContainer(pageSize int) (*fakeContainer, time.Time) {
+	modifiedAfter := time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)
+	before, after := modifiedAfter.Add(-time.Hour), modifiedAfter.Add(time.Nanosecond)
+
+	container := newFakeContainer(pageSize)
+	container.put("/readme.txt", "readme", before)
+	container.put("/data/1.csv", "1,2,3", after)
+	container.put("/data/2.csv", "4,5,6", before)
+	container.put("/data/sub/x.bin", "x", after)
+	container.put("/users/.#schema", `{"key": "id"}`, before)
+	container.put("/users/u1", "", before)
+	container.put("/users/u2", "", before)
+	container.put("/metrics/.#schema", `{"partitionSchemaInfo": {"partitionerInterval": "1h"}}`, before)
+	container.put("/metrics/1556668800/cpu", "", before)
+	container.put("/metrics/1556672400/cpu", "", before)
+	container.put("/events/0", "", before)
+	container.put("/events/1", "", before)
+	container.streams["/events"] = &v3io.DescribeStreamOutput{ShardCount: 2, RetentionPeriodHours: 24}
+
+	return container, modifiedAfter
+}
+
+func scan(tst *testing.T, vds *V3ioDataSource, paths []string, modifiedAfter time.Time) map[string]*backend.FileInfo {
+	iter, err := vds.Scan(paths, modifiedAfter)
+	require.NoError(tst, err)
+	defer iter.Close()
+
+	infos := make(map[string]*backend.FileInfo)
+	for info := iter.Next(); info != nil; info = iter.Next() {
+		infos[info.Path()] = info
+	}
+	require.NoError(tst, iter.Error())
+	return infos
+}
+
+func TestScan(tst *testing.T) {
+	container, _ := newScanContainer(2)
+
+	// The entries are emitted directory by directory, in the order the directories were found,
+	// whatever the number of workers listing them
+	expected := []string{
+		"/data", "/events", "/metrics", "/readme.txt", "/users",
+		"/data/1.csv", "/data/2.csv", "/data/sub",
+		"/metrics/.#schema", "/metrics/1556668800", "/metrics/1556672400",
+		"/users/.#schema",
+		"/data/sub/x.bin",
+	}
+	for _, parallelism := range []int{1, 2, 8} {
+		vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: parallelism})
+		for i := 0; i < 5; i++ {
+			iter, err := vds.Scan([]string{"/"}, time.Time{})
+			require.NoError(tst, err)
+			assert.Equal(tst, expected, paths(tst, iter), "parallelism %d", parallelism)
+			require.NoError(tst, iter.Error())
+			require.NoError(tst, iter.Close())
+		}
+	}
+
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 4})
+	infos := scan(tst, vds, []string{"/"}, time.Time{})
+
+	assert.True(tst, infos["/users"].IsTable())
+	assert.Nil(tst, infos["/users"].Partition())
+	assert.Equal(tst, int64(len(`{"key": "id"}`)), infos["/users/.#schema"].Size())
+	assert.Equal(tst, &backend.StreamInfo{ShardCount: 2, RetentionPeriodHours: 24}, infos["/events"].Stream())
+	assert.True(tst, infos["/data"].IsDir())
+	assert.False(tst, infos["/data"].IsTable())
+
+	// The latest partition is unsealed, although its time range is over
+	start := time.Unix(1556668800, 0).UTC()
+	assert.Equal(tst, &backend.PartitionInfo{Start: start, End: start.Add(time.Hour), Sealed: true},
+		infos["/metrics/1556668800"].Partition())
+	assert.Equal(tst, &backend.PartitionInfo{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour), Sealed: false},
+		infos["/metrics/1556672400"].Partition())
+
+	// The TSDB range leaves out the partitions that don't overlap it
+	vds.cfg.BackupOptions.TsdbFrom = start.Add(90 * time.Minute).Format(time.RFC3339)
+	infos = scan(tst, vds, []string{"/metrics"}, time.Time{})
+	assert.Contains(tst, infos, "/metrics/1556672400")
+	assert.NotContains(tst, infos, "/metrics/1556668800")
+}
+
+func TestPartitionStats(tst *testing.T) {
+	container, modifiedAfter := newScanContainer(1)
+	latest := modifiedAfter.Add(time.Minute)
+	container.put("/metrics/1556668800/mem", "", latest)
+	container.put("/metrics/1556668800/disk", "", modifiedAfter)
+	vds := newTestDataSource(tst, container, &config.Config{})
+	infos := scan(tst, vds, []string{"/metrics"}, time.Time{})
+
+	// The items are counted across the pages
+	stats, err := vds.PartitionStats(infos["/metrics/1556668800"])
+	require.NoError(tst, err)
+	assert.Equal(tst, uint64(3), stats.ItemCount)
+	assert.True(tst, latest.Equal(stats.LatestModTime))
+
+	connectionReset := errors.New("connection reset")
+	container.failures["GetItems /metrics/1556668800/"] = connectionReset
+	_, err = vds.PartitionStats(infos["/metrics/1556668800"])
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "Failed to read the items of partition")
+	assert.Equal(tst, connectionReset, errors.Cause(err))
+}
+
+func TestScanModifiedAfter(tst *testing.T) {
+	container, modifiedAfter := newScanContainer(2)
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 3})
+
+	// Unmodified directories are still traversed. The tables, their schemas and the streams are always
+	// emitted, their items and records are filtered when read.
+	iter, err := vds.Scan([]string{"/"}, modifiedAfter)
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{
+		"/data", "/events", "/users",
+		"/data/1.csv", "/data/sub",
+		"/metrics/.#schema", "/metrics/1556668800", "/metrics/1556672400",
+		"/users/.#schema",
+		"/data/sub/x.bin",
+	}, paths(tst, iter))
+	require.NoError(tst, iter.Error())
+
+	infos := scan(tst, vds, []string{"/data"}, modifiedAfter.Add(time.Hour))
+	assert.Empty(tst, infos)
+}
+
+func TestScanProbesPaths(tst *testing.T) {
+	container, _ := newScanContainer(2)
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 2})
+
+	// The scanned paths are tables, TSDB tables and streams themselves
+	iter, err := vds.Scan([]string{"/users", "events/", "/metrics/"}, time.Time{})
+	require.NoError(tst, err)
+	assert.Equal(tst, []string{
+		"/users", "/users/.#schema",
+		"/events",
+		"/metrics/.#schema", "/metrics/1556668800", "/metrics/1556672400",
+	}, paths(tst, iter))
+	require.NoError(tst, iter.Error())
+
+	// The configured backup paths are scanned when none are given
+	vds.cfg.BackupOptions.Paths = []string{"/data/sub"}
+	infos := scan(tst, vds, nil, time.Time{})
+	assert.Contains(tst, infos, "/data/sub/x.bin")
+
+	vds.cfg.BackupOptions.Paths = nil
+	_, err = vds.Scan(nil, time.Time{})
+	assert.Error(tst, err)
+}
+
+func TestScanErrors(tst *testing.T) {
+	for _, test := range []struct {
+		request string
+		message string
+	}{
+		{"GetContainerContents /data/", "Failed to list"},
+		{"GetItem /users/.#schema", "Failed to read the schema"},
+		{"DescribeStream /events/", "Failed to describe"},
+		{"GetObject /metrics/.#schema", "Failed to read"},
+	} {
+		container, _ := newScanContainer(2)
+		connectionReset := errors.New("connection reset")
+		container.failures[test.request] = connectionReset
+		vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 4})
+
+		iter, err := vds.Scan([]string{"/"}, time.Time{})
+		require.NoError(tst, err)
+		paths(tst, iter)
+		require.Error(tst, iter.Error(), test.request)
+		assert.Contains(tst, iter.Error().Error(), test.message, test.request)
+		assert.Equal(tst, connectionReset, errors.Cause(iter.Error()), test.request)
+
+		// The iterator stays failed, and may be closed more than once
+		assert.Nil(tst, iter.Next())
+		require.NoError(tst, iter.Close())
+		require.NoError(tst, iter.Close())
+	}
+
+	container, _ := newScanContainer(2)
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 2})
+	iter, err := vds.Scan([]string{"/missing"}, time.Time{})
+	require.NoError(tst, err)
+	assert.Nil(tst, iter.Next())
+	require.Error(tst, iter.Error())
+	assert.Contains(tst, iter.Error().Error(), "not found")
+}
+
+func TestScanClose(tst *testing.T) {
+	container, _ := newScanContainer(1)
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 4})
+
+	// Closing the iterator early stops the workers
+	iter, err := vds.Scan([]string{"/"}, time.Time{})
+	require.NoError(tst, err)
+	require.NotNil(tst, iter.Next())
+	require.NoError(tst, iter.Close())
+	require.NoError(tst, iter.Error())
+}
+
+func TestScanItemAttributes(tst *testing.T) {
+	modifiedAfter := time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)
+	container := newFakeContainer(2)
+	for _, name := range []string{"1.csv", "2.csv", "3.csv", "4.csv"} {
+		container.put("/data/"+name, name, modifiedAfter.Add(time.Hour))
+	}
+	container.put("/data/sub/x.bin", "x", modifiedAfter.Add(time.Hour))
+	container.put("/data/old.csv", "old", modifiedAfter.Add(-time.Hour))
+	blob := []byte{1, 2, 3}
+	container.objects["/data/1.csv"].attributes = map[string]interface{}{
+		"count": 7, "ratio": 0.5, "name": "x", "flag": true, "blob": blob,
+	}
+	container.objects["/data/4.csv"].attributes = map[string]interface{}{"count": 4}
+	container.objects["/data/old.csv"].attributes = map[string]interface{}{"count": 0}
+
+	// The attributes of the items are read page by page, limited to the names of the listed page
+	vds := newTestDataSource(tst, container, &config.Config{ScannerParallelism: 2})
+	container.itemRequests = 0
+	container.itemsRead = 0
+	infos := scan(tst, vds, []string{"/data"}, time.Time{})
+	assert.Equal(tst, map[string]interface{}{"count": 7, "ratio": 0.5, "name": "x", "flag": true, "blob": []byte{1, 2, 3}},
+		infos["/data/1.csv"].ExtendedAttributes())
+	assert.Nil(tst, infos["/data/2.csv"].ExtendedAttributes())
+	assert.Equal(tst, map[string]interface{}{"count": 4}, infos["/data/4.csv"].ExtendedAttributes())
+	assert.Equal(tst, map[string]interface{}{"count": 0}, infos["/data/old.csv"].ExtendedAttributes())
+	assert.Nil(tst, infos["/data/sub"].ExtendedAttributes())
+	assert.Nil(tst, infos["/data/sub/x.bin"].ExtendedAttributes())
+
+	// Probing /data and /data/sub for a table schema, then reading the attributes of the 3 listing pages
+	// of /data and of the single page of /data/sub, each item being read once
+	assert.Equal(tst, 2+3+1, container.itemRequests)
+	assert.Equal(tst, 5+1, container.itemsRead)
+
+	// The values don't share the memory of the responses
+	blob[0] = 9
+	assert.Equal(tst, []byte{1, 2, 3}, infos["/data/1.csv"].ExtendedAttributes()["blob"])
+
+	infos = scan(tst, vds, []string{"/data"}, modifiedAfter)
+	assert.NotContains(tst, infos, "/data/old.csv")
+	assert.Equal(tst, map[string]interface{}{"count": 4}, infos["/data/4.csv"].ExtendedAttributes())
+
+	vds.cfg.BackupOptions.SkipItemAttributes = true
+	container.itemRequests = 0
+	infos = scan(tst, vds, []string{"/data"}, time.Time{})
+	assert.Nil(tst, infos["/data/1.csv"].ExtendedAttributes())
+	assert.Equal(tst, 2, container.itemRequests)
+
+	vds.cfg.BackupOptions.SkipItemAttributes = false
+	container.failures["GetItems /data/"] = errors.New("connection reset")
+	iter, err := vds.Scan([]string{"/data"}, time.Time{})
+	require.NoError(tst, err)
+	paths(tst, iter)
+	require.Error(tst, iter.Error())
+	assert.Contains(tst, iter.Error().Error(), "Failed to read the attributes")
+}
@@ -0,0 +1,226 @@
+package v3io
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
+)
+
+// Maximal number of records read by a single GetRecords request, and written by a single PutRecords request
+const streamRecordsLimit = 1000
+
+// Number of attempts to write the records rejected by PutRecords, and the delay before the first retry
+// (growing linearly with the attempts)
+const (
+	putRecordsAttempts      = 5
+	putRecordsRetryInterval = 100 * time.Millisecond
+)
+
+// describeStream returns the description of the stream at the given directory, nil if the directory is not a stream
+func (vds *V3ioDataSource) describeStream(dir string) (*backend.StreamInfo, error) {
+	response, err := vds.container.DescribeStreamSync(&v3io.DescribeStreamInput{Path: listingPath(dir)})
+	defer releaseResponse(response)
+
+	if err != nil {
+		if v3ioUtils.IsNotExistsError(err) || v3ioUtils.IsBadRequestError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to describe '%s'.", dir)
+	}
+
+	output, ok := response.Output.(*v3io.DescribeStreamOutput)
+	if !ok {
+		return nil, errors.Errorf("Unexpected response while describing stream '%s'.", dir)
+	}
+
+	return &backend.StreamInfo{
+		ShardCount:           output.ShardCount,
+		RetentionPeriodHours: output.RetentionPeriodHours,
+	}, nil
+}
+
+// ScanStream reads the records of a single shard that arrived after the given time (all the records when zero)
+// up to the records that arrived when the scan started, so a busy stream doesn't keep the scan going
+func (vds *V3ioDataSource) ScanStream(fileInfo *backend.FileInfo, shard int, from time.Time,
+	fn func(records []backend.StreamRecord) error) error {
+
+	until := time.Now()
+	shardPath := shardPath(fileInfo.Path(), shard)
+	location, err := vds.seekShard(shardPath, from)
+	if err != nil {
+		return err
+	}
+
+	for location != "" {
+		response, err := vds.container.GetRecordsSync(&v3io.GetRecordsInput{
+			Path:     shardPath,
+			Location: location,
+			Limit:    streamRecordsLimit,
+		})
+		if err != nil {
+			releaseResponse(response)
+			return errors.Wrapf(err, "Failed to read the records of '%s'.", shardPath)
+		}
+
+		output, ok := response.Output.(*v3io.GetRecordsOutput)
+		if !ok {
+			releaseResponse(response)
+			return errors.Errorf("Unexpected response while reading the records of '%s'.", shardPath)
+		}
+
+		// The response is released once read, keep a copy of the data
+		records := make([]backend.StreamRecord, 0, len(output.Records))
+		caughtUp := output.RecordsBehindLatest == 0
+		for _, record := range output.Records {
+			arrivalTime := time.Unix(int64(record.ArrivalTimeSec), int64(record.ArrivalTimeNSec)).UTC()
+			if arrivalTime.After(until) {
+				caughtUp = true
+				break
+			}
+			if !from.IsZero() && !arrivalTime.After(from) {
+				// Seeking by time is precise to the second, the records of that second that arrived earlier
+				// were already read by the previous backup
+				continue
+			}
+			records = append(records, backend.StreamRecord{
+				SequenceNumber: uint64(record.SequenceNumber),
+				ArrivalTime:    arrivalTime,
+				ClientInfo:     append([]byte{}, record.ClientInfo...),
+				PartitionKey:   record.PartitionKey,
+				Data:           append([]byte{}, record.Data...),
+			})
+		}
+		location = output.NextLocation
+		releaseResponse(response)
+
+		if len(records) > 0 {
+			if err := fn(records); err != nil {
+				return err
+			}
+		}
+		if caughtUp {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (vds *V3ioDataSource) seekShard(shardPath string, from time.Time) (string, error) {
+	input := &v3io.SeekShardInput{Path: shardPath, Type: v3io.SeekShardInputTypeEarliest}
+	if !from.IsZero() {
+		input.Type = v3io.SeekShardInputTypeTime
+		input.Timestamp = int(from.Unix())
+	}
+
+	response, err := vds.container.SeekShardSync(input)
+	defer releaseResponse(response)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to seek '%s'.", shardPath)
+	}
+
+	output, ok := response.Output.(*v3io.SeekShardOutput)
+	if !ok {
+		return "", errors.Errorf("Unexpected response while seeking '%s'.", shardPath)
+	}
+	return output.Location, nil
+}
+
+// CreateStream creates the stream at the given path (restored with PutRecords)
+func (vds *V3ioDataSource) CreateStream(streamPath string, shardCount, retentionPeriodHours int) error {
+	err := vds.container.CreateStreamSync(&v3io.CreateStreamInput{
+		Path:                 listingPath(normalisePath(streamPath)),
+		ShardCount:           shardCount,
+		RetentionPeriodHours: retentionPeriodHours,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create stream '%s'.", streamPath)
+	}
+	return nil
+}
+
+// PutRecords appends the records to the given shard of the stream, in order. The records get new
+// sequence numbers and arrival times. The records rejected by V3IO (e.g. when the shard is throttled)
+// are written again, in their original order, so they may follow the records that were sent after them.
+func (vds *V3ioDataSource) PutRecords(streamPath string, shard int, records []backend.StreamRecord) error {
+	streamPath = listingPath(normalisePath(streamPath))
+	for len(records) > 0 {
+		batch := records
+		if len(batch) > streamRecordsLimit {
+			batch = batch[:streamRecordsLimit]
+		}
+		records = records[len(batch):]
+
+		for attempt := 1; len(batch) > 0; attempt++ {
+			if attempt > 1 {
+				time.Sleep(time.Duration(attempt-1) * putRecordsRetryInterval)
+			}
+			failed, reason, err := vds.putRecords(streamPath, shard, batch)
+			if err != nil {
+				return err
+			}
+			if len(failed) > 0 && attempt == putRecordsAttempts {
+				return errors.Errorf("Failed to write %d records of shard %d of stream '%s' after %d attempts: %s",
+					len(failed), shard, streamPath, attempt, reason)
+			}
+			if len(failed) > 0 {
+				vds.logger.DebugWith("Retrying rejected stream records", "stream", streamPath, "shard", shard,
+					"records", len(failed), "reason", reason)
+			}
+			batch = failed
+		}
+	}
+	return nil
+}
+
+// putRecords writes the records with a single PutRecords request, and returns the records rejected by V3IO
+// along with the reason of the first rejection
+func (vds *V3ioDataSource) putRecords(streamPath string, shard int, records []backend.StreamRecord) ([]backend.StreamRecord, string, error) {
+	input := &v3io.PutRecordsInput{Path: streamPath}
+	for i := range records {
+		input.Records = append(input.Records, &v3io.StreamRecord{
+			ShardID:      &shard,
+			Data:         records[i].Data,
+			ClientInfo:   records[i].ClientInfo,
+			PartitionKey: records[i].PartitionKey,
+		})
+	}
+
+	response, err := vds.container.PutRecordsSync(input)
+	defer releaseResponse(response)
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Failed to write the records of shard %d of stream '%s'.", shard, streamPath)
+	}
+
+	output, ok := response.Output.(*v3io.PutRecordsOutput)
+	if !ok || (output.FailedRecordCount > 0 && len(output.Records) != len(records)) {
+		return nil, "", errors.Errorf("Unexpected response while writing the records of shard %d of stream '%s'.", shard, streamPath)
+	}
+	if output.FailedRecordCount == 0 {
+		return nil, "", nil
+	}
+
+	var failed []backend.StreamRecord
+	var reason string
+	for i, result := range output.Records {
+		if result.ErrorCode == 0 {
+			continue
+		}
+		if failed == nil {
+			reason = fmt.Sprintf("%s (error code %d)", result.ErrorMessage, result.ErrorCode)
+		}
+		failed = append(failed, records[i])
+	}
+	return failed, reason, nil
+}
+
+func shardPath(streamPath string, shard int) string {
+	return path.Join(streamPath, strconv.Itoa(shard))
+}
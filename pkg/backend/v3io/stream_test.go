@@ -0,0 +1,203 @@
+// +build unit
+
+package v3io
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/config"
+)
+
+// streamContainer adds the stream shards to the fake container. The locations are the indexes of the records
+// within their shard, and the records whose data is in rejections are rejected by PutRecords the given number of times.
+type streamContainer struct {
+	*fakeContainer
+	shards      map[string][]v3io.GetRecordsResult
+	rejections  map[string]int
+	putRequests int
+}
+
+func newStreamContainer() *streamContainer {
+	return &streamContainer{
+		fakeContainer: newFakeContainer(2),
+		shards:        make(map[string][]v3io.GetRecordsResult),
+		rejections:    make(map[string]int),
+	}
+}
+
+func (sc *streamContainer) SeekShardSync(input *v3io.SeekShardInput) (*v3io.Response, error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	records, ok := sc.shards[input.Path]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	location := 0
+	if input.Type == v3io.SeekShardInputTypeTime {
+		for location < len(records) && records[location].ArrivalTimeSec < input.Timestamp {
+			location++
+		}
+	}
+	return newResponse(&v3io.SeekShardOutput{Location: strconv.Itoa(location)}, nil), nil
+}
+
+func (sc *streamContainer) GetRecordsSync(input *v3io.GetRecordsInput) (*v3io.Response, error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	records := sc.shards[input.Path]
+	location, err := strconv.Atoi(input.Location)
+	if err != nil || location > len(records) {
+		return nil, errors.Errorf("Invalid location '%s'", input.Location)
+	}
+	end := location + input.Limit
+	if end > len(records) {
+		end = len(records)
+	}
+	return newResponse(&v3io.GetRecordsOutput{
+		NextLocation:        strconv.Itoa(end),
+		RecordsBehindLatest: len(records) - end,
+		Records:             append([]v3io.GetRecordsResult{}, records[location:end]...),
+	}, nil), nil
+}
+
+func (sc *streamContainer) PutRecordsSync(input *v3io.PutRecordsInput) (*v3io.Response, error) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sc.putRequests++
+	if err := sc.failures["PutRecords "+input.Path]; err != nil {
+		return nil, err
+	}
+
+	output := &v3io.PutRecordsOutput{}
+	for _, record := range input.Records {
+		if sc.rejections[string(record.Data)] > 0 {
+			sc.rejections[string(record.Data)]--
+			output.FailedRecordCount++
+			output.Records = append(output.Records, v3io.PutRecordResult{ErrorCode: 503, ErrorMessage: "throttled"})
+			continue
+		}
+		shardPath := path.Join(input.Path, strconv.Itoa(*record.ShardID))
+		sequenceNumber := len(sc.shards[shardPath]) + 1
+		sc.shards[shardPath] = append(sc.shards[shardPath], v3io.GetRecordsResult{
+			SequenceNumber: sequenceNumber,
+			ClientInfo:     record.ClientInfo,
+			PartitionKey:   record.PartitionKey,
+			Data:           record.Data,
+		})
+		output.Records = append(output.Records, v3io.PutRecordResult{SequenceNumber: sequenceNumber, ShardID: *record.ShardID})
+	}
+	return newResponse(output, nil), nil
+}
+
+// shardData returns the data of the records of the shard
+func (sc *streamContainer) shardData(shardPath string) []string {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	var data []string
+	for _, record := range sc.shards[shardPath] {
+		data = append(data, string(record.Data))
+	}
+	return data
+}
+
+func streamRecords(count int) []backend.StreamRecord {
+	records := make([]backend.StreamRecord, count)
+	for i := range records {
+		records[i] = backend.StreamRecord{Data: []byte(fmt.Sprintf("r%d", i)), PartitionKey: "key"}
+	}
+	return records
+}
+
+func TestScanStream(tst *testing.T) {
+	container := newStreamContainer()
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	second := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, offset := range []time.Duration{200, 500, 800, 1100} {
+		arrivalTime := second.Add(offset * time.Millisecond)
+		container.shards["/events/0"] = append(container.shards["/events/0"], v3io.GetRecordsResult{
+			ArrivalTimeSec:  int(arrivalTime.Unix()),
+			ArrivalTimeNSec: arrivalTime.Nanosecond(),
+			SequenceNumber:  i + 1,
+			Data:            []byte(fmt.Sprintf("r%d", i)),
+		})
+	}
+	stream := backend.NewStreamInfo("/events", &objectInfo{name: "events", isDir: true}, &backend.StreamInfo{ShardCount: 1})
+
+	scanStream := func(from time.Time) []uint64 {
+		var sequenceNumbers []uint64
+		err := vds.ScanStream(stream, 0, from, func(records []backend.StreamRecord) error {
+			for _, record := range records {
+				sequenceNumbers = append(sequenceNumbers, record.SequenceNumber)
+			}
+			return nil
+		})
+		require.NoError(tst, err)
+		return sequenceNumbers
+	}
+
+	assert.Equal(tst, []uint64{1, 2, 3, 4}, scanStream(time.Time{}))
+
+	// The seek lands on the first record of the second, the records that arrived earlier are skipped
+	assert.Equal(tst, []uint64{3, 4}, scanStream(second.Add(500*time.Millisecond)))
+	assert.Equal(tst, []uint64{2, 3, 4}, scanStream(second.Add(499*time.Millisecond)))
+	assert.Equal(tst, []uint64{4}, scanStream(second.Add(time.Second)))
+	assert.Empty(tst, scanStream(second.Add(2*time.Second)))
+}
+
+func TestPutRecords(tst *testing.T) {
+	container := newStreamContainer()
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	// The records are split into batches of streamRecordsLimit
+	records := streamRecords(2*streamRecordsLimit + 1)
+	require.NoError(tst, vds.PutRecords("/events", 1, records))
+	assert.Equal(tst, 3, container.putRequests)
+	data := container.shardData("/events/1")
+	require.Len(tst, data, len(records))
+	for i := range records {
+		assert.Equal(tst, string(records[i].Data), data[i])
+	}
+
+	// Only the rejected records are written again, in their original order
+	container.rejections["r1"] = 1
+	container.rejections["r3"] = 2
+	container.putRequests = 0
+	require.NoError(tst, vds.PutRecords("events/", 0, streamRecords(5)))
+	assert.Equal(tst, []string{"r0", "r2", "r4", "r1", "r3"}, container.shardData("/events/0"))
+	assert.Equal(tst, 3, container.putRequests)
+}
+
+func TestPutRecordsFailure(tst *testing.T) {
+	container := newStreamContainer()
+	vds := newTestDataSource(tst, container, &config.Config{})
+
+	// The shard fails once the records were rejected putRecordsAttempts times
+	container.rejections["r1"] = putRecordsAttempts
+	err := vds.PutRecords("/events", 0, streamRecords(3))
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "Failed to write 1 records of shard 0")
+	assert.Contains(tst, err.Error(), "throttled")
+	assert.Equal(tst, putRecordsAttempts, container.putRequests)
+	assert.Equal(tst, []string{"r0", "r2"}, container.shardData("/events/0"))
+
+	connectionReset := errors.New("connection reset")
+	container.failures["PutRecords /events/"] = connectionReset
+	err = vds.PutRecords("/events", 0, streamRecords(3))
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "Failed to write the records of shard 0")
+	assert.Equal(tst, connectionReset, errors.Cause(err))
+}
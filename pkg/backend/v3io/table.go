@@ -0,0 +1,164 @@
+package v3io
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
+)
+
+// Name of the schema file marking a directory as a NoSQL table
+const schemaFileName = ".#schema"
+
+// Maximal number of items read by a single GetItems request
+const getItemsLimit = 1000
+
+// schemaInfo returns the description of the schema file of the given directory, nil if the directory is not a table
+func (vds *V3ioDataSource) schemaInfo(dir string) (*backend.FileInfo, error) {
+	schemaPath := path.Join(dir, schemaFileName)
+	response, err := vds.container.GetItemSync(&v3io.GetItemInput{
+		Path:           schemaPath,
+		AttributeNames: []string{"__size", "__mtime_secs", "__mtime_nsecs"},
+	})
+	defer releaseResponse(response)
+
+	if err != nil {
+		if v3ioUtils.IsNotExistsError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to read the schema of '%s'.", dir)
+	}
+
+	output, ok := response.Output.(*v3io.GetItemOutput)
+	if !ok {
+		return nil, errors.Errorf("Unexpected response while reading the schema of '%s'.", dir)
+	}
+
+	size, err := intAttribute(output.Item, "__size")
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read the schema of '%s'.", dir)
+	}
+	seconds, _ := intAttribute(output.Item, "__mtime_secs")
+	nanoseconds, _ := intAttribute(output.Item, "__mtime_nsecs")
+
+	return backend.NewFileInfo(schemaPath, &objectInfo{
+		name:    schemaFileName,
+		size:    size,
+		modTime: time.Unix(seconds, nanoseconds).UTC(),
+	}, nil), nil
+}
+
+// ScanTable reads the items of a single segment of the table, using GetItems with Segment/TotalSegments
+func (vds *V3ioDataSource) ScanTable(fileInfo *backend.FileInfo, segment, totalSegments int, modifiedAfterTime time.Time,
+	fn func(items []map[string]interface{}) error) error {
+
+	input := &v3io.GetItemsInput{
+		Path:           listingPath(fileInfo.Path()),
+		AttributeNames: []string{backend.ItemNameAttribute, "*"},
+		Filter:         modifiedAfterFilter(modifiedAfterTime),
+		Limit:          getItemsLimit,
+		Segment:        segment,
+		TotalSegments:  totalSegments,
+	}
+
+	for {
+		items, last, nextMarker, err := vds.getItems(input)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read segment %d/%d of table '%s'.", segment, totalSegments, fileInfo.Path())
+		}
+		if len(items) > 0 {
+			if err := fn(items); err != nil {
+				return err
+			}
+		}
+		if last || nextMarker == "" {
+			return nil
+		}
+		input.Marker = nextMarker
+	}
+}
+
+// modifiedAfterFilter returns the GetItems filter expression of the items modified after the given time,
+// empty when zero
+func modifiedAfterFilter(modifiedAfterTime time.Time) string {
+	if modifiedAfterTime.IsZero() {
+		return ""
+	}
+	seconds, nanoseconds := modifiedAfterTime.Unix(), modifiedAfterTime.Nanosecond()
+	return fmt.Sprintf("__mtime_secs > %d or (__mtime_secs == %d and __mtime_nsecs > %d)", seconds, seconds, nanoseconds)
+}
+
+// getItems reads a single page of items. The items are copied, as the response is released once read.
+func (vds *V3ioDataSource) getItems(input *v3io.GetItemsInput) ([]map[string]interface{}, bool, string, error) {
+	response, err := vds.container.GetItemsSync(input)
+	defer releaseResponse(response)
+
+	if err != nil {
+		return nil, false, "", err
+	}
+
+	output, ok := response.Output.(*v3io.GetItemsOutput)
+	if !ok {
+		return nil, false, "", errors.New("Unexpected GetItems response.")
+	}
+
+	items := make([]map[string]interface{}, 0, len(output.Items))
+	for _, item := range output.Items {
+		copied := make(map[string]interface{}, len(item))
+		for name, value := range item {
+			if strings.HasPrefix(name, systemAttributePrefix) && name != backend.ItemNameAttribute {
+				continue
+			}
+			if blob, ok := value.([]byte); ok {
+				value = append([]byte{}, blob...)
+			}
+			copied[name] = value
+		}
+		items = append(items, copied)
+	}
+
+	return items, output.Last, output.NextMarker, nil
+}
+
+// PutItems writes the items into the table at the given path. Every item holds its name in ItemNameAttribute.
+func (vds *V3ioDataSource) PutItems(tablePath string, items []map[string]interface{}) error {
+	for _, item := range items {
+		name, ok := item[backend.ItemNameAttribute].(string)
+		if !ok || name == "" {
+			return errors.Errorf("Item of table '%s' has no name.", tablePath)
+		}
+
+		attributes := make(map[string]interface{}, len(item))
+		for attributeName, value := range item {
+			if attributeName != backend.ItemNameAttribute {
+				attributes[attributeName] = value
+			}
+		}
+
+		if err := vds.SetAttributes(path.Join(tablePath, name), attributes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func intAttribute(item v3io.Item, name string) (int64, error) {
+	switch value := item[name].(type) {
+	case int:
+		return int64(value), nil
+	case int64:
+		return value, nil
+	case float64:
+		return int64(value), nil
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	default:
+		return 0, errors.Errorf("Unexpected value of attribute '%s': %v.", name, item[name])
+	}
+}
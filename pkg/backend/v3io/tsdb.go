@@ -0,0 +1,207 @@
+package v3io
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"v3io-backup/pkg/backend"
+)
+
+// tsdbSchema is the partitioning of a TSDB table, as described by its schema file
+type tsdbSchema struct {
+	PartitionSchemaInfo *struct {
+		PartitionerInterval string `json:"partitionerInterval"`
+	} `json:"partitionSchemaInfo"`
+
+	partitionInterval time.Duration // zero if unknown
+}
+
+// tsdbPartition is a partition directory of a TSDB table, named after the start time of the partition (in seconds)
+type tsdbPartition struct {
+	info  *backend.FileInfo
+	start time.Time
+}
+
+// readTsdbSchema parses the schema file of a table, nil if the table is not a TSDB table
+func (vds *V3ioDataSource) readTsdbSchema(schema *backend.FileInfo) (*tsdbSchema, error) {
+	response, err := vds.container.GetObjectSync(&v3io.GetObjectInput{Path: schema.Path()})
+	defer releaseResponse(response)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read '%s'.", schema.Path())
+	}
+
+	tsdb := &tsdbSchema{}
+	if err := json.Unmarshal(response.Body(), tsdb); err != nil || tsdb.PartitionSchemaInfo == nil {
+		// Not a TSDB schema
+		return nil, nil
+	}
+
+	if tsdb.partitionInterval, err = parseTsdbInterval(tsdb.PartitionSchemaInfo.PartitionerInterval); err != nil {
+		vds.logger.WarnWith("Unknown TSDB partitioning interval, only the latest partition is considered unsealed",
+			"schema", schema.Path(), "interval", tsdb.PartitionSchemaInfo.PartitionerInterval)
+	}
+	return tsdb, nil
+}
+
+// listPartitions lists the partitions of a TSDB table and emits the ones overlapping the configured TSDB range
+// as table entries. Subdirectories that are not partitions are scanned as any other directory.
+func (it *scanIterator) listPartitions(job *dirScan, entries []*backend.FileInfo) {
+	page := &scanPage{entries: entries}
+	from, to, err := it.vds.cfg.BackupOptions.TsdbRange()
+	if err != nil {
+		page.err = err
+		it.sendPage(job, page)
+		return
+	}
+
+	var partitions []tsdbPartition
+	marker := ""
+	for {
+		result, err := it.vds.listPage(job.dir, marker, true)
+		if err != nil {
+			page.err = err
+			it.sendPage(job, page)
+			return
+		}
+
+		for _, prefix := range result.CommonPrefixes {
+			info := it.vds.newDirInfo(prefix)
+			seconds, err := strconv.ParseInt(info.Name(), 10, 64)
+			if err != nil {
+				if err := it.addPrefixes(page, []CommonPrefixes{prefix}); err != nil {
+					page.err = err
+					it.sendPage(job, page)
+					return
+				}
+				continue
+			}
+			partitions = append(partitions, tsdbPartition{info: info, start: time.Unix(seconds, 0).UTC()})
+		}
+
+		if !isTruncated(result) {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].start.Before(partitions[j].start)
+	})
+
+	// The latest partition is never sealed, even once its time range is over, as late samples are still
+	// written into it
+	for i, partition := range partitions {
+		partitionInfo := &backend.PartitionInfo{Start: partition.start}
+		if job.tsdb.partitionInterval > 0 {
+			partitionInfo.End = partition.start.Add(job.tsdb.partitionInterval)
+		} else if i+1 < len(partitions) {
+			partitionInfo.End = partitions[i+1].start
+		}
+		partitionInfo.Sealed = i+1 < len(partitions)
+
+		if !to.IsZero() && !partitionInfo.Start.Before(to) {
+			continue
+		}
+		if !from.IsZero() && !partitionInfo.End.IsZero() && !partitionInfo.End.After(from) {
+			continue
+		}
+		excluded, err := it.vds.filter.Excluded(partition.info, it.vds.hasFile)
+		if err != nil {
+			page.err = err
+			break
+		}
+		if excluded {
+			continue
+		}
+
+		page.entries = append(page.entries,
+			backend.NewPartitionInfo(partition.info.Path(), partition.info.BaseInfo(), partitionInfo))
+	}
+
+	it.sendPage(job, page)
+}
+
+// PartitionStats counts the items of the TSDB partition and finds their latest modification time with GetItems,
+// reading only the system attributes of the items
+func (vds *V3ioDataSource) PartitionStats(fileInfo *backend.FileInfo) (*backend.PartitionStats, error) {
+	input := &v3io.GetItemsInput{
+		Path:           listingPath(fileInfo.Path()),
+		AttributeNames: []string{"__mtime_secs", "__mtime_nsecs"},
+		Limit:          getItemsLimit,
+	}
+
+	stats := &backend.PartitionStats{}
+	for {
+		last, nextMarker, err := vds.addPartitionStats(stats, input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read the items of partition '%s'.", fileInfo.Path())
+		}
+		if last || nextMarker == "" {
+			return stats, nil
+		}
+		input.Marker = nextMarker
+	}
+}
+
+// addPartitionStats adds the items of a single page to the partition stats
+func (vds *V3ioDataSource) addPartitionStats(stats *backend.PartitionStats, input *v3io.GetItemsInput) (bool, string, error) {
+	response, err := vds.container.GetItemsSync(input)
+	defer releaseResponse(response)
+
+	if err != nil {
+		return false, "", err
+	}
+
+	output, ok := response.Output.(*v3io.GetItemsOutput)
+	if !ok {
+		return false, "", errors.New("Unexpected GetItems response.")
+	}
+
+	for _, item := range output.Items {
+		seconds, err := intAttribute(item, "__mtime_secs")
+		if err != nil {
+			return false, "", err
+		}
+		nanoseconds, _ := intAttribute(item, "__mtime_nsecs")
+		if modTime := time.Unix(seconds, nanoseconds).UTC(); modTime.After(stats.LatestModTime) {
+			stats.LatestModTime = modTime
+		}
+		stats.ItemCount++
+	}
+
+	return output.Last, output.NextMarker, nil
+}
+
+// parseTsdbInterval parses the TSDB partitioning intervals, e.g. "2d", "1h" or "1y"
+func parseTsdbInterval(interval string) (time.Duration, error) {
+	interval = strings.TrimSpace(interval)
+	if len(interval) < 2 {
+		return 0, errors.Errorf("Invalid interval '%s'.", interval)
+	}
+
+	var unit time.Duration
+	switch interval[len(interval)-1] {
+	case 'm':
+		unit = time.Minute
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'y':
+		unit = 365 * 24 * time.Hour
+	default:
+		return 0, errors.Errorf("Invalid interval '%s'.", interval)
+	}
+
+	count, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || count <= 0 {
+		return 0, errors.Errorf("Invalid interval '%s'.", interval)
+	}
+	return time.Duration(count) * unit, nil
+}
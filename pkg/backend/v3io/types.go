@@ -6,38 +6,61 @@ import (
 	"time"
 )
 
-type DataSource interface {
-	Connect() error
-	Disconnect() error
-	ListDir(paths []string) (*FileInfoIterator, error)
-	Scan(paths []string, modifiedAfterTime time.Time) (*FileInfoIterator, error)
+type ListBucketResult struct {
+	XMLName        xml.Name `xml:"ListBucketResult"`
+	Name           string   `xml:"Name"`
+	Prefix         string   `xml:"Prefix"`
+	Marker         string   `xml:"Marker"`
+	Delimiter      string   `xml:"Delimiter"`
+	NextMarker     string   `xml:"NextMarker"`
+	MaxKeys        string   `xml:"MaxKeys"`
+	IsTruncated    string   `xml:"IsTruncated"`
+	Contents       []Contents
+	CommonPrefixes []CommonPrefixes
 }
 
-type FileInfo struct {
-	baseInfo           *os.FileInfo
-	extendedAttributes map[string]interface{}
+type Contents struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	LastModified string `xml:"LastModified"`
 }
 
-type FileInfoIterator interface {
-	Next() *FileInfo
-	At() *FileInfo
-	Error() error
+type CommonPrefixes struct {
+	Prefix       string `xml:"Prefix"`
+	LastModified string `xml:"LastModified"`
 }
 
-type ListBucketResult struct {
-	XMLName     xml.Name `xml:"ListBucketResult"`
-	Name        string   `xml:"Name"`
-	Prefix      string   `xml:"Prefix"`
-	Marker      string   `xml:"Marker"`
-	Delimiter   string   `xml:"Delimiter"`
-	NextMarker  string   `xml:"NextMarker"`
-	MaxKeys     string   `xml:"MaxKeys"`
-	IsTruncated string   `xml:"IsTruncated"`
-	Contents    []Contents
+// objectInfo implements os.FileInfo for the objects and directories listed from a V3IO container
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
 }
 
-type Contents struct {
-	Key          string `xml:"Key"`
-	Size         int32  `xml:"Size"`
-	LastModified string `xml:"LastModified"`
+func (oi *objectInfo) Name() string {
+	return oi.name
+}
+
+func (oi *objectInfo) Size() int64 {
+	return oi.size
+}
+
+func (oi *objectInfo) Mode() os.FileMode {
+	if oi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (oi *objectInfo) ModTime() time.Time {
+	return oi.modTime
+}
+
+func (oi *objectInfo) IsDir() bool {
+	return oi.isDir
+}
+
+func (oi *objectInfo) Sys() interface{} {
+	return nil
 }
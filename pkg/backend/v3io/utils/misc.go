@@ -16,4 +16,10 @@ func IsNotExistsError(err error) bool {
 		return true
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// IsBadRequestError returns true if the request was rejected as invalid (e.g. describing a directory that is not a stream)
+func IsBadRequestError(err error) bool {
+	errorWithStatusCode, ok := err.(v3ioerrors.ErrorWithStatusCode)
+	return ok && errorWithStatusCode.StatusCode() == http.StatusBadRequest
+}
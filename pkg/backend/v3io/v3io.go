@@ -4,10 +4,13 @@ import (
 	"github.com/nuclio/errors"
 	"github.com/nuclio/logger"
 	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	"path"
 	"strings"
 	"time"
+	"v3io-backup/pkg/backend"
 	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
 	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/filter"
 	containerUtils "v3io-backup/pkg/utils"
 )
 
@@ -18,6 +21,28 @@ type V3ioDataSource struct {
 	container   v3io.Container
 	HttpTimeout time.Duration
 	cfg         *config.Config
+	filter      *filter.Filter
+}
+
+// SetFilter sets the filter applied to the entries below the scanned paths (the paths themselves are not filtered).
+// The schema files of the tables are always backed up along with the tables.
+func (vds *V3ioDataSource) SetFilter(f *filter.Filter) {
+	vds.filter = f
+}
+
+// hasFile returns true if the directory holds an object with the given name
+func (vds *V3ioDataSource) hasFile(dir string, name string) (bool, error) {
+	p := path.Join(dir, name)
+	response, err := vds.container.GetItemSync(&v3io.GetItemInput{Path: p, AttributeNames: []string{"__size"}})
+	defer releaseResponse(response)
+
+	if err != nil {
+		if v3ioUtils.IsNotExistsError(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "Failed to access '%s'.", p)
+	}
+	return true, nil
 }
 
 func (vds *V3ioDataSource) Connect() error {
@@ -55,37 +80,31 @@ func (vds *V3ioDataSource) Disconnect() error {
 	return nil
 }
 
-func (vds *V3ioDataSource) ListDir(paths []string) (*FileInfoIterator, error) {
-	if vds.cfg.BackupOptions.Paths == nil {
+// ListDir recursively lists the given paths (or the configured backup paths when none given), skipping
+// the entries excluded by the filter. The listing is paginated and the entries are fetched lazily while iterating.
+func (vds *V3ioDataSource) ListDir(paths []string) (backend.FileInfoIterator, error) {
+	if len(paths) == 0 {
+		paths = vds.cfg.BackupOptions.Paths
+	}
+	if len(paths) == 0 {
 		return nil, errors.Errorf("Backup cannot continue without path. Path(s) not set.")
 	}
 
-	// TODO: Implement with async iterator
-	/* Example
-	path := normalisePath(vds.cfg.BackupOptions.Paths[0])
-	resp, err := vds.container.GetContainerContentsSync(&v3io.GetContainerContentsInput{Path: path})
-	defer releaseResponse(resp)
+	return newDirIterator(vds, paths), nil
+}
 
-	if err != nil {
-		if v3ioUtils.IsNotExistsError(err) {
-			return errors.Errorf("File found at container '%s' at path '%s'.", vds.cfg.WebApiEndpoint, path)
-		} else {
-			return errors.Wrapf(err, "Failed to read from '%s/%s%s'.", vds.cfg.WebApiEndpoint, vds.cfg.Container, path)
-		}
+// Scan recursively lists the given paths (or the configured backup paths when none given) using
+// ScannerParallelism concurrent workers, and returns the entries modified after the given time.
+// A zero modifiedAfterTime returns all the entries.
+func (vds *V3ioDataSource) Scan(paths []string, modifiedAfterTime time.Time) (backend.FileInfoIterator, error) {
+	if len(paths) == 0 {
+		paths = vds.cfg.BackupOptions.Paths
+	}
+	if len(paths) == 0 {
+		return nil, errors.Errorf("Scan cannot continue without path. Path(s) not set.")
 	}
 
-	result := ListBucketResult{}
-	xml.Unmarshal(resp.Body(), &result)
-
-	vds.logger.InfoWith("Connect Response", "Result", result, "Rows count", len(result.Contents))
-	*/
-
-	return nil, errors.Errorf("Not implemented: ListDir")
-}
-
-func (vds *V3ioDataSource) Scan(paths []string, modifiedAfterTime time.Time) (*FileInfoIterator, error) {
-	// TODO: Implement with async iterator
-	return nil, errors.Errorf("Not implemented: Scan")
+	return newScanIterator(vds, paths, modifiedAfterTime, vds.cfg.ScannerParallelism), nil
 }
 
 func NewDataSource(cfg *config.Config) (*V3ioDataSource, error) {
@@ -0,0 +1,70 @@
+package v3io
+
+import (
+	"io"
+
+	"github.com/nuclio/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+)
+
+// Size of the parts written to the V3IO objects
+const objectWriteSize = 4 * 1024 * 1024
+
+// objectWriter writes a V3IO object sequentially - the first part creates (or overwrites) the object,
+// and the following parts are appended to it
+type objectWriter struct {
+	vds     *V3ioDataSource
+	path    string
+	buf     []byte
+	written int64
+}
+
+// Create returns a writer of a new object at the given path. The object is complete once the writer is closed.
+func (vds *V3ioDataSource) Create(path string) (io.WriteCloser, error) {
+	return &objectWriter{vds: vds, path: normalisePath(path), buf: make([]byte, 0, objectWriteSize)}, nil
+}
+
+// MkDir creates the directory. V3IO directories are created implicitly along with the objects within them.
+func (vds *V3ioDataSource) MkDir(path string) error {
+	return nil
+}
+
+func (ow *objectWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(ow.buf[len(ow.buf):cap(ow.buf)], p)
+		ow.buf = ow.buf[:len(ow.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(ow.buf) == cap(ow.buf) {
+			if err := ow.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (ow *objectWriter) Close() error {
+	// Always flush on close, so empty objects are created as well
+	if len(ow.buf) > 0 || ow.written == 0 {
+		return ow.flush()
+	}
+	return nil
+}
+
+func (ow *objectWriter) flush() error {
+	err := ow.vds.container.PutObjectSync(&v3io.PutObjectInput{
+		Path:   ow.path,
+		Body:   ow.buf,
+		Append: ow.written > 0,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write '%s' at offset %d.", ow.path, ow.written)
+	}
+
+	ow.written += int64(len(ow.buf))
+	ow.buf = ow.buf[:0]
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package chunker
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Chunk size bounds. The average chunk size is MinSize + 2^averageBits (~1 MiB).
+const (
+	MinSize     = 512 * 1024
+	MaxSize     = 8 * 1024 * 1024
+	averageBits = 19
+
+	// Number of trailing bytes influencing the rolling hash
+	windowSize = 64
+
+	readBufferSize = 512 * 1024
+)
+
+// Chunker splits a stream into content-defined chunks using a gear rolling hash: a chunk ends where the hash
+// of the last 64 bytes has its top bits cleared. Since the boundaries only depend on the local content,
+// an insertion or a deletion only changes the chunks around it, and the following chunks are deduplicated.
+//
+// The gear table is derived from a per-repository seed, so the chunk boundaries (and sizes) of known content
+// can't be predicted without the repository key.
+type Chunker struct {
+	table [256]uint64
+	mask  uint64
+
+	rd  io.Reader
+	buf []byte
+	pos int // position of the next unprocessed byte within buf
+	n   int // number of bytes in buf
+	eof bool
+}
+
+// New returns a chunker reading from rd, with the gear table derived from the seed
+func New(rd io.Reader, seed uint64) *Chunker {
+	c := &Chunker{
+		mask: ^(^uint64(0) >> averageBits),
+		buf:  make([]byte, readBufferSize),
+	}
+
+	// SplitMix64 - a simple generator is enough, the table doesn't need to be cryptographically random
+	state := seed
+	for i := range c.table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		c.table[i] = z ^ (z >> 31)
+	}
+
+	c.Reset(rd)
+	return c
+}
+
+// Reset reuses the chunker (and its buffers) for reading another stream
+func (c *Chunker) Reset(rd io.Reader) {
+	c.rd = rd
+	c.pos = 0
+	c.n = 0
+	c.eof = false
+}
+
+// Next returns the next chunk, appended to data[:0]. Returns io.EOF when the stream is exhausted.
+// The returned slice is only valid until the next call when data is reused.
+func (c *Chunker) Next(data []byte) ([]byte, error) {
+	data = data[:0]
+	var hash uint64
+
+	for {
+		if c.pos >= c.n {
+			if err := c.fill(); err != nil {
+				return nil, err
+			}
+			if c.n == 0 {
+				if len(data) == 0 {
+					return nil, io.EOF
+				}
+				return data, nil
+			}
+		}
+		buf := c.buf[c.pos:c.n]
+
+		// The bytes before the minimum size (and the hash window) can't affect the boundary
+		if skip := MinSize - windowSize - len(data); skip > 0 {
+			if skip > len(buf) {
+				skip = len(buf)
+			}
+			data = append(data, buf[:skip]...)
+			c.pos += skip
+			continue
+		}
+
+		for i, b := range buf {
+			hash = (hash << 1) + c.table[b]
+			size := len(data) + i + 1
+			if size >= MinSize && hash&c.mask == 0 || size >= MaxSize {
+				data = append(data, buf[:i+1]...)
+				c.pos += i + 1
+				return data, nil
+			}
+		}
+
+		data = append(data, buf...)
+		c.pos = c.n
+	}
+}
+
+func (c *Chunker) fill() error {
+	c.pos, c.n = 0, 0
+	if c.eof {
+		return nil
+	}
+
+	n, err := io.ReadFull(c.rd, c.buf)
+	c.n = n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "Failed to read the chunked stream.")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// +build unit
+
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(tst *testing.T, data []byte, seed uint64) [][]byte {
+	var chunks [][]byte
+	c := New(bytes.NewReader(data), seed)
+	for {
+		chunk, err := c.Next(nil)
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(tst, err)
+		chunks = append(chunks, chunk)
+	}
+}
+
+func TestChunkBounds(tst *testing.T) {
+	data := randomData(1, 32*1024*1024)
+	chunks := chunkAll(tst, data, 42)
+
+	assert.Equal(tst, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.True(tst, len(chunk) <= MaxSize)
+		if i < len(chunks)-1 {
+			assert.True(tst, len(chunk) >= MinSize)
+		}
+	}
+
+	average := len(data) / len(chunks)
+	assert.True(tst, average > MinSize && average < 2*1024*1024, "average chunk size %d", average)
+
+	// Uniform content is cut at the maximum size
+	chunks = chunkAll(tst, make([]byte, 2*MaxSize+10), 42)
+	require.Len(tst, chunks, 3)
+	assert.Len(tst, chunks[0], MaxSize)
+	assert.Len(tst, chunks[2], 10)
+
+	assert.Empty(tst, chunkAll(tst, nil, 42))
+}
+
+func TestChunkShiftResistance(tst *testing.T) {
+	data := randomData(2, 16*1024*1024)
+	shifted := append(randomData(3, 1000), data...)
+
+	known := map[string]bool{}
+	for _, chunk := range chunkAll(tst, data, 42) {
+		known[string(chunk)] = true
+	}
+
+	chunks := chunkAll(tst, shifted, 42)
+	reused := 0
+	for _, chunk := range chunks {
+		if known[string(chunk)] {
+			reused++
+		}
+	}
+	assert.True(tst, reused >= len(chunks)-2, "only %d of %d chunks reused", reused, len(chunks))
+
+	// A different seed yields different boundaries
+	assert.NotEqual(tst, len(chunkAll(tst, data, 42)[0]), len(chunkAll(tst, data, 43)[0]))
+}
@@ -23,16 +23,33 @@ package commands
 import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"time"
+	"v3io-backup/pkg/archiver"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/backend/local"
 	"v3io-backup/pkg/backend/v3io"
 	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/filter"
+	"v3io-backup/pkg/repository"
 )
 
 type cmdBackup struct {
-	cmd            *cobra.Command
-	rootCommandeer *CmdRoot
-	paths          []string // comma separated  list of paths to backup the data from in the source container
-	excludeFilters []string // comma separated list of filter expressions to be applied on the file names in given path(s)
-	targetRepo     string   // The destination repository URL
+	cmd                *cobra.Command
+	rootCommandeer     *CmdRoot
+	paths              []string // comma separated  list of paths to backup the data from in the source container
+	excludeFilters     []string // filter expressions to be applied on the paths of the entries in given path(s)
+	includes           []string // filter expressions of the entries to back up even if excluded
+	excludeFiles       []string // files of exclude patterns
+	includeFiles       []string // files of include patterns
+	excludeLargerThan  string   // don't back up the files larger than this size
+	excludeIfPresent   []string // don't back up the directories holding a file with one of these names
+	modifiedAfter      string   // Only backup the entries modified after the given time (incremental backup)
+	tags               []string // tags to add to the snapshot
+	sourceDir          string   // local directory to backup instead of a V3IO container
+	skipItemAttributes bool     // Don't back up the attributes of the V3IO items
+	streamsFrom        string   // back up the stream records that arrived after this time
+	tsdbFrom           string   // back up the TSDB partitions overlapping this time range
+	tsdbTo             string
 }
 
 func newBackupCmd(rootCommandeer *CmdRoot) *cmdBackup {
@@ -57,9 +74,34 @@ instead of using the -s|--server, -u|--username, -p|--password, and -c|--contain
 	cmd.Flags().StringArrayVarP(&commandeer.paths, "paths", "d", []string{"/"},
 		"Paths to backup within the configured\ndata container. Examples: \"/my-data\"; \"/home/user/table-1\".")
 	cmd.Flags().StringArrayVarP(&commandeer.excludeFilters, "excludes", "e", nil,
-		"Comma separated list of filter expressions (RegEx). All matching items will be excluded. Empty by default.")
-	cmd.Flags().StringVarP(&commandeer.targetRepo, "repo", "r", "",
-		"The target backup repository URL")
+		"Filter expression (RegEx) matched against the absolute paths of the entries, may be repeated. All matching entries will be excluded,\n"+
+			"along with the entries of matching directories. Prefix with \""+filter.GlobPrefix+"\" for a gitignore-style glob. Example: \"\\.tmp$\"; \"glob:cache/\".")
+	cmd.Flags().StringArrayVar(&commandeer.includes, "include", nil,
+		"Filter expression (RegEx, or \""+filter.GlobPrefix+"\" glob) of the entries to back up even if excluded, may be repeated.\n"+
+			"The entries of excluded directories can't be included. Example: \"\\.keep$\".")
+	cmd.Flags().StringArrayVar(&commandeer.excludeFiles, "exclude-file", nil,
+		"File of exclude patterns, one per line - gitignore-style globs, or RegEx prefixed with \""+filter.RegexPrefix+"\".\n"+
+			"Lines starting with \"#\" are ignored, and patterns starting with \"!\" include the matching entries.")
+	cmd.Flags().StringArrayVar(&commandeer.includeFiles, "include-file", nil,
+		"File of include patterns, one per line - gitignore-style globs, or RegEx prefixed with \""+filter.RegexPrefix+"\".")
+	cmd.Flags().StringVar(&commandeer.excludeLargerThan, "exclude-larger-than", "",
+		"Exclude the files larger than the given size (K, M, G and T binary units). Example: \"512M\".")
+	cmd.Flags().StringArrayVar(&commandeer.excludeIfPresent, "exclude-if-present", nil,
+		"Exclude the directories holding a file with the given name, may be repeated. Example: \".nobackup\".")
+	cmd.Flags().StringVarP(&commandeer.modifiedAfter, "modified-after", "m", "",
+		"Incremental backup - only items modified after the given time (RFC 3339) are backed up.\nExample: \"2019-05-01T00:00:00Z\".")
+	cmd.Flags().StringSliceVarP(&commandeer.tags, "tags", "t", nil,
+		"Comma separated list of tags to add to the snapshot. Example: \"nightly,prod\".")
+	cmd.Flags().StringVar(&commandeer.sourceDir, "source-dir", "",
+		"Backup a local directory tree instead of a V3IO container.\nThe paths are relative to this directory. Example: \"/mnt/export\".")
+	cmd.Flags().StringVar(&commandeer.streamsFrom, "streams-from", "",
+		"Back up the stream records that arrived after the given time (RFC 3339) - the --modified-after time by default,\nor the earliest records in the shards of the streams when neither is set. Example: \"2019-05-01T00:00:00Z\".")
+	cmd.Flags().StringVar(&commandeer.tsdbFrom, "tsdb-from", "",
+		"Back up only the TSDB partitions holding samples from the given time (RFC 3339) onwards. Example: \"2019-05-01T00:00:00Z\".")
+	cmd.Flags().StringVar(&commandeer.tsdbTo, "tsdb-to", "",
+		"Back up only the TSDB partitions holding samples before the given time (RFC 3339). Example: \"2019-06-01T00:00:00Z\".")
+	cmd.Flags().BoolVar(&commandeer.skipItemAttributes, "skip-item-attributes", false,
+		"Don't back up the attributes of the V3IO items (e.g. NoSQL table items) - only the object contents.")
 
 	commandeer.cmd = cmd
 
@@ -68,12 +110,16 @@ instead of using the -s|--server, -u|--username, -p|--password, and -c|--contain
 
 func (bc *cmdBackup) backup() (error error) {
 
-	if bc.targetRepo == "" {
+	if bc.rootCommandeer.repo == "" {
 		return errors.New("The backup command must receive target repository parameters (set via the -r|--repo flag).")
 	}
 
-	// Initialize parameters and adapter
-	if err := bc.rootCommandeer.initialize(); err != nil {
+	// Initialize parameters and adapter. A local source doesn't require the V3IO connection parameters.
+	if bc.sourceDir != "" {
+		if err := bc.rootCommandeer.initializeConfig(); err != nil {
+			return err
+		}
+	} else if err := bc.rootCommandeer.initialize(); err != nil {
 		return err
 	}
 
@@ -85,27 +131,154 @@ func (bc *cmdBackup) backup() (error error) {
 		bc.rootCommandeer.cfg.BackupOptions.ExcludeFilters = bc.excludeFilters
 	}
 
-	if bc.excludeFilters != nil {
-		bc.rootCommandeer.cfg.BackupOptions.Repository = bc.targetRepo
+	if bc.includes != nil {
+		bc.rootCommandeer.cfg.BackupOptions.Includes = bc.includes
+	}
+	if bc.excludeFiles != nil {
+		bc.rootCommandeer.cfg.BackupOptions.ExcludeFiles = bc.excludeFiles
+	}
+	if bc.includeFiles != nil {
+		bc.rootCommandeer.cfg.BackupOptions.IncludeFiles = bc.includeFiles
+	}
+	if bc.excludeLargerThan != "" {
+		bc.rootCommandeer.cfg.BackupOptions.ExcludeLargerThan = bc.excludeLargerThan
+	}
+	if bc.excludeIfPresent != nil {
+		bc.rootCommandeer.cfg.BackupOptions.ExcludeIfPresent = bc.excludeIfPresent
+	}
+
+	bo := bc.rootCommandeer.cfg.BackupOptions
+	entryFilter, err := filter.New(filter.Options{
+		Excludes:          bo.ExcludeFilters,
+		Includes:          bo.Includes,
+		ExcludeFiles:      bo.ExcludeFiles,
+		IncludeFiles:      bo.IncludeFiles,
+		ExcludeLargerThan: bo.ExcludeLargerThan,
+		ExcludeIfPresent:  bo.ExcludeIfPresent,
+	})
+	if err != nil {
+		return err
+	}
+
+	if bc.skipItemAttributes {
+		bc.rootCommandeer.cfg.BackupOptions.SkipItemAttributes = true
+	}
+
+	if bc.tsdbFrom != "" {
+		bc.rootCommandeer.cfg.BackupOptions.TsdbFrom = bc.tsdbFrom
+	}
+	if bc.tsdbTo != "" {
+		bc.rootCommandeer.cfg.BackupOptions.TsdbTo = bc.tsdbTo
+	}
+	if _, _, err := bc.rootCommandeer.cfg.BackupOptions.TsdbRange(); err != nil {
+		return err
+	}
+
+	var modifiedAfterTime time.Time
+	if bc.modifiedAfter != "" {
+		parsed, err := time.Parse(time.RFC3339, bc.modifiedAfter)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid modification time '%s'.", bc.modifiedAfter)
+		}
+		modifiedAfterTime = parsed
+	}
+
+	var streamsFrom time.Time
+	if bc.streamsFrom != "" {
+		parsed, err := time.Parse(time.RFC3339, bc.streamsFrom)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid stream records time '%s'.", bc.streamsFrom)
+		}
+		streamsFrom = parsed
 	}
 
 	logger := bc.rootCommandeer.logger
-	logger.InfoWith("Backup", "source", bc.rootCommandeer.v3ioUrl, "paths", bc.paths, "filter", bc.excludeFilters,
-		"target repository", bc.targetRepo, "username", bc.rootCommandeer.username, "access-key", bc.rootCommandeer.accessKey, "log-level", bc.rootCommandeer.logLevel)
+	logger.InfoWith("Backup", "source", bc.rootCommandeer.v3ioUrl, "source dir", bc.sourceDir, "paths", bc.paths, "filter", entryFilter.Rules(),
+		"target repository", bc.rootCommandeer.repo, "modified after", bc.modifiedAfter, "tags", bc.tags, "username", bc.rootCommandeer.username, "access-key", bc.rootCommandeer.accessKey, "log-level", bc.rootCommandeer.logLevel)
+
+	repo, err := bc.rootCommandeer.openRepository()
+	if err != nil {
+		return err
+	}
+	if err := repo.LoadIndex(); err != nil {
+		return err
+	}
 
 	bc.rootCommandeer.Reporter.WithTimer("Backup", func() {
-		ds, err := v3io.NewDataSource(bc.rootCommandeer.cfg)
+		ds, opts, err := bc.newDataSource(entryFilter)
+		if err != nil {
+			error = err
+			return
+		}
 
+		// The unchanged sealed TSDB partitions of the previous complete snapshot of the same paths are reused
+		paths := bc.rootCommandeer.cfg.BackupOptions.Paths
+		parent, err := repo.LatestSnapshot(&repository.SnapshotFilter{Containers: []string{opts.Container}, Paths: paths, Complete: true})
 		if err != nil {
 			error = err
+			return
+		}
+		if parent != nil && (parent.Endpoint != opts.Endpoint || len(parent.Paths) != len(paths)) {
+			parent = nil
+		}
+		if parent != nil {
+			logger.InfoWith("Using parent snapshot", "snapshot", parent.ID().Str(), "time", parent.Time)
 		}
 
-		_, err = ds.ListDir(bc.paths)
+		arch := archiver.New(repo, ds, bc.rootCommandeer.cfg, logger)
+		sn, err := arch.Snapshot(archiver.Options{
+			Endpoint:          opts.Endpoint,
+			Container:         opts.Container,
+			Paths:             bc.rootCommandeer.cfg.BackupOptions.Paths,
+			Excludes:          entryFilter.Rules(),
+			Tags:              bc.tags,
+			ModifiedAfterTime: modifiedAfterTime,
+			StreamsFrom:       streamsFrom,
+			Parent:            parent,
+		})
 		if err != nil {
 			error = err
+			return
 		}
 
-		error = nil
+		stats := arch.Stats()
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up files", stats.Files)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up directories", stats.Directories)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up tables", stats.Tables)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up table items", stats.Items)
+		bc.rootCommandeer.Reporter.IncrementCounter("Unchanged TSDB partitions", stats.Partitions)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up streams", stats.Streams)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up stream records", stats.Records)
+		bc.rootCommandeer.Reporter.IncrementCounter("Backed up bytes", stats.Bytes)
+		logger.InfoWith("Backup completed", "snapshot", sn.ID().Str(), "files", stats.Files,
+			"directories", stats.Directories, "tables", stats.Tables, "items", stats.Items, "unchanged partitions", stats.Partitions,
+			"streams", stats.Streams, "records", stats.Records, "bytes", stats.Bytes)
+
+		for _, ruleStats := range entryFilter.Stats() {
+			bc.rootCommandeer.Reporter.IncrementCounter("Excluded by "+ruleStats.Rule, ruleStats.Excluded)
+			logger.InfoWith("Excluded entries", "rule", ruleStats.Rule, "entries", ruleStats.Excluded)
+		}
 	})
 	return
 }
+
+// newDataSource returns the data source to backup (applying the given filter) along with the description of it
+// recorded in the snapshot
+func (bc *cmdBackup) newDataSource(entryFilter *filter.Filter) (backend.DataSource, archiver.Options, error) {
+	cfg := bc.rootCommandeer.cfg
+	if bc.sourceDir != "" {
+		ds, err := local.NewDataSource(bc.sourceDir, bc.rootCommandeer.logger)
+		if err != nil {
+			return nil, archiver.Options{}, err
+		}
+		ds.SetFilter(entryFilter)
+		return ds, archiver.Options{Endpoint: "file://" + ds.Root()}, nil
+	}
+
+	ds, err := v3io.NewDataSource(cfg)
+	if err != nil {
+		return nil, archiver.Options{}, err
+	}
+	ds.SetFilter(entryFilter)
+	return ds, archiver.Options{Endpoint: cfg.WebApiEndpoint, Container: cfg.Container}, nil
+}
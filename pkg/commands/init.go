@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/storage/location"
+)
+
+type cmdInit struct {
+	cmd                  *cobra.Command
+	rootCommandeer       *CmdRoot
+	compression          string
+	compressionAlgorithm string
+}
+
+func newInitCmd(rootCommandeer *CmdRoot) *cmdInit {
+	commandeer := &cmdInit{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "init [flags]",
+		Short: "Initialize a new backup repository",
+		Long: `Create the layout of a new backup repository at the location given by the -r|--repo flag.
+All the repository data is encrypted with a random master key, which is protected by the
+password taken from the --password-file flag, $V3IO_BACKUP_PASSWORD or prompted for.
+Losing the password means losing the data. More passwords can be added with the key command.
+An existing repository is never re-initialized.`,
+		Example: `- V3IO_BACKUP_PASSWORD=secret v3io-backup init -r /backups/my-repo
+- v3io-backup init -r s3:s3.amazonaws.com/my-bucket/my-repo --compression max`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.init()
+		},
+	}
+
+	cmd.Flags().StringVar(&commandeer.compression, "compression", "",
+		"Compression of the repository data - off | auto | max.\n(default - \"auto\", or as set in the configuration file)")
+	cmd.Flags().StringVar(&commandeer.compressionAlgorithm, "compression-algorithm", "",
+		"Compression algorithm - zstd | snappy.\n(default - \"zstd\", or as set in the configuration file)")
+
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (ic *cmdInit) init() error {
+	if ic.rootCommandeer.repo == "" {
+		return errors.New("The init command must receive the repository location (set via the -r|--repo flag).")
+	}
+
+	if err := ic.rootCommandeer.initializeConfig(); err != nil {
+		return err
+	}
+
+	cfg := ic.rootCommandeer.cfg
+	if ic.compression != "" {
+		cfg.Compression = ic.compression
+	}
+	if ic.compressionAlgorithm != "" {
+		cfg.CompressionAlgorithm = ic.compressionAlgorithm
+	}
+
+	password, err := ic.rootCommandeer.newRepositoryPassword()
+	if err != nil {
+		return err
+	}
+
+	backend, err := location.Open(ic.rootCommandeer.repo, cfg)
+	if err != nil {
+		return err
+	}
+
+	repo, err := repository.Init(backend, cfg, password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created v3io-backup repository %s (version %d, compression %s) at '%s'.\n",
+		repo.Config().ID[:8], repo.Config().Version, repo.Config().Compression, repo.Location())
+	return nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"v3io-backup/pkg/repository"
+)
+
+type cmdKey struct {
+	cmd             *cobra.Command
+	rootCommandeer  *CmdRoot
+	newPasswordFile string
+}
+
+func newKeyCmd(rootCommandeer *CmdRoot) *cmdKey {
+	commandeer := &cmdKey{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "key [command] [flags]",
+		Short: "Manage the repository keys",
+		Long: `Manage the key files of the repository. Every key file wraps the same master key with its own password,
+so that each team member or automation job can access the repository with separate credentials.
+The repository password is taken from the --password-file flag, $V3IO_BACKUP_PASSWORD or prompted for.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List the repository keys",
+		Example: `- v3io-backup key list -r /backups/my-repo`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.list()
+		},
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [flags]",
+		Short: "Add a new key (password) to the repository",
+		Example: `- v3io-backup key add -r /backups/my-repo
+- v3io-backup key add -r /backups/my-repo --password-file admin.pass --new-password-file ci.pass`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.add()
+		},
+	}
+
+	removeCmd := &cobra.Command{
+		Aliases: []string{"rm"},
+		Use:     "remove <key ID>",
+		Short:   "Remove a key from the repository",
+		Long: `Remove the key with the given (possibly shortened) ID. The key used for accessing the repository
+can't be removed, so the repository is never left without keys.`,
+		Example: `- v3io-backup key remove -r /backups/my-repo 5c0f8a2e`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.remove(args[0])
+		},
+	}
+
+	passwdCmd := &cobra.Command{
+		Use:     "passwd [flags]",
+		Short:   "Change the password of the current key",
+		Long:    `Replace the key used for accessing the repository with a new key protected by a new password.`,
+		Example: `- v3io-backup key passwd -r /backups/my-repo`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.passwd()
+		},
+	}
+
+	for _, subCmd := range []*cobra.Command{addCmd, passwdCmd} {
+		subCmd.Flags().StringVar(&commandeer.newPasswordFile, "new-password-file", "",
+			"Path to a file holding the new password. The new password is prompted for when not set.")
+	}
+
+	cmd.AddCommand(listCmd, addCmd, removeCmd, passwdCmd)
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (kc *cmdKey) openRepository() (*repository.Repository, error) {
+	if kc.rootCommandeer.repo == "" {
+		return nil, errors.New("The key command must receive the repository location (set via the -r|--repo flag).")
+	}
+
+	if err := kc.rootCommandeer.initializeConfig(); err != nil {
+		return nil, err
+	}
+
+	return kc.rootCommandeer.openRepository()
+}
+
+func (kc *cmdKey) list() error {
+	repo, err := kc.openRepository()
+	if err != nil {
+		return err
+	}
+
+	keys, err := repo.ListKeys()
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, " ID\tUser\tHost\tCreated")
+	for _, k := range keys {
+		current := " "
+		if k.Name() == repo.KeyName() {
+			current = "*"
+		}
+		fmt.Fprintf(writer, "%s%s\t%s\t%s\t%s\n",
+			current,
+			k.Name()[:8],
+			k.Username,
+			k.Hostname,
+			k.Created.Local().Format(time.RFC3339))
+	}
+	writer.Flush()
+	return nil
+}
+
+func (kc *cmdKey) add() error {
+	repo, err := kc.openRepository()
+	if err != nil {
+		return err
+	}
+
+	password, err := newPassword(kc.newPasswordFile)
+	if err != nil {
+		return err
+	}
+
+	k, err := repo.AddKey(password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added key %s.\n", k.Name()[:8])
+	return nil
+}
+
+func (kc *cmdKey) remove(id string) error {
+	repo, err := kc.openRepository()
+	if err != nil {
+		return err
+	}
+
+	if err := repo.RemoveKey(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed key %s.\n", id)
+	return nil
+}
+
+func (kc *cmdKey) passwd() error {
+	repo, err := kc.openRepository()
+	if err != nil {
+		return err
+	}
+
+	password, err := newPassword(kc.newPasswordFile)
+	if err != nil {
+		return err
+	}
+
+	k, err := repo.ChangePassword(password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Changed the password, the new key is %s.\n", k.Name()[:8])
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/utils"
+)
+
+// repositoryPassword returns the password of the repository keys, taken from (in order of precedence)
+// the --password-file flag, $V3IO_BACKUP_PASSWORD or an interactive prompt
+func (rc *CmdRoot) repositoryPassword() (string, error) {
+	if rc.passwdFile != "" {
+		return readPasswordFile(rc.passwdFile)
+	}
+	if password := os.Getenv(passwordEnvironmentVariable); password != "" {
+		return password, nil
+	}
+	if utils.StdinIsTerminal() {
+		return utils.ReadPassword("Enter repository password: ")
+	}
+	return "", errors.Errorf("The repository password must be set (via the --password-file flag or $%s).", passwordEnvironmentVariable)
+}
+
+// newRepositoryPassword returns the password of a new repository. Same as repositoryPassword,
+// but a prompted password must be confirmed.
+func (rc *CmdRoot) newRepositoryPassword() (string, error) {
+	if rc.passwdFile != "" || os.Getenv(passwordEnvironmentVariable) != "" {
+		return rc.repositoryPassword()
+	}
+	return newPassword("")
+}
+
+// newPassword returns the password of a new key, read from the given file or prompted for twice
+func newPassword(passwordFile string) (string, error) {
+	if passwordFile != "" {
+		return readPasswordFile(passwordFile)
+	}
+	if !utils.StdinIsTerminal() {
+		return "", errors.New("The new password must be set (via a password file) when the standard input is not a terminal.")
+	}
+
+	password, err := utils.ReadPassword("Enter new repository password: ")
+	if err != nil {
+		return "", err
+	}
+	confirmation, err := utils.ReadPassword("Enter the password again: ")
+	if err != nil {
+		return "", err
+	}
+	if password != confirmation {
+		return "", errors.New("The passwords do not match.")
+	}
+	return password, nil
+}
+
+// readPasswordFile returns the first line of the file
+func readPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to open the password file '%s'.", path)
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Errorf("The password file '%s' is empty.", path)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.Errorf("The password file '%s' is empty.", path)
+	}
+	return password, nil
+}
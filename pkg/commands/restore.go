@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"v3io-backup/pkg/backend/v3io"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/restorer"
+)
+
+type cmdRestore struct {
+	cmd             *cobra.Command
+	rootCommandeer  *CmdRoot
+	targetContainer string   // the container to restore into; default - the container of the snapshot
+	targetPath      string   // the path within the target container to restore into
+	includes        []string // patterns of the entries to restore
+	excludes        []string // patterns of the entries to skip
+	parallelism     int      // number of objects restored concurrently
+	incremental     bool     // allow restoring an incremental snapshot on top of a previous restore
+}
+
+func newRestoreCmd(rootCommandeer *CmdRoot) *cmdRestore {
+	commandeer := &cmdRestore{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Aliases: []string{"rs"},
+		Use:     "restore <snapshot ID> [flags]",
+		Short:   "Restore a snapshot into a V3IO data container",
+		Long: `Restore the objects of a snapshot from the backup repository into a V3IO data container.
+The snapshot ID may be shortened to any unique prefix, or set to "latest" for the most recent snapshot.
+The latest snapshot is the most recent complete one, unless --incremental is set.`,
+		Example: `The examples assume that the endpoint of the web-gateway service and the login credentials are configured in
+the default configuration file instead of using the -s|--server, -u|--username and -p|--password flags.
+- v3io-backup restore latest -r /backups/my-repo
+- v3io-backup restore 4f2a91c0 -r /backups/my-repo --target-container bigdata-dr --target-path /restored --include "/tables/*"`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.restore(args[0])
+		},
+	}
+
+	cmd.Flags().StringVar(&commandeer.targetContainer, "target-container", "",
+		"The data container to restore into. Default: the container the snapshot was taken from.")
+	cmd.Flags().StringVar(&commandeer.targetPath, "target-path", "/",
+		"The path within the target container to restore into.")
+	cmd.Flags().StringArrayVarP(&commandeer.includes, "include", "i", nil,
+		"Restore only the entries matching the given pattern (glob). Can be set multiple times.")
+	cmd.Flags().StringArrayVarP(&commandeer.excludes, "exclude", "e", nil,
+		"Skip the entries matching the given pattern (glob). Can be set multiple times.")
+	cmd.Flags().IntVar(&commandeer.parallelism, "parallelism", 0,
+		"Number of objects restored concurrently. Default: the configured scanner parallelism.")
+	cmd.Flags().BoolVar(&commandeer.incremental, "incremental", false,
+		"Allow restoring an incremental snapshot (taken with --modified-after). It only holds the modified entries,\n"+
+			"so it should be applied on top of a restored complete snapshot.")
+
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (rc *cmdRestore) restore(snapshotID string) (error error) {
+	if rc.rootCommandeer.repo == "" {
+		return errors.New("The restore command must receive the repository location (set via the -r|--repo flag).")
+	}
+
+	if err := rc.rootCommandeer.initializeConfig(); err != nil {
+		return err
+	}
+
+	repo, err := rc.rootCommandeer.openRepository()
+	if err != nil {
+		return err
+	}
+	if err := repo.LoadIndex(); err != nil {
+		return err
+	}
+
+	// Unless restoring incremental snapshots, the latest snapshot is the latest complete one
+	id, err := repo.FindSnapshot(snapshotID, &repository.SnapshotFilter{Complete: !rc.incremental})
+	if err != nil {
+		return err
+	}
+	sn, err := repo.LoadSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	targetCfg := *rc.rootCommandeer.cfg
+	if rc.targetContainer != "" {
+		targetCfg.Container = rc.targetContainer
+	} else {
+		targetCfg.Container = sn.Container
+	}
+	if targetCfg.WebApiEndpoint == "" {
+		return errors.New("web API endpoint must be set")
+	}
+	if targetCfg.Container == "" {
+		return errors.New("target container must be set")
+	}
+
+	parallelism := rc.parallelism
+	if parallelism <= 0 {
+		parallelism = targetCfg.ScannerParallelism
+	}
+
+	logger := rc.rootCommandeer.logger
+	logger.InfoWith("Restore", "snapshot", id.Str(), "repository", rc.rootCommandeer.repo, "target container", targetCfg.Container,
+		"target path", rc.targetPath, "includes", rc.includes, "excludes", rc.excludes, "parallelism", parallelism, "incremental", sn.Incremental())
+
+	rc.rootCommandeer.Reporter.WithTimer("Restore", func() {
+		target, err := v3io.NewDataSource(&targetCfg)
+		if err != nil {
+			error = err
+			return
+		}
+
+		res, err := restorer.New(repo, target, restorer.Options{
+			TargetPath:  rc.targetPath,
+			Includes:    rc.includes,
+			Excludes:    rc.excludes,
+			Parallelism: parallelism,
+			Incremental: rc.incremental,
+		}, logger)
+		if err != nil {
+			error = err
+			return
+		}
+
+		if err := res.Restore(sn); err != nil {
+			error = err
+			return
+		}
+
+		stats := res.Stats()
+		rc.rootCommandeer.Reporter.IncrementCounter("Restored files", stats.Files)
+		rc.rootCommandeer.Reporter.IncrementCounter("Restored table items", stats.Items)
+		rc.rootCommandeer.Reporter.IncrementCounter("Restored stream records", stats.Records)
+		rc.rootCommandeer.Reporter.IncrementCounter("Restored bytes", stats.Bytes)
+		logger.InfoWith("Restore completed", "snapshot", id.Str(), "files", stats.Files,
+			"directories", stats.Directories, "items", stats.Items, "records", stats.Records, "bytes", stats.Bytes)
+	})
+	return
+}
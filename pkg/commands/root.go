@@ -27,12 +27,20 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 	"net/url"
+	"os"
 	"strings"
 	"v3io-backup/internal/pkg/performance"
 	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/storage/location"
 	"v3io-backup/pkg/utils"
 )
 
+const (
+	repositoryEnvironmentVariable = "V3IO_REPOSITORY"
+	passwordEnvironmentVariable   = "V3IO_BACKUP_PASSWORD"
+)
+
 type CmdRoot struct {
 	logger      logger.Logger
 	cfg         *config.Config
@@ -44,6 +52,8 @@ type CmdRoot struct {
 	username    string
 	password    string
 	accessKey   string
+	repo        string
+	passwdFile  string
 	Reporter    *performance.MetricReporter
 	BuildInfo   *config.BuildInfo
 }
@@ -74,13 +84,22 @@ func NewCmdRoot() (*CmdRoot, error) {
 		"Password of the configured user (see -u|--username).")
 	cmd.PersistentFlags().StringVarP(&commandeer.accessKey, "access-key", "k", "",
 		"Access-key for accessing the required table.\nIf access-key is passed, it will take precedence on user/password authentication.")
+	cmd.PersistentFlags().StringVarP(&commandeer.repo, "repo", "r", os.Getenv(repositoryEnvironmentVariable),
+		"The backup repository location (default: $"+repositoryEnvironmentVariable+").\nExamples: \"/backups/my-repo\"; \"local:/backups/my-repo\";\n\"s3:s3.amazonaws.com/my-bucket/my-repo\"; \"v3io://192.168.1.100:8081/backups/my-repo\";\n\"sftp:backup@bastion:/srv/backups/my-repo\"; \"rest:https://backup-host:8000/my-repo\".")
+	cmd.PersistentFlags().StringVar(&commandeer.passwdFile, "password-file", "",
+		"Path to a file holding the repository password. When neither this flag\nnor $"+passwordEnvironmentVariable+" is set, the password is prompted for.")
 
 	commandeer.cmd = cmd
 
 	// Add children
 	cmd.AddCommand(
 		newVersionCmd(commandeer).cmd,
+		newInitCmd(commandeer).cmd,
 		newBackupCmd(commandeer).cmd,
+		newSnapshotsCmd(commandeer).cmd,
+		newRestoreCmd(commandeer).cmd,
+		newServeCmd(commandeer).cmd,
+		newKeyCmd(commandeer).cmd,
 	)
 
 	return commandeer, nil
@@ -101,7 +120,23 @@ func (rc *CmdRoot) CreateMarkdown(path string) error {
 	return doc.GenMarkdownTree(rc.cmd, path)
 }
 
+// Initialize the configuration of commands that read from the V3IO data container
 func (rc *CmdRoot) initialize() error {
+	if err := rc.initializeConfig(); err != nil {
+		return err
+	}
+
+	if rc.cfg.WebApiEndpoint == "" {
+		return errors.New("web API endpoint must be set")
+	}
+	if rc.cfg.Container == "" {
+		return errors.New("container must be set")
+	}
+	return nil
+}
+
+// Initialize the configuration of commands that only access the backup repository
+func (rc *CmdRoot) initializeConfig() error {
 	cfg, err := config.GetOrLoadFromFile(rc.cfgFilePath)
 	if err != nil {
 		// Display an error if we fail to load a configuration file
@@ -137,11 +172,8 @@ func (rc *CmdRoot) populateConfig(cfg *config.Config) error {
 	if rc.container != "" {
 		cfg.Container = rc.container
 	}
-	if cfg.WebApiEndpoint == "" {
-		return errors.New("web API endpoint must be set")
-	}
-	if cfg.Container == "" {
-		return errors.New("container must be set")
+	if rc.repo != "" {
+		cfg.BackupOptions.Repository = rc.repo
 	}
 	if rc.logLevel != "" {
 		cfg.LogLevel = rc.logLevel
@@ -157,15 +189,31 @@ func (rc *CmdRoot) populateConfig(cfg *config.Config) error {
 		rc.logger = newLogger
 	}
 	// Prefix http:// in case that WebApiEndpoint is a pseudo-URL missing a scheme (for backward compatibility).
-	amendedWebApiEndpoint, err := buildUrl(cfg.WebApiEndpoint)
-	if err == nil {
-		cfg.WebApiEndpoint = amendedWebApiEndpoint
+	if cfg.WebApiEndpoint != "" {
+		amendedWebApiEndpoint, err := buildUrl(cfg.WebApiEndpoint)
+		if err == nil {
+			cfg.WebApiEndpoint = amendedWebApiEndpoint
+		}
 	}
 
 	rc.cfg = cfg
 	return nil
 }
 
+// openRepository opens the repository at the location given by the -r|--repo flag
+func (rc *CmdRoot) openRepository() (*repository.Repository, error) {
+	password, err := rc.repositoryPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := location.Open(rc.repo, rc.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return repository.Open(backend, rc.cfg, password)
+}
+
 func buildUrl(webApiEndpoint string) (string, error) {
 	if !strings.HasPrefix(webApiEndpoint, "http://") && !strings.HasPrefix(webApiEndpoint, "https://") {
 		webApiEndpoint = "http://" + webApiEndpoint
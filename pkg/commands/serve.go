@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"v3io-backup/pkg/storage/location"
+	"v3io-backup/pkg/storage/rest"
+)
+
+const servePasswordEnvironmentVariable = "V3IO_BACKUP_SERVE_PASSWORD"
+
+type cmdServe struct {
+	cmd            *cobra.Command
+	rootCommandeer *CmdRoot
+	listen         string
+	appendOnly     bool
+	authUser       string
+	authPassword   string
+	tlsCert        string
+	tlsKey         string
+	pathPrefix     string
+	maxFileSize    int64
+}
+
+// Files larger than this many times the pack size limit are rejected by default - a pack is only sealed
+// once it exceeds the limit, and it may end with a single large blob (e.g. the tree of a huge directory)
+const serveMaxFileSizeFactor = 4
+
+func newServeCmd(rootCommandeer *CmdRoot) *cmdServe {
+	commandeer := &cmdServe{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "serve [flags]",
+		Short: "Serve a repository over the REST protocol",
+		Long: `Expose the repository given by the -r|--repo flag over HTTP, so that it can be accessed remotely
+as a "rest:http[s]://<host>:<port>" repository. In append-only mode, the clients can add data
+to the repository but can't delete or overwrite any of it (except for the repository locks).`,
+		Example: `- v3io-backup serve -r /backups/my-repo --listen :8000 --append-only
+- V3IO_BACKUP_SERVE_PASSWORD=secret v3io-backup serve -r /backups/my-repo --auth-user backup --tls-cert cert.pem --tls-key key.pem`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.serve()
+		},
+	}
+
+	cmd.Flags().StringVar(&commandeer.listen, "listen", ":8000",
+		"The address to listen on, of the format \"[<IP address>]:<port number>\".")
+	cmd.Flags().BoolVar(&commandeer.appendOnly, "append-only", false,
+		"Reject the deletion and the overwriting of the repository data.")
+	cmd.Flags().StringVar(&commandeer.authUser, "auth-user", "",
+		"Require HTTP basic authentication with the given username.")
+	cmd.Flags().StringVar(&commandeer.authPassword, "auth-password", os.Getenv(servePasswordEnvironmentVariable),
+		"The password of the basic authentication (see --auth-user)\n(default: $"+servePasswordEnvironmentVariable+").")
+	cmd.Flags().StringVar(&commandeer.tlsCert, "tls-cert", "",
+		"Path to a TLS certificate file. Serve over HTTPS when set (requires --tls-key).")
+	cmd.Flags().StringVar(&commandeer.tlsKey, "tls-key", "",
+		"Path to the private key file of the TLS certificate (see --tls-cert).")
+	cmd.Flags().StringVar(&commandeer.pathPrefix, "path-prefix", "",
+		"Serve the repository under the given path, for the \"rest:http[s]://<host>:<port>/<path>\" clients. Example: \"/repo\".")
+	cmd.Flags().Int64Var(&commandeer.maxFileSize, "max-file-size", 0,
+		"Reject the uploaded files larger than the given number of bytes.\n(default: 4 times the configured packFileSizeLimit)")
+
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (sc *cmdServe) serve() error {
+	if sc.rootCommandeer.repo == "" {
+		return errors.New("The serve command must receive the repository location (set via the -r|--repo flag).")
+	}
+	if sc.authUser != "" && sc.authPassword == "" {
+		return errors.Errorf("Basic authentication requires a password (set via the --auth-password flag or $%s).", servePasswordEnvironmentVariable)
+	}
+	if (sc.tlsCert == "") != (sc.tlsKey == "") {
+		return errors.New("Both --tls-cert and --tls-key must be set to serve over HTTPS.")
+	}
+
+	if err := sc.rootCommandeer.initializeConfig(); err != nil {
+		return err
+	}
+
+	backend, err := location.Open(sc.rootCommandeer.repo, sc.rootCommandeer.cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	maxFileSize := sc.maxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = serveMaxFileSizeFactor * int64(sc.rootCommandeer.cfg.PackFileSizeLimit)
+	}
+
+	server := rest.NewServer(backend, rest.ServerOptions{
+		AppendOnly:  sc.appendOnly,
+		Username:    sc.authUser,
+		Password:    sc.authPassword,
+		PathPrefix:  sc.pathPrefix,
+		MaxFileSize: maxFileSize,
+	}, sc.rootCommandeer.logger)
+
+	sc.rootCommandeer.logger.InfoWith("Serving repository",
+		"location", backend.Location(),
+		"listen", sc.listen,
+		"pathPrefix", sc.pathPrefix,
+		"maxFileSize", maxFileSize,
+		"appendOnly", sc.appendOnly,
+		"tls", sc.tlsCert != "")
+
+	if sc.tlsCert != "" {
+		err = http.ListenAndServeTLS(sc.listen, sc.tlsCert, sc.tlsKey, server)
+	} else {
+		err = http.ListenAndServe(sc.listen, server)
+	}
+	return errors.Wrapf(err, "Failed to serve on '%s'.", sc.listen)
+}
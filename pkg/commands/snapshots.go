@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"v3io-backup/pkg/repository"
+)
+
+type cmdSnapshots struct {
+	cmd            *cobra.Command
+	rootCommandeer *CmdRoot
+	hosts          []string
+	paths          []string
+	tags           []string
+	asJson         bool
+}
+
+// Snapshot representation of the JSON output
+type snapshotJson struct {
+	*repository.Snapshot
+	ID      string `json:"id"`
+	ShortID string `json:"short_id"`
+}
+
+func newSnapshotsCmd(rootCommandeer *CmdRoot) *cmdSnapshots {
+	commandeer := &cmdSnapshots{
+		rootCommandeer: rootCommandeer,
+	}
+
+	cmd := &cobra.Command{
+		Aliases: []string{"snap"},
+		Use:     "snapshots [flags]",
+		Short:   "List the snapshots stored in the repository",
+		Long: `List the snapshots stored in the backup repository, optionally filtered by host, path, tag
+and data container (set via the -c|--container flag)`,
+		Example: `- v3io-backup snapshots -r /backups/my-repo
+- v3io-backup snapshots -r /backups/my-repo -c bigdata --tag nightly --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commandeer.snapshots()
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&commandeer.hosts, "host", nil,
+		"Only list the snapshots taken on the given host(s).")
+	cmd.Flags().StringSliceVar(&commandeer.paths, "path", nil,
+		"Only list the snapshots including all the given path(s).")
+	cmd.Flags().StringSliceVar(&commandeer.tags, "tag", nil,
+		"Only list the snapshots having all the given tag(s).")
+	cmd.Flags().BoolVar(&commandeer.asJson, "json", false,
+		"Print the snapshots in JSON format.")
+
+	commandeer.cmd = cmd
+
+	return commandeer
+}
+
+func (sc *cmdSnapshots) snapshots() error {
+	if sc.rootCommandeer.repo == "" {
+		return errors.New("The snapshots command must receive the repository location (set via the -r|--repo flag).")
+	}
+
+	if err := sc.rootCommandeer.initializeConfig(); err != nil {
+		return err
+	}
+
+	repo, err := sc.rootCommandeer.openRepository()
+	if err != nil {
+		return err
+	}
+
+	all, err := repo.ListSnapshots()
+	if err != nil {
+		return err
+	}
+
+	filter := repository.SnapshotFilter{
+		Hosts: sc.hosts,
+		Paths: sc.paths,
+		Tags:  sc.tags,
+	}
+	if sc.rootCommandeer.container != "" {
+		filter.Containers = []string{sc.rootCommandeer.container}
+	}
+
+	var snapshots []*repository.Snapshot
+	for _, sn := range all {
+		if filter.Matches(sn) {
+			snapshots = append(snapshots, sn)
+		}
+	}
+
+	if sc.asJson {
+		return printSnapshotsJson(snapshots)
+	}
+	printSnapshotsTable(snapshots)
+	return nil
+}
+
+func printSnapshotsJson(snapshots []*repository.Snapshot) error {
+	output := make([]snapshotJson, 0, len(snapshots))
+	for _, sn := range snapshots {
+		output = append(output, snapshotJson{Snapshot: sn, ID: sn.ID().String(), ShortID: sn.ID().Str()})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return errors.Wrap(err, "Failed to encode the snapshots.")
+	}
+	return nil
+}
+
+func printSnapshotsTable(snapshots []*repository.Snapshot) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTime\tHost\tContainer\tTags\tPaths\tModified after")
+	for _, sn := range snapshots {
+		// Incremental snapshots only hold the entries modified after the given time
+		modifiedAfter := "-"
+		if sn.Incremental() {
+			modifiedAfter = sn.ModifiedAfter.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			sn.ID().Str(),
+			sn.Time.Local().Format(time.RFC3339),
+			sn.Hostname,
+			sn.Container,
+			strings.Join(sn.Tags, ","),
+			strings.Join(sn.Paths, ","),
+			modifiedAfter)
+	}
+	writer.Flush()
+	fmt.Printf("%d snapshots\n", len(snapshots))
+}
@@ -27,6 +27,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ghodss/yaml"
 	"github.com/imdario/mergo"
@@ -41,6 +42,12 @@ const (
 
 	defaultScannerParallelism = 16
 	defaultTimeoutInSeconds   = 24 * 60 * 60 // 24 hours
+	defaultPackFileSizeLimit  = 16 * 1024 * 1024
+	defaultIndexFileSizeLimit = 4 * 1024 * 1024
+	defaultS3Region           = "us-east-1"
+	defaultS3PartSize         = 16 * 1024 * 1024
+	defaultCompression        = "auto"
+	defaultCompressionAlgo    = "zstd"
 )
 
 type BuildInfo struct {
@@ -56,6 +63,40 @@ type BackupOptions struct {
 	Paths          Paths  `json:"paths"`
 	ExcludeFilters Paths  `json:"excludeFilters"`
 	Repository     string `json:"repository"`
+	// Don't read the attributes of the V3IO items (faster when backing up plain objects)
+	SkipItemAttributes bool `json:"skipItemAttributes,omitempty"`
+	// Back up the TSDB partitions overlapping this time range (RFC 3339, empty times leave the range open)
+	TsdbFrom string `json:"tsdbFrom,omitempty"`
+	TsdbTo   string `json:"tsdbTo,omitempty"`
+	// Back up the entries matching these patterns even if excluded (see the filter package for the syntax)
+	Includes Paths `json:"includes,omitempty"`
+	// Files of exclude and include patterns, one per line
+	ExcludeFiles Paths `json:"excludeFiles,omitempty"`
+	IncludeFiles Paths `json:"includeFiles,omitempty"`
+	// Exclude the files larger than this size, e.g. "512M"
+	ExcludeLargerThan string `json:"excludeLargerThan,omitempty"`
+	// Exclude the directories holding a file with any of these names, e.g. ".nobackup"
+	ExcludeIfPresent Paths `json:"excludeIfPresent,omitempty"`
+}
+
+// TsdbRange returns the parsed TSDB time range, zero times for the open ends
+func (bo *BackupOptions) TsdbRange() (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if bo.TsdbFrom != "" {
+		if from, err = time.Parse(time.RFC3339, bo.TsdbFrom); err != nil {
+			return from, to, errors.Wrapf(err, "Invalid TSDB range start '%s'.", bo.TsdbFrom)
+		}
+	}
+	if bo.TsdbTo != "" {
+		if to, err = time.Parse(time.RFC3339, bo.TsdbTo); err != nil {
+			return from, to, errors.Wrapf(err, "Invalid TSDB range end '%s'.", bo.TsdbTo)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, errors.Errorf("Invalid TSDB range - '%s' is not before '%s'.", bo.TsdbFrom, bo.TsdbTo)
+	}
+	return from, to, nil
 }
 
 func (bi *BuildInfo) String() string {
@@ -108,16 +149,57 @@ type Config struct {
 	ScannerParallelism int `json:"scannerParallelism"`
 	// Default timeout duration, in seconds; default = 3,600 seconds (1 hour)
 	DefaultTimeoutInSeconds int `json:"defaultTimeoutInSeconds,omitempty"`
-	// Desired size of single index file
+	// Desired size of single index file, in bytes; default = 4 MiB
 	IndexFileSizeLimit int `json:"indexFileSizeLimit,omitempty"`
-	// Desired size of single pack file
+	// Desired size of single pack file, in bytes; default = 16 MiB
 	PackFileSizeLimit int `json:"packFileSizeLimit,omitempty"`
+	// Blob compression of new repositories - "off" | "auto" | "max"; default = "auto"
+	Compression string `json:"compression,omitempty"`
+	// Blob compression algorithm of new repositories - "zstd" | "snappy"; default = "zstd"
+	CompressionAlgorithm string `json:"compressionAlgorithm,omitempty"`
 	// Metrics-reporter configuration
 	MetricsReporter MetricsReporterConfig `json:"performance,omitempty"`
 	// Build Info
 	BuildInfo *BuildInfo `json:"buildInfo,omitempty"`
 	// Backup Options
 	BackupOptions BackupOptions `json:"backupOptions,omitempty"`
+	// S3 repository backend configuration
+	S3 S3Config `json:"s3,omitempty"`
+	// V3IO repository backend configuration
+	V3ioRepository V3ioRepositoryConfig `json:"v3ioRepository,omitempty"`
+	// SFTP repository backend configuration
+	Sftp SftpConfig `json:"sftp,omitempty"`
+}
+
+type S3Config struct {
+	// S3 region of the repository bucket; default = "us-east-1"
+	Region string `json:"region,omitempty"`
+	// Credentials; default = $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN
+	AccessKeyID     string `json:"accessKeyId,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+	// Address the bucket as part of the path ("<endpoint>/<bucket>") rather than the host name ("<bucket>.<endpoint>").
+	// Required by most S3-compatible stores (MinIO, Ceph, etc.)
+	PathStyle bool `json:"pathStyle,omitempty"`
+	// Files larger than this are uploaded in parts of this size, in bytes; default = 16 MiB (minimum = 5 MiB)
+	PartSize int `json:"partSize,omitempty"`
+}
+
+type V3ioRepositoryConfig struct {
+	// Credentials of the repository container, distinct from the credentials of the backed up (source) container;
+	// default = $V3IO_REPOSITORY_USERNAME, $V3IO_REPOSITORY_PASSWORD and $V3IO_REPOSITORY_ACCESS_KEY
+	Username  string `json:"username,omitempty"`
+	Password  string `json:"password,omitempty"`
+	AccessKey string `json:"accessKey,omitempty"`
+}
+
+type SftpConfig struct {
+	// Private key file used to authenticate; default = the first of ~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa
+	IdentityFile string `json:"identityFile,omitempty"`
+	// File of the trusted host keys used to verify the server; default = ~/.ssh/known_hosts
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
+	// Timeout of establishing the SSH connection; default = 30s
+	ConnectTimeout string `json:"connectTimeout,omitempty"`
 }
 
 type MetricsReporterConfig struct {
@@ -193,6 +275,18 @@ func (config Config) String() string {
 	if config.AccessKey != "" {
 		config.AccessKey = "SANITIZED"
 	}
+	if config.S3.SecretAccessKey != "" {
+		config.S3.SecretAccessKey = "SANITIZED"
+	}
+	if config.S3.SessionToken != "" {
+		config.S3.SessionToken = "SANITIZED"
+	}
+	if config.V3ioRepository.Password != "" {
+		config.V3ioRepository.Password = "SANITIZED"
+	}
+	if config.V3ioRepository.AccessKey != "" {
+		config.V3ioRepository.AccessKey = "SANITIZED"
+	}
 
 	sanitizedConfigJson, err := json.Marshal(&config)
 	if err == nil {
@@ -281,6 +375,22 @@ func initDefaults(cfg *Config) {
 		cfg.DefaultTimeoutInSeconds = int(defaultTimeoutInSeconds)
 	}
 
+	if cfg.PackFileSizeLimit == 0 {
+		cfg.PackFileSizeLimit = defaultPackFileSizeLimit
+	}
+
+	if cfg.IndexFileSizeLimit == 0 {
+		cfg.IndexFileSizeLimit = defaultIndexFileSizeLimit
+	}
+
+	if cfg.Compression == "" {
+		cfg.Compression = defaultCompression
+	}
+
+	if cfg.CompressionAlgorithm == "" {
+		cfg.CompressionAlgorithm = defaultCompressionAlgo
+	}
+
 	if cfg.WebApiEndpoint == "" {
 		cfg.WebApiEndpoint = os.Getenv("V3IO_API")
 	}
@@ -297,6 +407,42 @@ func initDefaults(cfg *Config) {
 		cfg.Password = os.Getenv("V3IO_PASSWORD")
 	}
 
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = defaultS3Region
+	}
+
+	if cfg.S3.AccessKeyID == "" {
+		cfg.S3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+
+	if cfg.S3.SecretAccessKey == "" {
+		cfg.S3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if cfg.S3.SessionToken == "" {
+		cfg.S3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	if cfg.S3.PartSize == 0 {
+		cfg.S3.PartSize = defaultS3PartSize
+	}
+
+	if cfg.V3ioRepository.Username == "" {
+		cfg.V3ioRepository.Username = os.Getenv("V3IO_REPOSITORY_USERNAME")
+	}
+
+	if cfg.V3ioRepository.Password == "" {
+		cfg.V3ioRepository.Password = os.Getenv("V3IO_REPOSITORY_PASSWORD")
+	}
+
+	if cfg.V3ioRepository.AccessKey == "" {
+		cfg.V3ioRepository.AccessKey = os.Getenv("V3IO_REPOSITORY_ACCESS_KEY")
+	}
+
 	// Development mode
 	if ! cfg.MetricsReporter.ReportOnShutdown {
 		cfg.MetricsReporter.ReportOnShutdown = true
@@ -9,10 +9,11 @@ import (
 )
 
 func TestSanitation(tst *testing.T) {
-	config := &commands.Config{
+	config := &Config{
 		AccessKey: "12345",
 		Username:  "moses",
 		Password:  "bla-bla-password",
+		S3:        S3Config{AccessKeyID: "AKID", SecretAccessKey: "s3-secret"},
 	}
 
 	configAsString := config.String()
@@ -23,8 +24,25 @@ func TestSanitation(tst *testing.T) {
 	// sensitive fields must be sanitized
 	assert.NotContains(tst, configAsString, "12345")
 	assert.NotContains(tst, configAsString, "bla-bla-password")
+	assert.NotContains(tst, configAsString, "s3-secret")
 
 	// original object should not be changed
 	assert.Equal(tst, config.AccessKey, "12345")
 	assert.Equal(tst, config.Password, "bla-bla-password")
 }
+
+func TestTsdbRange(tst *testing.T) {
+	options := &BackupOptions{TsdbFrom: "2019-05-01T00:00:00Z"}
+	from, to, err := options.TsdbRange()
+	assert.NoError(tst, err)
+	assert.Equal(tst, int64(1556668800), from.Unix())
+	assert.True(tst, to.IsZero())
+
+	options.TsdbTo = "2019-04-01T00:00:00Z"
+	_, _, err = options.TsdbRange()
+	assert.Error(tst, err)
+
+	options = &BackupOptions{TsdbTo: "yesterday"}
+	_, _, err = options.TsdbRange()
+	assert.Error(tst, err)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KeySize is the size of the AES-256 keys
+	KeySize = 32
+	// NonceSize is the size of the random nonce prepended to every ciphertext
+	NonceSize = 12
+	// Extension is the number of bytes a ciphertext is longer than its plaintext
+	Extension = NonceSize + 16
+)
+
+// ErrUnauthenticated is returned when a ciphertext fails the authentication - it was either
+// encrypted with a different key or modified since
+var ErrUnauthenticated = errors.New("Ciphertext verification failed.")
+
+// Key encrypts and authenticates data with AES-256-GCM. Every ciphertext has the form
+//
+//	nonce (12 bytes) | encrypted data | GCM tag (16 bytes)
+type Key struct {
+	EncryptionKey []byte `json:"encrypt"`
+
+	aead cipher.AEAD
+}
+
+// NewRandomKey returns a new key generated from the system CSPRNG
+func NewRandomKey() *Key {
+	encryptionKey := make([]byte, KeySize)
+	if _, err := rand.Read(encryptionKey); err != nil {
+		panic(err)
+	}
+
+	key, err := NewKey(encryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// NewKey returns a key using the given raw key material
+func NewKey(encryptionKey []byte) (*Key, error) {
+	key := &Key{EncryptionKey: encryptionKey}
+	if err := key.init(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *Key) init() error {
+	if len(k.EncryptionKey) != KeySize {
+		return errors.Errorf("Invalid key size %d (expected %d).", len(k.EncryptionKey), KeySize)
+	}
+
+	block, err := aes.NewCipher(k.EncryptionKey)
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize the cipher.")
+	}
+	k.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize the cipher.")
+	}
+	return nil
+}
+
+// Valid returns true if the key material is set
+func (k *Key) Valid() bool {
+	return k != nil && len(k.EncryptionKey) == KeySize
+}
+
+// Seal encrypts and authenticates the plaintext, returning a newly allocated ciphertext
+func (k *Key) Seal(plaintext []byte) []byte {
+	if k.aead == nil {
+		if err := k.init(); err != nil {
+			panic(err)
+		}
+	}
+
+	ciphertext := make([]byte, NonceSize, NonceSize+len(plaintext)+k.aead.Overhead())
+	if _, err := rand.Read(ciphertext); err != nil {
+		panic(err)
+	}
+	return k.aead.Seal(ciphertext, ciphertext[:NonceSize], plaintext, nil)
+}
+
+// Open verifies and decrypts the ciphertext, returning a newly allocated plaintext
+func (k *Key) Open(ciphertext []byte) ([]byte, error) {
+	if k.aead == nil {
+		if err := k.init(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ciphertext) < Extension {
+		return nil, errors.Errorf("Ciphertext too short (%d bytes).", len(ciphertext))
+	}
+
+	plaintext, err := k.aead.Open(nil, ciphertext[:NonceSize], ciphertext[NonceSize:], nil)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	return plaintext, nil
+}
+
+// KDFParams are the scrypt parameters used for deriving a key from a password
+type KDFParams struct {
+	N int `json:"N"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// DefaultKDFParams are used for new keys (~100ms and 32MiB of memory per derivation)
+var DefaultKDFParams = KDFParams{N: 1 << 15, R: 8, P: 1}
+
+// NewSalt returns a random salt for the key derivation
+func NewSalt() []byte {
+	salt := make([]byte, 64)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return salt
+}
+
+// DeriveKey derives a key from the password with scrypt
+func DeriveKey(password string, salt []byte, params KDFParams) (*Key, error) {
+	if len(salt) == 0 {
+		return nil, errors.New("Key derivation salt must be set.")
+	}
+
+	derived, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, KeySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to derive the key from the password.")
+	}
+	return NewKey(derived)
+}
@@ -0,0 +1,51 @@
+// +build unit
+
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(tst *testing.T) {
+	key := NewRandomKey()
+	plaintext := []byte("customer data")
+
+	ciphertext := key.Seal(plaintext)
+	assert.Len(tst, ciphertext, len(plaintext)+Extension)
+	assert.NotEqual(tst, ciphertext, key.Seal(plaintext), "nonce must be random")
+
+	decrypted, err := key.Open(ciphertext)
+	require.NoError(tst, err)
+	assert.Equal(tst, plaintext, decrypted)
+
+	ciphertext[NonceSize] ^= 1
+	_, err = key.Open(ciphertext)
+	assert.Equal(tst, ErrUnauthenticated, err)
+
+	_, err = NewRandomKey().Open(key.Seal(plaintext))
+	assert.Equal(tst, ErrUnauthenticated, err)
+
+	_, err = key.Open(ciphertext[:Extension-1])
+	assert.Error(tst, err)
+}
+
+func TestDeriveKey(tst *testing.T) {
+	params := KDFParams{N: 1 << 10, R: 8, P: 1}
+	salt := NewSalt()
+
+	key, err := DeriveKey("secret", salt, params)
+	require.NoError(tst, err)
+	same, err := DeriveKey("secret", salt, params)
+	require.NoError(tst, err)
+	assert.Equal(tst, key.EncryptionKey, same.EncryptionKey)
+
+	other, err := DeriveKey("other", salt, params)
+	require.NoError(tst, err)
+	assert.NotEqual(tst, key.EncryptionKey, other.EncryptionKey)
+
+	_, err = DeriveKey("secret", nil, params)
+	assert.Error(tst, err)
+}
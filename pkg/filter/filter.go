@@ -0,0 +1,367 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package filter
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/backend"
+)
+
+// Pattern prefixes selecting the pattern syntax
+const (
+	RegexPrefix = "re:"
+	GlobPrefix  = "glob:"
+)
+
+// Options of the filter. Patterns are matched against the absolute path of the entries within the data source.
+//
+// Patterns given directly are RegEx patterns unless prefixed with "glob:". Pattern files hold a pattern per line,
+// gitignore-style globs unless prefixed with "re:" - blank lines and lines starting with "#" are ignored, and
+// lines of exclude files starting with "!" are include patterns.
+//
+// Globs without a slash match the name of the entry at any depth ("*.tmp"), and other globs match the path from
+// the root ("/logs/*.log", "data/**/cache"). "**" matches any number of directories, and a trailing slash
+// matches directories only ("cache/").
+type Options struct {
+	Excludes          []string // exclude the entries matching any of these patterns
+	Includes          []string // back up the entries matching any of these patterns, even if excluded
+	ExcludeFiles      []string // files of exclude (and "!" prefixed include) patterns
+	IncludeFiles      []string // files of include patterns
+	ExcludeLargerThan string   // exclude the files larger than this size, e.g. "512M" (binary units)
+	ExcludeIfPresent  []string // exclude the directories holding a file with any of these names
+}
+
+// MarkerFunc returns true if the directory at the given path holds a file with the given name
+type MarkerFunc func(dir string, name string) (bool, error)
+
+// RuleStats counts the entries excluded by a single rule
+type RuleStats struct {
+	Rule     string
+	Excluded int64
+}
+
+// Filter decides which entries are backed up. Data sources apply it while listing, so the entries of
+// excluded directories are not listed at all - and thus can't be included back.
+// A nil filter excludes nothing. Safe for concurrent use.
+type Filter struct {
+	excludes []*rule
+	includes []*rule
+}
+
+type rule struct {
+	description string
+	match       func(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error)
+	excluded    int64
+}
+
+// New compiles the filter rules. Returns nil if there are no exclude rules.
+func New(opts Options) (*Filter, error) {
+	f := &Filter{}
+
+	for _, pattern := range opts.Excludes {
+		r, err := newPatternRule(pattern, false)
+		if err != nil {
+			return nil, err
+		}
+		f.excludes = append(f.excludes, r)
+	}
+	for _, pattern := range opts.Includes {
+		r, err := newPatternRule(pattern, false)
+		if err != nil {
+			return nil, err
+		}
+		f.includes = append(f.includes, r)
+	}
+
+	for _, file := range opts.ExcludeFiles {
+		patterns, err := readPatternFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range patterns {
+			include := strings.HasPrefix(pattern, "!")
+			r, err := newPatternRule(strings.TrimPrefix(pattern, "!"), true)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid pattern in '%s'.", file)
+			}
+			if include {
+				f.includes = append(f.includes, r)
+			} else {
+				f.excludes = append(f.excludes, r)
+			}
+		}
+	}
+	for _, file := range opts.IncludeFiles {
+		patterns, err := readPatternFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range patterns {
+			r, err := newPatternRule(pattern, true)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid pattern in '%s'.", file)
+			}
+			f.includes = append(f.includes, r)
+		}
+	}
+
+	if opts.ExcludeLargerThan != "" {
+		size, err := ParseSize(opts.ExcludeLargerThan)
+		if err != nil {
+			return nil, err
+		}
+		f.excludes = append(f.excludes, &rule{
+			description: "larger than " + opts.ExcludeLargerThan,
+			match: func(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error) {
+				return !fileInfo.IsDir() && fileInfo.Size() > size, nil
+			},
+		})
+	}
+
+	for _, name := range opts.ExcludeIfPresent {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, errors.Errorf("Invalid marker file name '%s'.", name)
+		}
+		markerName := name
+		f.excludes = append(f.excludes, &rule{
+			description: "if present " + markerName,
+			match: func(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error) {
+				if !fileInfo.IsDir() || marker == nil {
+					return false, nil
+				}
+				return marker(fileInfo.Path(), markerName)
+			},
+		})
+	}
+
+	if len(f.excludes) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Excluded returns true if the entry should not be backed up. The marker function is used
+// to look for the marker files of the directories (see Options.ExcludeIfPresent).
+func (f *Filter) Excluded(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+
+	for _, exclude := range f.excludes {
+		matched, err := exclude.match(fileInfo, marker)
+		if err != nil {
+			return false, errors.Wrapf(err, "Failed to apply exclude rule '%s' to '%s'.", exclude.description, fileInfo.Path())
+		}
+		if !matched {
+			continue
+		}
+
+		for _, include := range f.includes {
+			if included, _ := include.match(fileInfo, marker); included {
+				return false, nil
+			}
+		}
+
+		atomic.AddInt64(&exclude.excluded, 1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Rules returns the descriptions of the exclude rules
+func (f *Filter) Rules() []string {
+	if f == nil {
+		return nil
+	}
+
+	rules := make([]string, 0, len(f.excludes))
+	for _, exclude := range f.excludes {
+		rules = append(rules, exclude.description)
+	}
+	return rules
+}
+
+// Stats returns the number of entries excluded by every exclude rule, in the order of the rules
+func (f *Filter) Stats() []RuleStats {
+	if f == nil {
+		return nil
+	}
+
+	stats := make([]RuleStats, 0, len(f.excludes))
+	for _, exclude := range f.excludes {
+		stats = append(stats, RuleStats{Rule: exclude.description, Excluded: atomic.LoadInt64(&exclude.excluded)})
+	}
+	return stats
+}
+
+// newPatternRule compiles a RegEx or glob pattern, globs being the default for patterns read from files
+func newPatternRule(pattern string, globByDefault bool) (*rule, error) {
+	glob := globByDefault
+	switch {
+	case strings.HasPrefix(pattern, RegexPrefix):
+		glob, pattern = false, strings.TrimPrefix(pattern, RegexPrefix)
+	case strings.HasPrefix(pattern, GlobPrefix):
+		glob, pattern = true, strings.TrimPrefix(pattern, GlobPrefix)
+	}
+	if pattern == "" {
+		return nil, errors.New("Empty filter pattern.")
+	}
+
+	if !glob {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid RegEx pattern '%s'.", pattern)
+		}
+		return &rule{
+			description: RegexPrefix + pattern,
+			match: func(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error) {
+				return re.MatchString(fileInfo.Path()), nil
+			},
+		}, nil
+	}
+
+	re, dirOnly, err := compileGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &rule{
+		description: GlobPrefix + pattern,
+		match: func(fileInfo *backend.FileInfo, marker MarkerFunc) (bool, error) {
+			return (!dirOnly || fileInfo.IsDir()) && re.MatchString(path.Clean("/"+fileInfo.Path())), nil
+		},
+	}, nil
+}
+
+// compileGlob translates a gitignore-style glob to a regular expression matching the absolute paths.
+// Returns true if the glob matches directories only.
+func compileGlob(pattern string) (*regexp.Regexp, bool, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	glob := strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	if glob == "" {
+		return nil, false, errors.Errorf("Invalid glob pattern '%s'.", pattern)
+	}
+
+	var expr strings.Builder
+	if anchored {
+		expr.WriteString("^/")
+	} else {
+		expr.WriteString("(^|/)")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, false, errors.Errorf("Invalid glob pattern '%s' - unterminated character class.", pattern)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			expr.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Invalid glob pattern '%s'.", pattern)
+	}
+	return re, dirOnly, nil
+}
+
+func readPatternFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open pattern file '%s'.", file)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read pattern file '%s'.", file)
+	}
+	return patterns, nil
+}
+
+// ParseSize parses a size with an optional binary unit suffix - "100", "512K", "10MiB", "1G", "2TB"
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "IB"), "B")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.Errorf("Invalid size '%s'.", s)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,178 @@
+// +build unit
+
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/backend"
+)
+
+type entryInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (ei *entryInfo) Name() string       { return ei.name }
+func (ei *entryInfo) Size() int64        { return ei.size }
+func (ei *entryInfo) Mode() os.FileMode  { return 0644 }
+func (ei *entryInfo) ModTime() time.Time { return time.Time{} }
+func (ei *entryInfo) IsDir() bool        { return ei.isDir }
+func (ei *entryInfo) Sys() interface{}   { return nil }
+
+func file(p string, size int64) *backend.FileInfo {
+	return backend.NewFileInfo(p, &entryInfo{name: path.Base(p), size: size}, nil)
+}
+
+func dir(p string) *backend.FileInfo {
+	return backend.NewFileInfo(p, &entryInfo{name: path.Base(p), isDir: true}, nil)
+}
+
+func excluded(tst *testing.T, f *Filter, info *backend.FileInfo) bool {
+	result, err := f.Excluded(info, nil)
+	require.NoError(tst, err)
+	return result
+}
+
+func TestGlobs(tst *testing.T) {
+	for _, test := range []struct {
+		glob    string
+		path    string
+		isDir   bool
+		matched bool
+	}{
+		{"*.tmp", "/a.tmp", false, true},
+		{"*.tmp", "/data/sub/a.tmp", false, true},
+		{"*.tmp", "/data/a.tmp.csv", false, false},
+		{"cache/", "/data/cache", true, true},
+		{"cache/", "/data/cache", false, false},
+		{"/logs", "/logs", true, true},
+		{"/logs", "/data/logs", true, false},
+		{"data/*.csv", "/data/a.csv", false, true},
+		{"data/*.csv", "/data/sub/a.csv", false, false},
+		{"data/**/a.csv", "/data/a.csv", false, true},
+		{"data/**/a.csv", "/data/x/y/a.csv", false, true},
+		{"**/build", "/src/build", true, true},
+		{"logs/**", "/logs/app/server.log", false, true},
+		{"file-?.csv", "/file-1.csv", false, true},
+		{"file-?.csv", "/file-10.csv", false, false},
+		{"file-[!0-4].csv", "/file-7.csv", false, true},
+		{"file-[!0-4].csv", "/file-3.csv", false, false},
+		{"a\\*b", "/a*b", false, true},
+		{"a\\*b", "/axb", false, false},
+	} {
+		f, err := New(Options{Excludes: []string{GlobPrefix + test.glob}})
+		require.NoError(tst, err)
+
+		info := file(test.path, 0)
+		if test.isDir {
+			info = dir(test.path)
+		}
+		assert.Equal(tst, test.matched, excluded(tst, f, info), "%s %s", test.glob, test.path)
+	}
+
+	_, err := New(Options{Excludes: []string{"glob:file-[0-9"}})
+	assert.Error(tst, err)
+	_, err = New(Options{Excludes: []string{"glob:/"}})
+	assert.Error(tst, err)
+}
+
+func TestRegexAndIncludes(tst *testing.T) {
+	f, err := New(Options{
+		Excludes: []string{`\.log$`, "^/tmp/"},
+		Includes: []string{`/important\.log$`},
+	})
+	require.NoError(tst, err)
+
+	assert.True(tst, excluded(tst, f, file("/logs/app.log", 1)))
+	assert.False(tst, excluded(tst, f, file("/logs/important.log", 1)))
+	assert.True(tst, excluded(tst, f, file("/tmp/a.csv", 1)))
+	assert.False(tst, excluded(tst, f, file("/data/a.csv", 1)))
+
+	assert.Equal(tst, []string{`re:\.log$`, "re:^/tmp/"}, f.Rules())
+	assert.Equal(tst, []RuleStats{{Rule: `re:\.log$`, Excluded: 1}, {Rule: "re:^/tmp/", Excluded: 1}}, f.Stats())
+
+	_, err = New(Options{Excludes: []string{"("}})
+	assert.Error(tst, err)
+
+	// No exclude rules, no filter
+	f, err = New(Options{Includes: []string{"a"}})
+	require.NoError(tst, err)
+	assert.Nil(tst, f)
+	assert.False(tst, excluded(tst, f, file("/a", 1)))
+}
+
+func TestPatternFiles(tst *testing.T) {
+	dir, err := ioutil.TempDir("", "v3io-backup-filter")
+	require.NoError(tst, err)
+	defer os.RemoveAll(dir)
+
+	excludeFile := filepath.Join(dir, "excludes")
+	require.NoError(tst, ioutil.WriteFile(excludeFile, []byte("# build output\n*.o\n\n!keep.o\nre:^/scratch/\n"), 0644))
+	includeFile := filepath.Join(dir, "includes")
+	require.NoError(tst, ioutil.WriteFile(includeFile, []byte("/scratch/notes.txt\n"), 0644))
+
+	f, err := New(Options{ExcludeFiles: []string{excludeFile}, IncludeFiles: []string{includeFile}})
+	require.NoError(tst, err)
+
+	assert.True(tst, excluded(tst, f, file("/src/main.o", 1)))
+	assert.False(tst, excluded(tst, f, file("/src/keep.o", 1)))
+	assert.True(tst, excluded(tst, f, file("/scratch/a.txt", 1)))
+	assert.False(tst, excluded(tst, f, file("/scratch/notes.txt", 1)))
+	assert.Equal(tst, []string{"glob:*.o", "re:^/scratch/"}, f.Rules())
+
+	_, err = New(Options{ExcludeFiles: []string{filepath.Join(dir, "missing")}})
+	assert.Error(tst, err)
+}
+
+func TestSizeAndMarkerRules(tst *testing.T) {
+	f, err := New(Options{ExcludeLargerThan: "1K", ExcludeIfPresent: []string{".nobackup"}})
+	require.NoError(tst, err)
+
+	marker := func(dir string, name string) (bool, error) {
+		assert.Equal(tst, ".nobackup", name)
+		return dir == "/skipped", nil
+	}
+	isExcluded := func(info *backend.FileInfo) bool {
+		result, err := f.Excluded(info, marker)
+		require.NoError(tst, err)
+		return result
+	}
+
+	assert.False(tst, isExcluded(file("/small", 1024)))
+	assert.True(tst, isExcluded(file("/large", 1025)))
+	assert.False(tst, isExcluded(dir("/kept")))
+	assert.True(tst, isExcluded(dir("/skipped")))
+	assert.Equal(tst, []RuleStats{{Rule: "larger than 1K", Excluded: 1}, {Rule: "if present .nobackup", Excluded: 1}}, f.Stats())
+
+	_, err = New(Options{ExcludeIfPresent: []string{"a/b"}})
+	assert.Error(tst, err)
+}
+
+func TestParseSize(tst *testing.T) {
+	for input, expected := range map[string]int64{
+		"100":   100,
+		"2K":    2 << 10,
+		"10MiB": 10 << 20,
+		"1g":    1 << 30,
+		"3TB":   3 << 40,
+		"512B":  512,
+	} {
+		size, err := ParseSize(input)
+		require.NoError(tst, err, input)
+		assert.Equal(tst, expected, size, input)
+	}
+
+	for _, input := range []string{"", "M", "-1", "1X"} {
+		_, err := ParseSize(input)
+		assert.Error(tst, err, input)
+	}
+}
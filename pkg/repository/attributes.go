@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Attribute types
+const (
+	AttributeTypeInt    = "int"
+	AttributeTypeFloat  = "float"
+	AttributeTypeString = "string"
+	AttributeTypeBlob   = "blob"
+	AttributeTypeBool   = "bool"
+)
+
+// Attributes are the extended attributes of an entry (e.g. the attributes of a V3IO item). Every value is stored
+// along with its type, so the values are restored with their original types:
+//
+//	{"<name>": {"type": "int" | "float" | "string" | "blob" | "bool", "value": <value>}, ...}
+//
+// Integers are int64 values, floats are float64 values and blobs are []byte values (base64 encoded).
+type Attributes map[string]interface{}
+
+type typedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (a Attributes) MarshalJSON() ([]byte, error) {
+	typed := make(map[string]typedValue, len(a))
+	for name, value := range a {
+		var (
+			t       string
+			encoded []byte
+			err     error
+		)
+
+		switch v := value.(type) {
+		case int:
+			t, encoded = AttributeTypeInt, []byte(strconv.FormatInt(int64(v), 10))
+		case int64:
+			t, encoded = AttributeTypeInt, []byte(strconv.FormatInt(v, 10))
+		case int32:
+			t, encoded = AttributeTypeInt, []byte(strconv.FormatInt(int64(v), 10))
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, errors.Errorf("Value of attribute '%s' overflows int64.", name)
+			}
+			t, encoded = AttributeTypeInt, []byte(strconv.FormatUint(v, 10))
+		case float64:
+			t = AttributeTypeFloat
+			encoded, err = json.Marshal(v)
+		case float32:
+			t = AttributeTypeFloat
+			encoded, err = json.Marshal(float64(v))
+		case string:
+			t = AttributeTypeString
+			encoded, err = json.Marshal(v)
+		case []byte:
+			t = AttributeTypeBlob
+			encoded, err = json.Marshal(base64.StdEncoding.EncodeToString(v))
+		case bool:
+			t = AttributeTypeBool
+			encoded, err = json.Marshal(v)
+		default:
+			return nil, errors.Errorf("Unsupported type %T of attribute '%s'.", value, name)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to encode attribute '%s'.", name)
+		}
+
+		typed[name] = typedValue{Type: t, Value: encoded}
+	}
+	return json.Marshal(typed)
+}
+
+func (a *Attributes) UnmarshalJSON(data []byte) error {
+	typed := map[string]typedValue{}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+
+	*a = make(Attributes, len(typed))
+	for name, tv := range typed {
+		var (
+			value interface{}
+			err   error
+		)
+
+		switch tv.Type {
+		case AttributeTypeInt:
+			value, err = strconv.ParseInt(string(bytes.TrimSpace(tv.Value)), 10, 64)
+		case AttributeTypeFloat:
+			var f float64
+			err = json.Unmarshal(tv.Value, &f)
+			value = f
+		case AttributeTypeString:
+			var s string
+			err = json.Unmarshal(tv.Value, &s)
+			value = s
+		case AttributeTypeBlob:
+			var s string
+			if err = json.Unmarshal(tv.Value, &s); err == nil {
+				value, err = base64.StdEncoding.DecodeString(s)
+			}
+		case AttributeTypeBool:
+			var b bool
+			err = json.Unmarshal(tv.Value, &b)
+			value = b
+		default:
+			return errors.Errorf("Unknown type '%s' of attribute '%s'.", tv.Type, name)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Failed to decode attribute '%s'.", name)
+		}
+
+		(*a)[name] = value
+	}
+	return nil
+}
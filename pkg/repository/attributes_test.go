@@ -0,0 +1,43 @@
+// +build unit
+
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributesEncoding(tst *testing.T) {
+	attributes := Attributes{
+		"int":    9007199254740993,
+		"float":  1.0,
+		"string": "value",
+		"blob":   []byte{0, 1, 2, 255},
+		"bool":   false,
+	}
+
+	data, err := json.Marshal(attributes)
+	require.NoError(tst, err)
+
+	decoded := Attributes{}
+	require.NoError(tst, json.Unmarshal(data, &decoded))
+	assert.Equal(tst, Attributes{
+		"int":    int64(9007199254740993),
+		"float":  1.0,
+		"string": "value",
+		"blob":   []byte{0, 1, 2, 255},
+		"bool":   false,
+	}, decoded)
+}
+
+func TestAttributesErrors(tst *testing.T) {
+	_, err := json.Marshal(Attributes{"list": []int{1}})
+	assert.Error(tst, err)
+
+	decoded := Attributes{}
+	assert.Error(tst, json.Unmarshal([]byte(`{"a": {"type": "date", "value": 1}}`), &decoded))
+	assert.Error(tst, json.Unmarshal([]byte(`{"a": {"type": "int", "value": 1.5}}`), &decoded))
+}
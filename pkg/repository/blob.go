@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// BlobType specifies the kind of data stored in a blob
+type BlobType uint8
+
+const (
+	InvalidBlob BlobType = iota
+	DataBlob
+	TreeBlob
+)
+
+func (t BlobType) String() string {
+	switch t {
+	case DataBlob:
+		return "data"
+	case TreeBlob:
+		return "tree"
+	default:
+		return fmt.Sprintf("<BlobType %d>", t)
+	}
+}
+
+func (t BlobType) MarshalJSON() ([]byte, error) {
+	switch t {
+	case DataBlob, TreeBlob:
+		return []byte(`"` + t.String() + `"`), nil
+	default:
+		return nil, errors.Errorf("Unknown blob type %d.", t)
+	}
+}
+
+func (t *BlobType) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `"data"`:
+		*t = DataBlob
+	case `"tree"`:
+		*t = TreeBlob
+	default:
+		return errors.Errorf("Unknown blob type %s.", data)
+	}
+	return nil
+}
+
+// BlobHandle identifies a blob of a given type
+type BlobHandle struct {
+	ID   ID
+	Type BlobType
+}
+
+func (h BlobHandle) String() string {
+	return fmt.Sprintf("<%s/%s>", h.Type, h.ID.Str())
+}
+
+// Blob is a single blob stored within a pack file. Offset and Length locate the stored (compressed and encrypted)
+// blob, UncompressedLength is only set for compressed blobs.
+type Blob struct {
+	BlobHandle
+	Offset             uint
+	Length             uint
+	UncompressedLength uint
+	Compression        Compression
+}
+
+func (b Blob) String() string {
+	return fmt.Sprintf("<Blob (%s) %s, offset %d, length %d>", b.Type, b.ID.Str(), b.Offset, b.Length)
+}
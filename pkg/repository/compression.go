@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression modes of the repository
+const (
+	CompressionOff  = "off"  // store the blobs as is
+	CompressionAuto = "auto" // fast compression, blobs that don't shrink are stored as is
+	CompressionMax  = "max"  // best compression, slower
+)
+
+// Compression algorithms of the repository
+const (
+	AlgorithmZstd   = "zstd"
+	AlgorithmSnappy = "snappy"
+)
+
+// Compression specifies how a stored blob is compressed, recorded per blob in the pack header and the index
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	ZstdCompression
+	SnappyCompression
+)
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case ZstdCompression:
+		return AlgorithmZstd
+	case SnappyCompression:
+		return AlgorithmSnappy
+	default:
+		return fmt.Sprintf("<Compression %d>", c)
+	}
+}
+
+func (c Compression) MarshalJSON() ([]byte, error) {
+	switch c {
+	case NoCompression, ZstdCompression, SnappyCompression:
+		return []byte(`"` + c.String() + `"`), nil
+	default:
+		return nil, errors.Errorf("Unknown compression %d.", c)
+	}
+}
+
+func (c *Compression) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `"none"`:
+		*c = NoCompression
+	case `"zstd"`:
+		*c = ZstdCompression
+	case `"snappy"`:
+		*c = SnappyCompression
+	default:
+		return errors.Errorf("Unknown compression %s.", data)
+	}
+	return nil
+}
+
+// ValidateCompression checks the repository compression settings
+func ValidateCompression(mode string, algorithm string) error {
+	switch mode {
+	case CompressionOff, CompressionAuto, CompressionMax:
+	default:
+		return errors.Errorf("Invalid compression mode '%s' - expected one of %s, %s, %s.", mode, CompressionOff, CompressionAuto, CompressionMax)
+	}
+
+	switch algorithm {
+	case AlgorithmZstd, AlgorithmSnappy:
+	default:
+		return errors.Errorf("Invalid compression algorithm '%s' - expected %s or %s.", algorithm, AlgorithmZstd, AlgorithmSnappy)
+	}
+	return nil
+}
+
+// compressor compresses the blobs according to the repository settings. Safe for concurrent use.
+type compressor struct {
+	compression Compression
+	encoder     *zstd.Encoder
+}
+
+func newCompressor(mode string, algorithm string) (*compressor, error) {
+	if mode == "" || mode == CompressionOff {
+		return &compressor{compression: NoCompression}, nil
+	}
+	if err := ValidateCompression(mode, algorithm); err != nil {
+		return nil, err
+	}
+
+	if algorithm == AlgorithmSnappy {
+		return &compressor{compression: SnappyCompression}, nil
+	}
+
+	level := zstd.SpeedDefault
+	if mode == CompressionMax {
+		level = zstd.SpeedBestCompression
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create the zstd encoder.")
+	}
+	return &compressor{compression: ZstdCompression, encoder: encoder}, nil
+}
+
+// Compress returns the data to store along with its compression. Data that doesn't shrink is returned as is.
+func (c *compressor) Compress(data []byte) ([]byte, Compression) {
+	var compressed []byte
+	switch c.compression {
+	case ZstdCompression:
+		compressed = c.encoder.EncodeAll(data, make([]byte, 0, len(data)))
+	case SnappyCompression:
+		compressed = snappy.Encode(nil, data)
+	default:
+		return data, NoCompression
+	}
+
+	if len(compressed) >= len(data) {
+		return data, NoCompression
+	}
+	return compressed, c.compression
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+// decompress restores the blob data, which must be of the given size once decompressed
+func decompress(data []byte, compression Compression, uncompressedLength uint) ([]byte, error) {
+	var (
+		decompressed []byte
+		err          error
+	)
+
+	switch compression {
+	case NoCompression:
+		return data, nil
+	case ZstdCompression:
+		zstdDecoderOnce.Do(func() {
+			zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+		})
+		decompressed, err = zstdDecoder.DecodeAll(data, make([]byte, 0, uncompressedLength))
+	case SnappyCompression:
+		decompressed, err = snappy.Decode(make([]byte, uncompressedLength), data)
+	default:
+		return nil, errors.Errorf("Unsupported blob compression %s.", compression)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decompress the %s blob.", compression)
+	}
+	if uint(len(decompressed)) != uncompressedLength {
+		return nil, errors.Errorf("Decompressed blob size %d doesn't match the expected size %d.", len(decompressed), uncompressedLength)
+	}
+	return decompressed, nil
+}
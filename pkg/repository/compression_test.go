@@ -0,0 +1,104 @@
+// +build unit
+
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/crypto"
+	"v3io-backup/pkg/storage"
+)
+
+func TestCompression(tst *testing.T) {
+	compressible := bytes.Repeat([]byte(`{"key": "value", "count": 42}`), 1000)
+	random := crypto.NewRandomKey().Seal(compressible)
+
+	for _, test := range []struct {
+		mode      string
+		algorithm string
+		expected  Compression
+	}{
+		{CompressionOff, "", NoCompression},
+		{CompressionAuto, AlgorithmZstd, ZstdCompression},
+		{CompressionMax, AlgorithmZstd, ZstdCompression},
+		{CompressionAuto, AlgorithmSnappy, SnappyCompression},
+	} {
+		cfg := &config.Config{Compression: test.mode, CompressionAlgorithm: test.algorithm}
+		repo, backend, cleanup := newTestRepository(tst, cfg)
+		defer cleanup()
+
+		compressibleID, err := repo.SaveBlob(DataBlob, compressible)
+		require.NoError(tst, err)
+		randomID, err := repo.SaveBlob(DataBlob, random)
+		require.NoError(tst, err)
+		require.NoError(tst, repo.Flush())
+
+		reopened, err := Open(backend, cfg, "secret")
+		require.NoError(tst, err)
+		assert.Equal(tst, test.mode, reopened.Config().Compression)
+		require.NoError(tst, reopened.LoadIndex())
+
+		blob, ok := reopened.Index().Lookup(BlobHandle{ID: compressibleID, Type: DataBlob})
+		require.True(tst, ok)
+		assert.Equal(tst, test.expected, blob.Compression, test.mode)
+		if test.expected != NoCompression {
+			assert.True(tst, blob.Length < uint(len(compressible)))
+			assert.Equal(tst, uint(len(compressible)), blob.UncompressedLength)
+		}
+
+		// Incompressible data is stored as is
+		blob, ok = reopened.Index().Lookup(BlobHandle{ID: randomID, Type: DataBlob})
+		require.True(tst, ok)
+		assert.Equal(tst, NoCompression, blob.Compression)
+
+		for id, expected := range map[ID][]byte{compressibleID: compressible, randomID: random} {
+			data, err := reopened.LoadBlob(DataBlob, id)
+			require.NoError(tst, err)
+			assert.Equal(tst, expected, data)
+		}
+
+		packs, err := backend.List(storage.PackFile)
+		require.NoError(tst, err)
+		require.Len(tst, packs, 1)
+		packID, err := ParseID(packs[0].Name)
+		require.NoError(tst, err)
+		data, err := backend.Load(packHandle(packID), 0, 0)
+		require.NoError(tst, err)
+		blobs, err := readPackHeader(bytes.NewReader(data), int64(len(data)), repo.key)
+		require.NoError(tst, err)
+		assert.Equal(tst, test.expected, blobs[0].Compression)
+	}
+
+	_, err := newCompressor("fast", AlgorithmZstd)
+	assert.Error(tst, err)
+	_, err = newCompressor(CompressionAuto, "lz4")
+	assert.Error(tst, err)
+}
+
+func TestPackHeaderVersion(tst *testing.T) {
+	key := crypto.NewRandomKey()
+	blobData := []byte("blob")
+	stored := key.Seal(blobData)
+
+	// A well formed header of the first version, which held no compression
+	header := []byte{1, byte(DataBlob)}
+	header = append(header, make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(header[6:], uint32(len(stored)))
+	id := Hash(blobData)
+	header = append(header, id[:]...)
+
+	encryptedHeader := key.Seal(header)
+	pack := append(append([]byte{}, stored...), encryptedHeader...)
+	headerLength := make([]byte, packHeaderLengthSize)
+	binary.LittleEndian.PutUint32(headerLength, uint32(len(encryptedHeader)))
+	pack = append(pack, headerLength...)
+
+	_, err := readPackHeader(bytes.NewReader(pack), int64(len(pack)), key)
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "Unsupported pack header version 1")
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/crypto"
+	"v3io-backup/pkg/storage"
+)
+
+var configHandle = storage.Handle{Type: storage.ConfigFile}
+
+// Version of the repository format
+const RepositoryVersion = 1
+
+// Config is the repository configuration, stored in the "config" file at the root of the repository
+type Config struct {
+	Version     int       `json:"version"`
+	ID          string    `json:"id"`
+	Created     time.Time `json:"created"`
+	ChunkerSeed uint64    `json:"chunker_seed"` // seed of the content-defined chunker, see chunker.New
+
+	// Blob compression - "off" (or unset) | "auto" | "max", with the "zstd" or "snappy" algorithm
+	Compression          string `json:"compression,omitempty"`
+	CompressionAlgorithm string `json:"compression_algorithm,omitempty"`
+}
+
+// Init creates a new repository in the given backend, with a random master key wrapped by the password.
+// It fails if a repository already exists there.
+func Init(backend storage.Backend, cfg *config.Config, password string) (*Repository, error) {
+	if _, err := backend.Stat(configHandle); err == nil {
+		return nil, errors.Errorf("Repository at '%s' is already initialized.", backend.Location())
+	} else if !backend.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "Failed to check the repository at '%s'.", backend.Location())
+	}
+
+	if password == "" {
+		return nil, errors.New("Repository password must not be empty.")
+	}
+	if cfg.Compression != "" && cfg.Compression != CompressionOff {
+		if err := ValidateCompression(cfg.Compression, cfg.CompressionAlgorithm); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := backend.Create(); err != nil {
+		return nil, err
+	}
+
+	key, err := newKey(backend, password, crypto.NewRandomKey())
+	if err != nil {
+		return nil, err
+	}
+
+	id := NewRandomID()
+	seed := NewRandomID()
+	repoConfig := Config{
+		Version:     RepositoryVersion,
+		ID:          id.String(),
+		Created:     time.Now().UTC(),
+		ChunkerSeed: binary.LittleEndian.Uint64(seed[:8]),
+
+		Compression:          cfg.Compression,
+		CompressionAlgorithm: cfg.CompressionAlgorithm,
+	}
+	if repoConfig.Compression == "" || repoConfig.Compression == CompressionOff {
+		repoConfig.Compression = CompressionOff
+		repoConfig.CompressionAlgorithm = ""
+	}
+
+	data, err := json.MarshalIndent(&repoConfig, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode the repository config.")
+	}
+
+	r := New(backend, cfg)
+	r.useKey(key)
+	if err := r.setConfig(repoConfig); err != nil {
+		return nil, err
+	}
+	if err := backend.Save(configHandle, r.key.Seal(data)); err != nil {
+		return nil, errors.Wrap(err, "Failed to save the repository config.")
+	}
+
+	return r, nil
+}
+
+// Open opens an existing repository stored in the given backend, using the first key that matches the password
+func Open(backend storage.Backend, cfg *config.Config, password string) (*Repository, error) {
+	if _, err := backend.Stat(configHandle); err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the repository at '%s'. Is it initialized?", backend.Location())
+	}
+
+	key, err := searchKey(backend, password)
+	if err != nil {
+		return nil, err
+	}
+
+	r := New(backend, cfg)
+	r.useKey(key)
+
+	data, err := backend.Load(configHandle, 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load the repository config.")
+	}
+	if data, err = r.key.Open(data); err != nil {
+		return nil, errors.Wrap(err, "Failed to decrypt the repository config.")
+	}
+
+	repoConfig := Config{}
+	if err := json.Unmarshal(data, &repoConfig); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode the repository config.")
+	}
+
+	if repoConfig.Version != RepositoryVersion {
+		return nil, errors.Errorf("Unsupported repository version %d (expected %d).", repoConfig.Version, RepositoryVersion)
+	}
+
+	if err := r.setConfig(repoConfig); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,54 @@
+// +build unit
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage/local"
+)
+
+func TestInitAndOpen(tst *testing.T) {
+	dir, err := ioutil.TempDir("", "v3io-backup-repo")
+	require.NoError(tst, err)
+	defer os.RemoveAll(dir)
+
+	backend, err := local.New(filepath.Join(dir, "repo"))
+	require.NoError(tst, err)
+	cfg := config.WithDefaults(&config.Config{})
+
+	_, err = Init(backend, cfg, "")
+	assert.Error(tst, err)
+
+	created, err := Init(backend, cfg, "secret")
+	require.NoError(tst, err)
+	assert.Equal(tst, RepositoryVersion, created.Config().Version)
+
+	// Never re-initialize an existing repository
+	_, err = Init(backend, cfg, "secret")
+	assert.Error(tst, err)
+
+	opened, err := Open(backend, cfg, "secret")
+	require.NoError(tst, err)
+	assert.Equal(tst, created.Config().ID, opened.Config().ID)
+	assert.Equal(tst, created.KeyName(), opened.KeyName())
+
+	_, err = Open(backend, cfg, "wrong")
+	assert.Equal(tst, ErrNoKeyFound, err)
+
+	// The config is not stored in plaintext
+	data, err := ioutil.ReadFile(filepath.Join(dir, "repo", "config"))
+	require.NoError(tst, err)
+	assert.NotContains(tst, string(data), created.Config().ID)
+
+	missing, err := local.New(filepath.Join(dir, "missing"))
+	require.NoError(tst, err)
+	_, err = Open(missing, cfg, "secret")
+	assert.Error(tst, err)
+}
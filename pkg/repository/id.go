@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const idSize = sha256.Size
+
+// ID references content within a repository - the SHA-256 hash of that content
+type ID [idSize]byte
+
+// Hash returns the ID for the given data
+func Hash(data []byte) ID {
+	return sha256.Sum256(data)
+}
+
+// NewRandomID returns a randomly generated ID
+func NewRandomID() ID {
+	id := ID{}
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// ParseID converts the given hex string to an ID
+func ParseID(s string) (ID, error) {
+	id := ID{}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return id, errors.Wrapf(err, "Invalid ID '%s'.", s)
+	}
+	if len(b) != idSize {
+		return id, errors.Errorf("Invalid ID '%s' - wrong length.", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Str returns the shortened string version of the ID
+func (id ID) Str() string {
+	return hex.EncodeToString(id[:4])
+}
+
+func (id ID) IsNull() bool {
+	return id == ID{}
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "Failed to unmarshal ID.")
+	}
+	parsed, err := ParseID(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PackedBlob is a blob along with the pack file it is stored in
+type PackedBlob struct {
+	Blob
+	PackID ID
+}
+
+// Index maps the blobs to the pack files containing them. It is stored as a JSON index file:
+//
+//	{"packs": [{"id": "<pack ID>", "blobs": [{"id": "<blob ID>", "type": "data", "offset": 0, "length": 42}, ...]}, ...]}
+//
+// Compressed blobs also have the "uncompressed_length" and "compression" fields.
+type Index struct {
+	packs []indexPack
+	blobs map[BlobHandle]PackedBlob
+	size  int
+}
+
+type indexJSON struct {
+	Packs []indexPack `json:"packs"`
+}
+
+type indexPack struct {
+	ID    ID          `json:"id"`
+	Blobs []indexBlob `json:"blobs"`
+}
+
+type indexBlob struct {
+	ID                 ID          `json:"id"`
+	Type               BlobType    `json:"type"`
+	Offset             uint        `json:"offset"`
+	Length             uint        `json:"length"`
+	UncompressedLength uint        `json:"uncompressed_length,omitempty"`
+	Compression        Compression `json:"compression,omitempty"`
+}
+
+// Size of the encoded index without any packs
+const emptyIndexSize = len(`{"packs":[]}`)
+
+func NewIndex() *Index {
+	return &Index{
+		blobs: make(map[BlobHandle]PackedBlob),
+		size:  emptyIndexSize,
+	}
+}
+
+// StorePack adds the blobs of the given pack to the index
+func (idx *Index) StorePack(packID ID, blobs []Blob) {
+	pack := indexPack{ID: packID}
+	for _, blob := range blobs {
+		pack.Blobs = append(pack.Blobs, indexBlob{
+			ID:                 blob.ID,
+			Type:               blob.Type,
+			Offset:             blob.Offset,
+			Length:             blob.Length,
+			UncompressedLength: blob.UncompressedLength,
+			Compression:        blob.Compression,
+		})
+		idx.blobs[blob.BlobHandle] = PackedBlob{Blob: blob, PackID: packID}
+	}
+
+	// Keep track of the encoded size, so the index file can be rolled over at the configured size
+	if encoded, err := json.Marshal(&pack); err == nil {
+		if len(idx.packs) > 0 {
+			idx.size++ // separating comma
+		}
+		idx.size += len(encoded)
+	}
+	idx.packs = append(idx.packs, pack)
+}
+
+// Has returns true if the blob is in the index
+func (idx *Index) Has(h BlobHandle) bool {
+	_, ok := idx.blobs[h]
+	return ok
+}
+
+// Lookup returns the location of the blob
+func (idx *Index) Lookup(h BlobHandle) (PackedBlob, bool) {
+	blob, ok := idx.blobs[h]
+	return blob, ok
+}
+
+// Count returns the number of blobs in the index
+func (idx *Index) Count() int {
+	return len(idx.blobs)
+}
+
+// Packs returns the IDs of the pack files referenced by the index
+func (idx *Index) Packs() []ID {
+	ids := make([]ID, 0, len(idx.packs))
+	for _, pack := range idx.packs {
+		ids = append(ids, pack.ID)
+	}
+	return ids
+}
+
+// Size returns the (approximate) size of the encoded index, in bytes
+func (idx *Index) Size() int {
+	return idx.size
+}
+
+// Encode serializes the index
+func (idx *Index) Encode() ([]byte, error) {
+	data, err := json.Marshal(&indexJSON{Packs: idx.packs})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode the index.")
+	}
+	return data, nil
+}
+
+// DecodeIndex loads an index from its serialized form
+func DecodeIndex(data []byte) (*Index, error) {
+	decoded := indexJSON{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode the index.")
+	}
+
+	idx := NewIndex()
+	for _, pack := range decoded.Packs {
+		blobs := make([]Blob, 0, len(pack.Blobs))
+		for _, blob := range pack.Blobs {
+			blobs = append(blobs, Blob{
+				BlobHandle:         BlobHandle{ID: blob.ID, Type: blob.Type},
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+				Compression:        blob.Compression,
+			})
+		}
+		idx.StorePack(pack.ID, blobs)
+	}
+
+	return idx, nil
+}
+
+// MasterIndex merges all the index files of a repository into a single lookup table
+type MasterIndex struct {
+	lock  sync.RWMutex
+	blobs map[BlobHandle]PackedBlob
+}
+
+func NewMasterIndex() *MasterIndex {
+	return &MasterIndex{blobs: make(map[BlobHandle]PackedBlob)}
+}
+
+// Insert merges the given index into the master index
+func (mi *MasterIndex) Insert(idx *Index) {
+	mi.lock.Lock()
+	defer mi.lock.Unlock()
+
+	for h, blob := range idx.blobs {
+		mi.blobs[h] = blob
+	}
+}
+
+// Has returns true if the blob is already stored in the repository
+func (mi *MasterIndex) Has(h BlobHandle) bool {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	_, ok := mi.blobs[h]
+	return ok
+}
+
+// Lookup returns the location of the blob
+func (mi *MasterIndex) Lookup(h BlobHandle) (PackedBlob, bool) {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	blob, ok := mi.blobs[h]
+	return blob, ok
+}
+
+// Count returns the number of known blobs
+func (mi *MasterIndex) Count() int {
+	mi.lock.RLock()
+	defer mi.lock.RUnlock()
+
+	return len(mi.blobs)
+}
@@ -0,0 +1,70 @@
+// +build unit
+
+package repository
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+)
+
+func TestIndexEncoding(tst *testing.T) {
+	idx := NewIndex()
+	packID := Hash([]byte("pack"))
+	blob := Blob{BlobHandle: BlobHandle{ID: Hash([]byte("blob")), Type: TreeBlob}, Offset: 10, Length: 20}
+	idx.StorePack(packID, []Blob{blob})
+
+	data, err := idx.Encode()
+	require.NoError(tst, err)
+	assert.Equal(tst, len(data), idx.Size())
+
+	decoded, err := DecodeIndex(data)
+	require.NoError(tst, err)
+
+	found, ok := decoded.Lookup(blob.BlobHandle)
+	require.True(tst, ok)
+	assert.Equal(tst, packID, found.PackID)
+	assert.Equal(tst, blob, found.Blob)
+	assert.False(tst, decoded.Has(BlobHandle{ID: blob.ID, Type: DataBlob}))
+}
+
+func TestRepositoryIndexRollover(tst *testing.T) {
+	cfg := &config.Config{PackFileSizeLimit: 128, IndexFileSizeLimit: 512}
+	repo, backend, cleanup := newTestRepository(tst, cfg)
+	defer cleanup()
+
+	var ids []ID
+	for i := 0; i < 50; i++ {
+		id, err := repo.SaveBlob(DataBlob, []byte(fmt.Sprintf("blob number %d", i)))
+		require.NoError(tst, err)
+		ids = append(ids, id)
+	}
+
+	// Duplicates are not stored again
+	_, err := repo.SaveBlob(DataBlob, []byte("blob number 0"))
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	indexFiles, err := backend.List(storage.IndexFile)
+	require.NoError(tst, err)
+	assert.True(tst, len(indexFiles) > 1, "index files should have been rolled over")
+
+	for _, file := range indexFiles {
+		assert.True(tst, file.Size < 2*int64(cfg.IndexFileSizeLimit))
+	}
+
+	reopened, err := Open(backend, cfg, "secret")
+	require.NoError(tst, err)
+	require.NoError(tst, reopened.LoadIndex())
+	assert.Equal(tst, len(ids), reopened.Index().Count())
+
+	for i, id := range ids {
+		data, err := reopened.LoadBlob(DataBlob, id)
+		require.NoError(tst, err)
+		assert.Equal(tst, fmt.Sprintf("blob number %d", i), string(data))
+	}
+}
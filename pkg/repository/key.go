@@ -0,0 +1,259 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/crypto"
+	"v3io-backup/pkg/storage"
+)
+
+// ErrNoKeyFound is returned when none of the repository keys can be opened with the given password
+var ErrNoKeyFound = errors.New("Wrong password or no key found.")
+
+// Key is a key file, stored in the "keys" directory of the repository. It holds the repository master key,
+// encrypted with a key derived from the user password. Several key files may wrap the same master key.
+type Key struct {
+	Created  time.Time `json:"created"`
+	Username string    `json:"username,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+
+	KDF  string `json:"kdf"`
+	N    int    `json:"N"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+	Data []byte `json:"data"`
+
+	name   string      // the key file name, set when saved or loaded
+	master *crypto.Key // the decrypted master key, set when saved or opened
+}
+
+const kdfScrypt = "scrypt"
+
+// Name returns the name of the key file
+func (k *Key) Name() string {
+	return k.name
+}
+
+// newKey wraps the master key with a key derived from the password and saves it as a new key file
+func newKey(backend storage.Backend, password string, master *crypto.Key) (*Key, error) {
+	if password == "" {
+		return nil, errors.New("Repository password must not be empty.")
+	}
+
+	k := &Key{
+		Created: time.Now().UTC(),
+		KDF:     kdfScrypt,
+		N:       crypto.DefaultKDFParams.N,
+		R:       crypto.DefaultKDFParams.R,
+		P:       crypto.DefaultKDFParams.P,
+		Salt:    crypto.NewSalt(),
+		master:  master,
+	}
+	k.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		k.Username = usr.Username
+	}
+
+	userKey, err := crypto.DeriveKey(password, k.Salt, crypto.KDFParams{N: k.N, R: k.R, P: k.P})
+	if err != nil {
+		return nil, err
+	}
+
+	masterData, err := json.Marshal(master)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode the master key.")
+	}
+	k.Data = userKey.Seal(masterData)
+
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode the key.")
+	}
+
+	k.name = Hash(data).String()
+	if err := backend.Save(storage.Handle{Type: storage.KeyFile, Name: k.name}, data); err != nil {
+		return nil, errors.Wrap(err, "Failed to save the key.")
+	}
+	return k, nil
+}
+
+// loadKey reads the key file with the given name, without opening it
+func loadKey(backend storage.Backend, name string) (*Key, error) {
+	data, err := backend.Load(storage.Handle{Type: storage.KeyFile, Name: name}, 0, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load key %s.", name)
+	}
+
+	k := &Key{name: name}
+	if err := json.Unmarshal(data, k); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode key %s.", name)
+	}
+	return k, nil
+}
+
+// open decrypts the master key with the password
+func (k *Key) open(password string) error {
+	if k.KDF != kdfScrypt {
+		return errors.Errorf("Unsupported key derivation function '%s' of key %s.", k.KDF, k.name)
+	}
+
+	userKey, err := crypto.DeriveKey(password, k.Salt, crypto.KDFParams{N: k.N, R: k.R, P: k.P})
+	if err != nil {
+		return err
+	}
+
+	masterData, err := userKey.Open(k.Data)
+	if err != nil {
+		return err
+	}
+
+	master := &crypto.Key{}
+	if err := json.Unmarshal(masterData, master); err != nil {
+		return errors.Wrapf(err, "Failed to decode the master key of key %s.", k.name)
+	}
+	if !master.Valid() {
+		return errors.Errorf("Invalid master key in key %s.", k.name)
+	}
+
+	k.master = master
+	return nil
+}
+
+// searchKey returns the first key file that can be opened with the password
+func searchKey(backend storage.Backend, password string) (*Key, error) {
+	files, err := backend.List(storage.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list the repository keys.")
+	}
+
+	for _, file := range files {
+		k, err := loadKey(backend, file.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := k.open(password); err != nil {
+			if errors.Cause(err) == crypto.ErrUnauthenticated {
+				continue
+			}
+			return nil, err
+		}
+		return k, nil
+	}
+
+	return nil, ErrNoKeyFound
+}
+
+// ListKeys loads all the key files of the repository, ordered by creation time
+func (r *Repository) ListKeys() ([]*Key, error) {
+	names, err := r.listNames(storage.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(names))
+	for _, name := range names {
+		k, err := loadKey(r.backend, name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+// AddKey stores a new key file wrapping the repository master key with the given password
+func (r *Repository) AddKey(password string) (*Key, error) {
+	return newKey(r.backend, password, r.key)
+}
+
+// RemoveKey removes the key file with the given (possibly shortened) name.
+// The key the repository was opened with can't be removed, so there is always at least one key left.
+func (r *Repository) RemoveKey(prefix string) error {
+	name, err := r.findKeyName(prefix)
+	if err != nil {
+		return err
+	}
+
+	if name == r.keyName {
+		return errors.New("Refusing to remove the key currently used to access the repository.")
+	}
+
+	if err := r.backend.Remove(storage.Handle{Type: storage.KeyFile, Name: name}); err != nil {
+		return errors.Wrapf(err, "Failed to remove key %s.", name)
+	}
+	return nil
+}
+
+// ChangePassword replaces the key the repository was opened with by a new key protected by the given password
+func (r *Repository) ChangePassword(password string) (*Key, error) {
+	k, err := r.AddKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	oldName := r.keyName
+	r.keyName = k.name
+	if err := r.backend.Remove(storage.Handle{Type: storage.KeyFile, Name: oldName}); err != nil {
+		return nil, errors.Wrapf(err, "Failed to remove the old key %s. The new key %s was added.", oldName, k.name)
+	}
+	return k, nil
+}
+
+// findKeyName resolves the key file name from the given (possibly shortened) name
+func (r *Repository) findKeyName(prefix string) (string, error) {
+	if prefix == "" {
+		return "", errors.New("Key ID must be set.")
+	}
+
+	names, err := r.listNames(storage.KeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	var match string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			if match != "" {
+				return "", errors.Errorf("Key ID '%s' is ambiguous.", prefix)
+			}
+			match = name
+		}
+	}
+
+	if match == "" {
+		return "", errors.Errorf("Key '%s' not found.", prefix)
+	}
+	return match, nil
+}
@@ -0,0 +1,54 @@
+// +build unit
+
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+)
+
+func TestKeys(tst *testing.T) {
+	cfg := &config.Config{}
+	repo, backend, cleanup := newTestRepository(tst, cfg)
+	defer cleanup()
+
+	id, err := repo.SaveBlob(DataBlob, []byte("data"))
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	second, err := repo.AddKey("second")
+	require.NoError(tst, err)
+
+	keys, err := repo.ListKeys()
+	require.NoError(tst, err)
+	assert.Len(tst, keys, 2)
+
+	// Both keys open the same master key
+	reopened, err := Open(backend, cfg, "second")
+	require.NoError(tst, err)
+	assert.Equal(tst, second.Name(), reopened.KeyName())
+	require.NoError(tst, reopened.LoadIndex())
+	data, err := reopened.LoadBlob(DataBlob, id)
+	require.NoError(tst, err)
+	assert.Equal(tst, "data", string(data))
+
+	assert.Error(tst, reopened.RemoveKey(second.Name()[:8]))
+	require.NoError(tst, reopened.RemoveKey(repo.KeyName()[:8]))
+	_, err = Open(backend, cfg, "secret")
+	assert.Equal(tst, ErrNoKeyFound, err)
+
+	changed, err := reopened.ChangePassword("third")
+	require.NoError(tst, err)
+	assert.Equal(tst, changed.Name(), reopened.KeyName())
+	_, err = Open(backend, cfg, "second")
+	assert.Equal(tst, ErrNoKeyFound, err)
+	_, err = Open(backend, cfg, "third")
+	require.NoError(tst, err)
+
+	keys, err = reopened.ListKeys()
+	require.NoError(tst, err)
+	assert.Len(tst, keys, 1)
+}
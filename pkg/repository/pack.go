@@ -0,0 +1,196 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/crypto"
+)
+
+// Pack file layout:
+//
+//	[encrypted blob 1][encrypted blob 2]...[encrypted blob n][encrypted header][header length]
+//
+//	header:        version (1 byte) followed by an entry per blob
+//	entry:         type (1 byte) | offset (uint32) | length (uint32) | uncompressed length (uint32) |
+//	               compression (1 byte) | blob ID (32 bytes)
+//	header length: uint32 - the length of the encrypted header, excluding this field
+//
+// Every blob and the header are encrypted separately, so a single blob can be read without reading the entire pack.
+// The blobs are compressed before being encrypted. The offsets and lengths refer to the stored (compressed and
+// encrypted) blobs, while the blob IDs are the hashes of the plaintext. Headers of any other version are rejected.
+// All the integers are little endian. The pack ID is the SHA-256 hash of the entire pack file.
+const (
+	packHeaderVersion     = 2
+	packHeaderEntrySize   = 1 + 4 + 4 + 4 + 1 + idSize
+	packHeaderLengthSize  = 4
+	packMaxHeaderEntries  = 1 << 20
+	packMaxBlobOffset     = math.MaxUint32
+	packMinHeaderSize     = 1 + crypto.Extension
+	packMinHeaderOverhead = packMinHeaderSize + packHeaderLengthSize
+)
+
+// packWriter accumulates encrypted blobs in memory and produces a sealed pack file
+type packWriter struct {
+	key   *crypto.Key
+	buf   bytes.Buffer
+	blobs []Blob
+}
+
+func newPackWriter(key *crypto.Key) *packWriter {
+	return &packWriter{key: key}
+}
+
+// Add encrypts the (possibly compressed) blob data and appends it to the pack. Returns the number of bytes written.
+func (p *packWriter) Add(t BlobType, id ID, data []byte, compression Compression, uncompressedLength uint) (int, error) {
+	offset := p.buf.Len()
+	if uint64(offset)+uint64(len(data))+crypto.Extension > packMaxBlobOffset || uint64(uncompressedLength) > math.MaxUint32 {
+		return 0, errors.Errorf("Pack file is full, can't add blob %s of %d bytes.", id.Str(), len(data))
+	}
+
+	n, err := p.buf.Write(p.key.Seal(data))
+	if err != nil {
+		return n, errors.Wrapf(err, "Failed to add blob %s to the pack.", id.Str())
+	}
+
+	p.blobs = append(p.blobs, Blob{
+		BlobHandle:         BlobHandle{ID: id, Type: t},
+		Offset:             uint(offset),
+		Length:             uint(n),
+		UncompressedLength: uncompressedLength,
+		Compression:        compression,
+	})
+
+	return n, nil
+}
+
+// Size returns the size of the pack file if it was finalized now
+func (p *packWriter) Size() int {
+	return p.buf.Len() + packMinHeaderOverhead + len(p.blobs)*packHeaderEntrySize
+}
+
+// Count returns the number of blobs in the pack
+func (p *packWriter) Count() int {
+	return len(p.blobs)
+}
+
+// Blobs returns the blobs stored in the pack
+func (p *packWriter) Blobs() []Blob {
+	return p.blobs
+}
+
+// Finalize appends the header and returns the pack ID along with the pack file contents
+func (p *packWriter) Finalize() (ID, []byte, error) {
+	header := bytes.Buffer{}
+	header.WriteByte(packHeaderVersion)
+
+	entry := make([]byte, packHeaderEntrySize)
+	for _, blob := range p.blobs {
+		entry[0] = byte(blob.Type)
+		binary.LittleEndian.PutUint32(entry[1:], uint32(blob.Offset))
+		binary.LittleEndian.PutUint32(entry[5:], uint32(blob.Length))
+		binary.LittleEndian.PutUint32(entry[9:], uint32(blob.UncompressedLength))
+		entry[13] = byte(blob.Compression)
+		copy(entry[14:], blob.ID[:])
+		header.Write(entry)
+	}
+
+	encryptedHeader := p.key.Seal(header.Bytes())
+	headerLength := make([]byte, packHeaderLengthSize)
+	binary.LittleEndian.PutUint32(headerLength, uint32(len(encryptedHeader)))
+
+	p.buf.Write(encryptedHeader)
+	p.buf.Write(headerLength)
+
+	data := p.buf.Bytes()
+	return Hash(data), data, nil
+}
+
+// readPackHeader reads and decrypts the list of blobs from the header of the pack file
+func readPackHeader(rd io.ReaderAt, size int64, key *crypto.Key) ([]Blob, error) {
+	if size < packMinHeaderOverhead {
+		return nil, errors.Errorf("Invalid pack file - size %d is too small.", size)
+	}
+
+	headerLengthBuf := make([]byte, packHeaderLengthSize)
+	if _, err := rd.ReadAt(headerLengthBuf, size-packHeaderLengthSize); err != nil {
+		return nil, errors.Wrap(err, "Failed to read the pack header length.")
+	}
+
+	headerLength := int64(binary.LittleEndian.Uint32(headerLengthBuf))
+	if headerLength < packMinHeaderSize || headerLength > size-packHeaderLengthSize ||
+		(headerLength-packMinHeaderSize)/packHeaderEntrySize > packMaxHeaderEntries {
+		return nil, errors.Errorf("Invalid pack file - bad header length %d.", headerLength)
+	}
+
+	encryptedHeader := make([]byte, headerLength)
+	if _, err := rd.ReadAt(encryptedHeader, size-packHeaderLengthSize-headerLength); err != nil {
+		return nil, errors.Wrap(err, "Failed to read the pack header.")
+	}
+
+	header, err := key.Open(encryptedHeader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decrypt the pack header.")
+	}
+
+	return parsePackHeader(header, size-packHeaderLengthSize-headerLength)
+}
+
+func parsePackHeader(header []byte, dataSize int64) ([]Blob, error) {
+	if header[0] != packHeaderVersion {
+		return nil, errors.Errorf("Unsupported pack header version %d.", header[0])
+	}
+
+	if (len(header)-1)%packHeaderEntrySize != 0 {
+		return nil, errors.Errorf("Invalid pack header - bad length %d.", len(header))
+	}
+
+	var blobs []Blob
+	for entry := header[1:]; len(entry) > 0; entry = entry[packHeaderEntrySize:] {
+		blob := Blob{
+			BlobHandle:         BlobHandle{Type: BlobType(entry[0])},
+			Offset:             uint(binary.LittleEndian.Uint32(entry[1:])),
+			Length:             uint(binary.LittleEndian.Uint32(entry[5:])),
+			UncompressedLength: uint(binary.LittleEndian.Uint32(entry[9:])),
+			Compression:        Compression(entry[13]),
+		}
+		copy(blob.ID[:], entry[14:packHeaderEntrySize])
+
+		if blob.Type != DataBlob && blob.Type != TreeBlob {
+			return nil, errors.Errorf("Invalid pack header - unknown type of blob %s.", blob.ID.Str())
+		}
+		if blob.Compression > SnappyCompression {
+			return nil, errors.Errorf("Invalid pack header - unknown compression of blob %s.", blob.ID.Str())
+		}
+		if int64(blob.Offset)+int64(blob.Length) > dataSize {
+			return nil, errors.Errorf("Invalid pack header - blob %s is out of bounds.", blob.ID.Str())
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
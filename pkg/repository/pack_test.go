@@ -0,0 +1,72 @@
+// +build unit
+
+package repository
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/crypto"
+)
+
+func TestPackRoundTrip(tst *testing.T) {
+	blobs := [][]byte{
+		[]byte("first blob"),
+		bytes.Repeat([]byte{0x42}, 4096),
+		[]byte("third blob"),
+	}
+
+	key := crypto.NewRandomKey()
+	p := newPackWriter(key)
+	for i, data := range blobs {
+		t := DataBlob
+		if i == 2 {
+			t = TreeBlob
+		}
+		n, err := p.Add(t, Hash(data), data, NoCompression, 0)
+		require.NoError(tst, err)
+		assert.Equal(tst, len(data)+crypto.Extension, n)
+	}
+
+	expectedSize := p.Size()
+	id, data, err := p.Finalize()
+	require.NoError(tst, err)
+	assert.Equal(tst, expectedSize, len(data))
+	assert.Equal(tst, Hash(data), id)
+
+	entries, err := readPackHeader(bytes.NewReader(data), int64(len(data)), key)
+	require.NoError(tst, err)
+	require.Len(tst, entries, len(blobs))
+
+	for i, entry := range entries {
+		assert.Equal(tst, Hash(blobs[i]), entry.ID)
+		plaintext, err := key.Open(data[entry.Offset : entry.Offset+entry.Length])
+		require.NoError(tst, err)
+		assert.Equal(tst, blobs[i], plaintext)
+	}
+	assert.Equal(tst, TreeBlob, entries[2].Type)
+
+	// The header can't be read without the key
+	_, err = readPackHeader(bytes.NewReader(data), int64(len(data)), crypto.NewRandomKey())
+	assert.Error(tst, err)
+}
+
+func TestPackHeaderCorruption(tst *testing.T) {
+	key := crypto.NewRandomKey()
+	p := newPackWriter(key)
+	_, err := p.Add(DataBlob, Hash([]byte("data")), []byte("data"), NoCompression, 0)
+	require.NoError(tst, err)
+	_, data, err := p.Finalize()
+	require.NoError(tst, err)
+
+	// Header length pointing beyond the file
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1] = 0xff
+	_, err = readPackHeader(bytes.NewReader(corrupted), int64(len(corrupted)), key)
+	assert.Error(tst, err)
+
+	_, err = readPackHeader(bytes.NewReader(data[:3]), 3, key)
+	assert.Error(tst, err)
+}
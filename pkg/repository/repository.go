@@ -0,0 +1,328 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/crypto"
+	"v3io-backup/pkg/storage"
+)
+
+// Repository stores content-addressed blobs in pack files, and keeps track of them in index files.
+// All the stored files (except the key files) are encrypted with the repository master key.
+type Repository struct {
+	cfg     *config.Config
+	backend storage.Backend
+	config  Config
+	key     *crypto.Key // the master key
+	keyName string      // the name of the key file used for opening the repository
+
+	compressor *compressor
+
+	index *MasterIndex
+
+	packerLock sync.Mutex
+	packer     *packWriter
+	inFlight   map[BlobHandle]struct{} // blobs stored in packs that are not indexed yet
+
+	indexLock    sync.Mutex
+	pendingIndex *Index // index of the packs saved by this session, not stored yet
+}
+
+// New returns a repository stored in the given backend
+func New(backend storage.Backend, cfg *config.Config) *Repository {
+	return &Repository{
+		cfg:          cfg,
+		backend:      backend,
+		index:        NewMasterIndex(),
+		inFlight:     make(map[BlobHandle]struct{}),
+		pendingIndex: NewIndex(),
+	}
+}
+
+// Config returns the repository configuration
+func (r *Repository) Config() Config {
+	return r.config
+}
+
+// Location returns the location of the repository
+func (r *Repository) Location() string {
+	return r.backend.Location()
+}
+
+// Backend returns the storage backend of the repository
+func (r *Repository) Backend() storage.Backend {
+	return r.backend
+}
+
+// KeyName returns the name of the key file the repository was opened with
+func (r *Repository) KeyName() string {
+	return r.keyName
+}
+
+func (r *Repository) setConfig(repoConfig Config) error {
+	compressor, err := newCompressor(repoConfig.Compression, repoConfig.CompressionAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	r.config = repoConfig
+	r.compressor = compressor
+	return nil
+}
+
+func (r *Repository) useKey(k *Key) {
+	r.key = k.master
+	r.keyName = k.name
+}
+
+// Index returns the master index of the repository
+func (r *Repository) Index() *MasterIndex {
+	return r.index
+}
+
+// LoadIndex loads all the index files of the repository into the master index
+func (r *Repository) LoadIndex() error {
+	files, err := r.backend.List(storage.IndexFile)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		id, err := ParseID(file.Name)
+		if err != nil {
+			// Not an index file
+			continue
+		}
+
+		data, err := r.loadUnpacked(storage.IndexFile, id)
+		if err != nil {
+			return err
+		}
+
+		idx, err := DecodeIndex(data)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to load index '%s'.", file.Name)
+		}
+		r.index.Insert(idx)
+	}
+
+	return nil
+}
+
+// SaveBlob adds the blob to the current pack file and returns the blob ID.
+// Blobs already stored in the repository are not saved again.
+// The pack is sealed and uploaded once it reaches the configured PackFileSizeLimit.
+func (r *Repository) SaveBlob(t BlobType, data []byte) (ID, error) {
+	id := Hash(data)
+	h := BlobHandle{ID: id, Type: t}
+
+	if r.index.Has(h) {
+		return id, nil
+	}
+
+	// Compress outside of the lock, so blobs are compressed concurrently
+	stored, compression := r.compressor.Compress(data)
+	var uncompressedLength uint
+	if compression != NoCompression {
+		uncompressedLength = uint(len(data))
+	}
+
+	r.packerLock.Lock()
+	if _, ok := r.inFlight[h]; ok {
+		r.packerLock.Unlock()
+		return id, nil
+	}
+	if r.packer == nil {
+		r.packer = newPackWriter(r.key)
+	}
+	if _, err := r.packer.Add(t, id, stored, compression, uncompressedLength); err != nil {
+		r.packerLock.Unlock()
+		return id, err
+	}
+	r.inFlight[h] = struct{}{}
+
+	var full *packWriter
+	if r.packer.Size() >= r.cfg.PackFileSizeLimit {
+		full, r.packer = r.packer, nil
+	}
+	r.packerLock.Unlock()
+
+	if full != nil {
+		return id, r.savePack(full)
+	}
+	return id, nil
+}
+
+// LoadBlob reads the blob from the pack file containing it
+func (r *Repository) LoadBlob(t BlobType, id ID) ([]byte, error) {
+	blob, ok := r.index.Lookup(BlobHandle{ID: id, Type: t})
+	if !ok {
+		return nil, errors.Errorf("Blob %s (%s) not found in the index.", id.Str(), t)
+	}
+
+	ciphertext, err := r.backend.Load(packHandle(blob.PackID), int(blob.Length), int64(blob.Offset))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read blob %s from pack %s.", id.Str(), blob.PackID.Str())
+	}
+
+	data, err := r.key.Open(ciphertext)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decrypt blob %s from pack %s.", id.Str(), blob.PackID.Str())
+	}
+
+	if data, err = decompress(data, blob.Compression, blob.UncompressedLength); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read blob %s from pack %s.", id.Str(), blob.PackID.Str())
+	}
+
+	if Hash(data) != id {
+		return nil, errors.Errorf("Blob %s read from pack %s is corrupted.", id.Str(), blob.PackID.Str())
+	}
+
+	return data, nil
+}
+
+// Flush seals and uploads the pending pack file, if any, and stores the pending index
+func (r *Repository) Flush() error {
+	r.packerLock.Lock()
+	pending := r.packer
+	r.packer = nil
+	r.packerLock.Unlock()
+
+	if pending != nil && pending.Count() > 0 {
+		if err := r.savePack(pending); err != nil {
+			return err
+		}
+	}
+
+	r.indexLock.Lock()
+	defer r.indexLock.Unlock()
+	return r.saveIndex()
+}
+
+func (r *Repository) savePack(p *packWriter) error {
+	id, data, err := p.Finalize()
+	if err != nil {
+		r.removeInFlight(p)
+		return err
+	}
+
+	if err := r.backend.Save(packHandle(id), data); err != nil {
+		// The blobs were not stored, so they must be saved again when added later
+		r.removeInFlight(p)
+		return errors.Wrapf(err, "Failed to save pack %s.", id.Str())
+	}
+
+	// The pack is durable, register its blobs within the index
+	idx := NewIndex()
+	idx.StorePack(id, p.Blobs())
+	r.index.Insert(idx)
+	r.removeInFlight(p)
+
+	r.indexLock.Lock()
+	defer r.indexLock.Unlock()
+
+	r.pendingIndex.StorePack(id, p.Blobs())
+	if r.pendingIndex.Size() >= r.cfg.IndexFileSizeLimit {
+		return r.saveIndex()
+	}
+	return nil
+}
+
+// removeInFlight forgets the blobs of the pack, once indexed or failed to be stored
+func (r *Repository) removeInFlight(p *packWriter) {
+	r.packerLock.Lock()
+	defer r.packerLock.Unlock()
+
+	for _, blob := range p.Blobs() {
+		delete(r.inFlight, blob.BlobHandle)
+	}
+}
+
+// saveIndex stores the pending index as a new index file. Must be called with indexLock held.
+func (r *Repository) saveIndex() error {
+	if r.pendingIndex.Count() == 0 {
+		return nil
+	}
+
+	data, err := r.pendingIndex.Encode()
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.saveUnpacked(storage.IndexFile, data); err != nil {
+		return err
+	}
+
+	r.pendingIndex = NewIndex()
+	return nil
+}
+
+// saveUnpacked encrypts the data and stores it as a file of the given type.
+// The file is named after the ID of its (encrypted) contents.
+func (r *Repository) saveUnpacked(t storage.FileType, data []byte) (ID, error) {
+	ciphertext := r.key.Seal(data)
+	id := Hash(ciphertext)
+	if err := r.backend.Save(storage.Handle{Type: t, Name: id.String()}, ciphertext); err != nil {
+		return ID{}, errors.Wrapf(err, "Failed to save '%s/%s'.", t, id.Str())
+	}
+	return id, nil
+}
+
+// loadUnpacked reads, verifies and decrypts the file of the given type and ID
+func (r *Repository) loadUnpacked(t storage.FileType, id ID) ([]byte, error) {
+	ciphertext, err := r.backend.Load(storage.Handle{Type: t, Name: id.String()}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if Hash(ciphertext) != id {
+		return nil, errors.Errorf("File '%s/%s' is corrupted.", t, id.Str())
+	}
+
+	data, err := r.key.Open(ciphertext)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decrypt '%s/%s'.", t, id.Str())
+	}
+	return data, nil
+}
+
+// packHandle returns the handle of the pack file with the given ID
+func packHandle(id ID) storage.Handle {
+	return storage.Handle{Type: storage.PackFile, Name: id.String()}
+}
+
+// listNames returns the names of the stored files of the given type
+func (r *Repository) listNames(t storage.FileType) ([]string, error) {
+	files, err := r.backend.List(t)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names, nil
+}
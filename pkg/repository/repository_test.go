@@ -0,0 +1,70 @@
+// +build unit
+
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+	"v3io-backup/pkg/storage/local"
+)
+
+// newTestRepository initializes a repository with the given configuration (and the defaults) within a new
+// temporary directory, which is removed by the returned cleanup function
+func newTestRepository(tst *testing.T, cfg *config.Config) (*Repository, storage.Backend, func()) {
+	dir, err := ioutil.TempDir("", "v3io-backup-repo")
+	require.NoError(tst, err)
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	backend, err := local.New(dir)
+	require.NoError(tst, err)
+	repo, err := Init(backend, config.WithDefaults(cfg), "secret")
+	require.NoError(tst, err)
+
+	return repo, backend, cleanup
+}
+
+// failingBackend fails to save the pack files while failPacks is set
+type failingBackend struct {
+	storage.Backend
+	failPacks bool
+}
+
+func (fb *failingBackend) Save(h storage.Handle, data []byte) error {
+	if fb.failPacks && h.Type == storage.PackFile {
+		return errors.New("injected failure")
+	}
+	return fb.Backend.Save(h, data)
+}
+
+func TestFailedPackIsSavedAgain(tst *testing.T) {
+	cfg := &config.Config{}
+	_, backend, cleanup := newTestRepository(tst, cfg)
+	defer cleanup()
+
+	failing := &failingBackend{Backend: backend, failPacks: true}
+	repo, err := Open(failing, cfg, "secret")
+	require.NoError(tst, err)
+
+	id, err := repo.SaveBlob(DataBlob, []byte("data"))
+	require.NoError(tst, err)
+	assert.Error(tst, repo.Flush())
+
+	// The blob was not stored, so it's not skipped as a duplicate
+	failing.failPacks = false
+	_, err = repo.SaveBlob(DataBlob, []byte("data"))
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	data, err := repo.LoadBlob(DataBlob, id)
+	require.NoError(tst, err)
+	assert.Equal(tst, "data", string(data))
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata files (e.g. the schema of a table) are named with this prefix
+const MetadataFilePrefix = ".#"
+
+// Name of the schema file of the NoSQL and TSDB tables
+const SchemaFileName = ".#schema"
+
+// Schema is the parsed schema file of a table, recorded along with the file
+type Schema struct {
+	Key        string        `json:"key,omitempty"`
+	SortingKey string        `json:"sorting_key,omitempty"`
+	Fields     []SchemaField `json:"fields,omitempty"`
+	TSDB       bool          `json:"tsdb,omitempty"`
+}
+
+// SchemaField is an attribute of the table items, along with its type
+type SchemaField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// IsMetadataFile returns true if the file with the given name holds metadata (e.g. a table schema)
+func IsMetadataFile(name string) bool {
+	return strings.HasPrefix(name, MetadataFilePrefix)
+}
+
+// ParseSchema parses and validates the contents of a schema file - either a NoSQL table schema, which must name
+// the key attribute and the types of all its fields, or a TSDB table schema
+func ParseSchema(data []byte) (*Schema, error) {
+	var raw struct {
+		Key                 string          `json:"key"`
+		SortingKey          string          `json:"sortingKey"`
+		Fields              []SchemaField   `json:"fields"`
+		PartitionSchemaInfo json.RawMessage `json:"partitionSchemaInfo"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "Schema is not a valid JSON document.")
+	}
+
+	if len(raw.PartitionSchemaInfo) > 0 {
+		return &Schema{TSDB: true}, nil
+	}
+
+	if raw.Key == "" {
+		return nil, errors.New("Schema has no key attribute.")
+	}
+	for i, field := range raw.Fields {
+		if field.Name == "" {
+			return nil, errors.Errorf("Field %d of the schema has no name.", i)
+		}
+		if field.Type == "" {
+			return nil, errors.Errorf("Field '%s' of the schema has no type.", field.Name)
+		}
+	}
+
+	return &Schema{Key: raw.Key, SortingKey: raw.SortingKey, Fields: raw.Fields}, nil
+}
@@ -0,0 +1,34 @@
+// +build unit
+
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchema(tst *testing.T) {
+	schema, err := ParseSchema([]byte(`{"fields": [{"name": "id", "type": "long", "nullable": false},
+		{"name": "city", "type": "string", "nullable": true}], "key": "id", "hashingBucketNum": 0}`))
+	require.NoError(tst, err)
+	assert.Equal(tst, &Schema{Key: "id", Fields: []SchemaField{{"id", "long"}, {"city", "string"}}}, schema)
+
+	schema, err = ParseSchema([]byte(`{"tableSchemaInfo": {}, "partitionSchemaInfo": {"partitionerInterval": "2d"}}`))
+	require.NoError(tst, err)
+	assert.True(tst, schema.TSDB)
+
+	for _, invalid := range []string{
+		``,
+		`{"key": "id"`,
+		`{"fields": [{"name": "id", "type": "long"}]}`,
+		`{"key": "id", "fields": [{"name": "id"}]}`,
+	} {
+		_, err := ParseSchema([]byte(invalid))
+		assert.Error(tst, err, invalid)
+	}
+
+	assert.True(tst, IsMetadataFile(".#schema"))
+	assert.False(tst, IsMetadataFile("item"))
+}
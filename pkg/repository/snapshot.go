@@ -0,0 +1,217 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/storage"
+)
+
+// Snapshot describes the state of the backed up paths at a point in time. Incremental snapshots only
+// describe the entries modified after ModifiedAfter.
+type Snapshot struct {
+	Time          time.Time  `json:"time"`
+	Hostname      string     `json:"hostname"`
+	Endpoint      string     `json:"endpoint"`
+	Container     string     `json:"container"`
+	Paths         []string   `json:"paths"`
+	Excludes      []string   `json:"excludes,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Tree          *ID        `json:"tree"`
+	Parent        *ID        `json:"parent,omitempty"`
+	ModifiedAfter *time.Time `json:"modified_after,omitempty"`
+	Version       string     `json:"version,omitempty"`
+
+	id *ID // the ID of the snapshot file, set when saved or loaded
+}
+
+// ID returns the snapshot ID, nil if the snapshot was not saved yet
+func (sn *Snapshot) ID() *ID {
+	return sn.id
+}
+
+// Incremental returns true if the snapshot only holds the entries modified after ModifiedAfter,
+// rather than the complete state of the paths
+func (sn *Snapshot) Incremental() bool {
+	return sn.ModifiedAfter != nil
+}
+
+// SaveSnapshot stores the snapshot document in the repository and returns its ID
+func (r *Repository) SaveSnapshot(sn *Snapshot) (ID, error) {
+	data, err := json.MarshalIndent(sn, "", "  ")
+	if err != nil {
+		return ID{}, errors.Wrap(err, "Failed to encode the snapshot.")
+	}
+
+	id, err := r.saveUnpacked(storage.SnapshotFile, data)
+	if err != nil {
+		return ID{}, err
+	}
+
+	sn.id = &id
+	return id, nil
+}
+
+// LoadSnapshot reads the snapshot document with the given ID
+func (r *Repository) LoadSnapshot(id ID) (*Snapshot, error) {
+	data, err := r.loadUnpacked(storage.SnapshotFile, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sn := &Snapshot{}
+	if err := json.Unmarshal(data, sn); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode snapshot %s.", id.Str())
+	}
+
+	sn.id = &id
+	return sn, nil
+}
+
+// ListSnapshots loads all the snapshot documents of the repository, ordered by time
+func (r *Repository) ListSnapshots() ([]*Snapshot, error) {
+	names, err := r.listNames(storage.SnapshotFile)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Snapshot, 0, len(names))
+	for _, name := range names {
+		id, err := ParseID(name)
+		if err != nil {
+			// Not a snapshot file
+			continue
+		}
+
+		sn, err := r.LoadSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, sn)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	return snapshots, nil
+}
+
+// FindSnapshot resolves the snapshot ID from the given (possibly shortened) ID, or "latest" for the most recent
+// snapshot matching the filter
+func (r *Repository) FindSnapshot(prefix string, filter *SnapshotFilter) (ID, error) {
+	if prefix == "" {
+		return ID{}, errors.New("Snapshot ID must be set.")
+	}
+
+	if prefix == "latest" {
+		sn, err := r.LatestSnapshot(filter)
+		if err != nil {
+			return ID{}, err
+		}
+		if sn == nil {
+			return ID{}, errors.New("No matching snapshots found in the repository.")
+		}
+		return *sn.ID(), nil
+	}
+
+	names, err := r.listNames(storage.SnapshotFile)
+	if err != nil {
+		return ID{}, err
+	}
+
+	var match string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			if match != "" {
+				return ID{}, errors.Errorf("Snapshot ID '%s' is ambiguous.", prefix)
+			}
+			match = name
+		}
+	}
+
+	if match == "" {
+		return ID{}, errors.Errorf("Snapshot '%s' not found.", prefix)
+	}
+	return ParseID(match)
+}
+
+// LatestSnapshot returns the most recent snapshot matching the filter, nil if there's none
+func (r *Repository) LatestSnapshot(filter *SnapshotFilter) (*Snapshot, error) {
+	snapshots, err := r.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if filter.Matches(snapshots[i]) {
+			return snapshots[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// SnapshotFilter selects snapshots. Empty criteria match any snapshot.
+type SnapshotFilter struct {
+	Hosts      []string // any of the hosts
+	Containers []string // any of the containers
+	Paths      []string // all of the paths
+	Tags       []string // all of the tags
+	Complete   bool     // only the complete (not incremental) snapshots
+}
+
+// Matches returns true if the snapshot satisfies all the filter criteria
+func (f *SnapshotFilter) Matches(sn *Snapshot) bool {
+	if f.Complete && sn.Incremental() {
+		return false
+	}
+	if len(f.Hosts) > 0 && !containsString(f.Hosts, sn.Hostname) {
+		return false
+	}
+	if len(f.Containers) > 0 && !containsString(f.Containers, sn.Container) {
+		return false
+	}
+	for _, p := range f.Paths {
+		if !containsString(sn.Paths, p) {
+			return false
+		}
+	}
+	for _, tag := range f.Tags {
+		if !containsString(sn.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// +build unit
+
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+)
+
+func TestSnapshotFilter(tst *testing.T) {
+	sn := &Snapshot{
+		Hostname:  "backup-1",
+		Container: "bigdata",
+		Paths:     []string{"/tables", "/streams"},
+		Tags:      []string{"nightly", "prod"},
+	}
+
+	for _, test := range []struct {
+		filter  SnapshotFilter
+		matches bool
+	}{
+		{SnapshotFilter{}, true},
+		{SnapshotFilter{Hosts: []string{"backup-2", "backup-1"}}, true},
+		{SnapshotFilter{Hosts: []string{"backup-2"}}, false},
+		{SnapshotFilter{Containers: []string{"users", "bigdata"}}, true},
+		{SnapshotFilter{Containers: []string{"users"}}, false},
+		{SnapshotFilter{Paths: []string{"/streams", "/tables"}}, true},
+		{SnapshotFilter{Paths: []string{"/tables", "/logs"}}, false},
+		{SnapshotFilter{Tags: []string{"nightly"}}, true},
+		{SnapshotFilter{Tags: []string{"nightly", "test"}}, false},
+		{SnapshotFilter{Hosts: []string{"backup-1"}, Containers: []string{"bigdata"}, Tags: []string{"prod"}}, true},
+		{SnapshotFilter{Hosts: []string{"backup-1"}, Containers: []string{"users"}, Tags: []string{"prod"}}, false},
+		{SnapshotFilter{Complete: true}, true},
+	} {
+		assert.Equal(tst, test.matches, test.filter.Matches(sn), "%+v", test.filter)
+	}
+
+	// Incremental snapshots are skipped when only the complete snapshots are selected
+	modifiedAfter := time.Now().Add(-time.Hour)
+	sn.ModifiedAfter = &modifiedAfter
+	assert.True(tst, sn.Incremental())
+	assert.True(tst, (&SnapshotFilter{Containers: []string{"bigdata"}}).Matches(sn))
+	assert.False(tst, (&SnapshotFilter{Containers: []string{"bigdata"}, Complete: true}).Matches(sn))
+}
+
+func TestFindSnapshot(tst *testing.T) {
+	repo, _, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	_, err := repo.FindSnapshot("latest", &SnapshotFilter{})
+	assert.Error(tst, err)
+	latest, err := repo.LatestSnapshot(&SnapshotFilter{})
+	require.NoError(tst, err)
+	assert.Nil(tst, latest)
+
+	// 17 snapshots, so at least two of the IDs share their first hex digit
+	startTime := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	byPrefix := make(map[string][]ID)
+	var snapshots []*Snapshot
+	for i := 0; i < 17; i++ {
+		sn := &Snapshot{Time: startTime.Add(time.Duration(i) * time.Hour), Hostname: fmt.Sprintf("host-%d", i%2)}
+		id, err := repo.SaveSnapshot(sn)
+		require.NoError(tst, err)
+		byPrefix[id.String()[:1]] = append(byPrefix[id.String()[:1]], id)
+		snapshots = append(snapshots, sn)
+	}
+
+	for _, sn := range snapshots {
+		id, err := repo.FindSnapshot(sn.ID().Str(), &SnapshotFilter{})
+		require.NoError(tst, err)
+		assert.Equal(tst, *sn.ID(), id)
+
+		id, err = repo.FindSnapshot(sn.ID().String(), &SnapshotFilter{})
+		require.NoError(tst, err)
+		assert.Equal(tst, *sn.ID(), id)
+	}
+
+	for prefix, ids := range byPrefix {
+		if len(ids) > 1 {
+			_, err := repo.FindSnapshot(prefix, &SnapshotFilter{})
+			require.Error(tst, err)
+			assert.Contains(tst, err.Error(), "ambiguous")
+		}
+	}
+
+	_, err = repo.FindSnapshot("", &SnapshotFilter{})
+	assert.Error(tst, err)
+	_, err = repo.FindSnapshot("x", &SnapshotFilter{})
+	assert.Error(tst, err)
+
+	// The latest snapshot is the most recent one, not the last one listed (files are listed by ID)
+	id, err := repo.FindSnapshot("latest", &SnapshotFilter{})
+	require.NoError(tst, err)
+	assert.Equal(tst, *snapshots[16].ID(), id)
+
+	latest, err = repo.LatestSnapshot(&SnapshotFilter{Hosts: []string{"host-1"}})
+	require.NoError(tst, err)
+	require.NotNil(tst, latest)
+	assert.Equal(tst, snapshots[15].ID(), latest.ID())
+
+	latest, err = repo.LatestSnapshot(&SnapshotFilter{Hosts: []string{"host-2"}})
+	require.NoError(tst, err)
+	assert.Nil(tst, latest)
+
+	// An incremental snapshot is the latest one only when incremental snapshots are allowed
+	modifiedAfter := startTime
+	incremental := &Snapshot{Time: startTime.Add(17 * time.Hour), Hostname: "host-0", ModifiedAfter: &modifiedAfter}
+	_, err = repo.SaveSnapshot(incremental)
+	require.NoError(tst, err)
+	id, err = repo.FindSnapshot("latest", &SnapshotFilter{})
+	require.NoError(tst, err)
+	assert.Equal(tst, *incremental.ID(), id)
+	id, err = repo.FindSnapshot("latest", &SnapshotFilter{Complete: true})
+	require.NoError(tst, err)
+	assert.Equal(tst, *snapshots[16].ID(), id)
+
+	// The incremental snapshot is still found by its ID
+	id, err = repo.FindSnapshot(incremental.ID().Str(), &SnapshotFilter{Complete: true})
+	require.NoError(tst, err)
+	assert.Equal(tst, *incremental.ID(), id)
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package repository
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Node types
+const (
+	NodeTypeFile     = "file"
+	NodeTypeMetadata = "metadata" // a metadata file of a table (e.g. its schema), restored before the other files
+	NodeTypeDir      = "dir"
+	NodeTypeStream   = "stream"
+)
+
+// Node is a single entry of a directory. Files list the IDs of their content chunks, directories reference
+// the tree of their entries. A snapshot is thus a Merkle tree rooted in a single tree ID, and directories
+// that didn't change between snapshots are stored once. NoSQL table directories also list the IDs of the
+// blobs holding their items (see the table package) - TSDB partitions are such tables, recording the
+// partition metadata as well. Streams describe the blobs of the records of every shard.
+type Node struct {
+	Name               string     `json:"name"`
+	Type               string     `json:"type"`
+	Size               uint64     `json:"size,omitempty"`
+	ModTime            time.Time  `json:"mtime"`
+	Content            []ID       `json:"content,omitempty"`
+	ExtendedAttributes Attributes `json:"xattrs,omitempty"`
+	Subtree            *ID        `json:"subtree,omitempty"`
+	Items              []ID       `json:"items,omitempty"`
+	ItemCount          uint64     `json:"item_count,omitempty"`
+	Stream             *Stream    `json:"stream,omitempty"`
+	Partition          *Partition `json:"partition,omitempty"`
+	Schema             *Schema    `json:"schema,omitempty"`
+}
+
+// Partition describes a backed up TSDB partition. Sealed partitions that didn't change since the previous
+// snapshot are not read again - the node of the previous snapshot is reused, if it holds all the items.
+type Partition struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Sealed   bool      `json:"sealed"`
+	Complete bool      `json:"complete"` // all the items were backed up, not only the recently modified ones
+
+	// The summary of the items of the partition, read before backing them up. Nil if the data source
+	// can't summarize partitions.
+	Stats *PartitionStats `json:"stats,omitempty"`
+}
+
+// PartitionStats summarizes the items of a TSDB partition
+type PartitionStats struct {
+	ItemCount     uint64    `json:"item_count"`
+	LatestModTime time.Time `json:"latest_mod_time"`
+}
+
+// Unchanged returns true if the partition is sealed, complete, describes the same time range and its items
+// have the same summary
+func (p *Partition) Unchanged(other *Partition) bool {
+	return p.Sealed && p.Complete && other.Sealed && p.Start.Equal(other.Start) && p.End.Equal(other.End) &&
+		p.Stats != nil && other.Stats != nil && p.Stats.ItemCount == other.Stats.ItemCount &&
+		p.Stats.LatestModTime.Equal(other.Stats.LatestModTime)
+}
+
+// Stream describes a backed up stream. The records of every shard are stored in sequence order,
+// encoded as table items.
+type Stream struct {
+	ShardCount           int           `json:"shard_count"`
+	RetentionPeriodHours int           `json:"retention_period_hours"`
+	Shards               []StreamShard `json:"shards"`
+}
+
+// StreamShard describes the backed up records of a single stream shard
+type StreamShard struct {
+	Records             []ID   `json:"records,omitempty"`
+	RecordCount         uint64 `json:"record_count,omitempty"`
+	FirstSequenceNumber uint64 `json:"first_sequence_number,omitempty"`
+	LastSequenceNumber  uint64 `json:"last_sequence_number,omitempty"`
+}
+
+// Tree lists the entries of a single directory, sorted by name
+type Tree struct {
+	Nodes []*Node `json:"nodes"`
+}
+
+// Insert adds the node to the tree
+func (t *Tree) Insert(node *Node) {
+	t.Nodes = append(t.Nodes, node)
+}
+
+// Sort orders the nodes by name
+func (t *Tree) Sort() {
+	sort.Slice(t.Nodes, func(i, j int) bool {
+		return t.Nodes[i].Name < t.Nodes[j].Name
+	})
+}
+
+// Find returns the node with the given name, nil if not found. The tree must be sorted.
+func (t *Tree) Find(name string) *Node {
+	i := sort.Search(len(t.Nodes), func(i int) bool {
+		return t.Nodes[i].Name >= name
+	})
+	if i < len(t.Nodes) && t.Nodes[i].Name == name {
+		return t.Nodes[i]
+	}
+	return nil
+}
+
+// SaveTree stores the tree as a tree blob and returns its ID
+func (r *Repository) SaveTree(t *Tree) (ID, error) {
+	t.Sort()
+	data, err := json.Marshal(t)
+	if err != nil {
+		return ID{}, errors.Wrap(err, "Failed to encode the tree.")
+	}
+
+	return r.SaveBlob(TreeBlob, data)
+}
+
+// LoadTree reads the tree blob with the given ID
+func (r *Repository) LoadTree(id ID) (*Tree, error) {
+	data, err := r.LoadBlob(TreeBlob, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tree{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode tree %s.", id.Str())
+	}
+	return t, nil
+}
+
+// FindNode returns the node of the entry at the given absolute path in the tree rooted at the given ID,
+// nil if there's no such entry
+func (r *Repository) FindNode(id ID, p string) (*Node, error) {
+	var node *Node
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			return nil, errors.New("The root directory has no node.")
+		}
+		if node != nil {
+			if node.Subtree == nil {
+				return nil, nil
+			}
+			id = *node.Subtree
+		}
+
+		t, err := r.LoadTree(id)
+		if err != nil {
+			return nil, err
+		}
+		if node = t.Find(name); node == nil {
+			return nil, nil
+		}
+	}
+	return node, nil
+}
+
+// ErrSkipDir is returned by a WalkFunc to skip the entries of the directory it was called for (no-op for files)
+var ErrSkipDir = errors.New("Skip this directory.")
+
+// WalkFunc is called for every entry of the walked tree, with the absolute path of the entry
+type WalkFunc func(p string, node *Node) error
+
+// WalkTree calls fn for every entry of the tree rooted at the given ID, depth first and in name order.
+// A directory is visited before its entries.
+func (r *Repository) WalkTree(id ID, fn WalkFunc) error {
+	return r.walkTree(id, "/", fn)
+}
+
+func (r *Repository) walkTree(id ID, dir string, fn WalkFunc) error {
+	t, err := r.LoadTree(id)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to load the tree of '%s'.", dir)
+	}
+
+	for _, node := range t.Nodes {
+		p := path.Join(dir, node.Name)
+		if err := fn(p, node); err != nil {
+			if err == ErrSkipDir {
+				continue
+			}
+			return err
+		}
+
+		if node.Type == NodeTypeDir && node.Subtree != nil {
+			if err := r.walkTree(*node.Subtree, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TreeBuilder assembles the directory hierarchy from the entries of an ordered scan, added in any order within
+// a directory. The tree of a directory is saved as soon as the scan is done with it (see Finish), all its
+// reserved entries were added and the trees of its subdirectories were saved, so only the trees of the pending
+// directories are kept in memory. The remaining trees are saved bottom-up by Save. Not safe for concurrent use.
+type TreeBuilder struct {
+	repo     *Repository
+	dirs     map[string]*pendingDir // the directories whose tree was not saved yet, by path
+	reserved map[string]bool        // the entries that will be added later, by path
+	finished map[string]bool        // the reserved directories the scan is done with, by path
+}
+
+// pendingDir is a directory whose tree was not saved yet
+type pendingDir struct {
+	node     *Node            // the node of the directory in the tree of its parent, nil for the root
+	tree     Tree             // the entries of the directory
+	names    map[string]*Node // the entries of the directory, by name
+	pending  int              // the reserved entries and the subdirectories whose trees were not saved yet
+	finished bool             // the scan is done with the directory
+}
+
+func NewTreeBuilder(r *Repository) *TreeBuilder {
+	return &TreeBuilder{
+		repo:     r,
+		dirs:     map[string]*pendingDir{"/": {names: make(map[string]*Node)}},
+		reserved: make(map[string]bool),
+		finished: make(map[string]bool),
+	}
+}
+
+// Reserve announces the entry at the given absolute path, which is added later on. The tree of its parent
+// directory isn't saved until the entry is added or released.
+func (b *TreeBuilder) Reserve(p string) error {
+	p = path.Clean("/" + p)
+	if b.reserved[p] {
+		return errors.Errorf("Entry '%s' was already reserved.", p)
+	}
+	parent, err := b.dir(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	parent.pending++
+	b.reserved[p] = true
+	return nil
+}
+
+// Release drops the reservation of the entry at the given absolute path, which won't be added
+func (b *TreeBuilder) Release(p string) error {
+	p = path.Clean("/" + p)
+	if !b.reserved[p] {
+		return nil
+	}
+	delete(b.reserved, p)
+	delete(b.finished, p)
+	return b.release(path.Dir(p))
+}
+
+// Add inserts the node of the entry at the given absolute path. The node name is set from the path.
+// Missing parent directories are added implicitly, and updated if added explicitly later on.
+func (b *TreeBuilder) Add(p string, node *Node) error {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return errors.New("Can't add the root directory to the tree.")
+	}
+	node.Name = path.Base(p)
+
+	parent, err := b.dir(path.Dir(p))
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := parent.names[node.Name]; ok {
+		if existing.Type != NodeTypeDir || node.Type != NodeTypeDir {
+			return errors.Errorf("Entry '%s' was already added.", p)
+		}
+
+		// Added implicitly before, keep its place in the parent tree (and its subtree if already saved)
+		existing.Size = node.Size
+		existing.ModTime = node.ModTime
+		existing.ExtendedAttributes = node.ExtendedAttributes
+		existing.Items = node.Items
+		existing.ItemCount = node.ItemCount
+		existing.Partition = node.Partition
+	} else {
+		parent.tree.Insert(node)
+		parent.names[node.Name] = node
+		if node.Type == NodeTypeDir {
+			b.dirs[p] = &pendingDir{node: node, names: make(map[string]*Node), finished: b.finished[p]}
+			parent.pending++
+			if err := b.trySave(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	delete(b.finished, p)
+	if b.reserved[p] {
+		delete(b.reserved, p)
+		return b.release(path.Dir(p))
+	}
+	return nil
+}
+
+// Finish marks the scan as done with the directory at the given absolute path - no more entries are added to it,
+// other than the reserved ones. Its tree is saved once the reserved entries were added. Directories that were
+// neither added nor reserved (or whose tree was saved) are ignored.
+func (b *TreeBuilder) Finish(dir string) error {
+	dir = path.Clean("/" + dir)
+	if b.reserved[dir] {
+		b.finished[dir] = true
+		return nil
+	}
+
+	d, ok := b.dirs[dir]
+	if !ok {
+		return nil
+	}
+
+	d.finished = true
+	return b.trySave(dir)
+}
+
+// dir returns the pending directory at the given path, adding it (and its missing parents) implicitly if missing
+func (b *TreeBuilder) dir(dir string) (*pendingDir, error) {
+	if d, ok := b.dirs[dir]; ok {
+		return d, nil
+	}
+
+	parent, err := b.dir(path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := parent.names[path.Base(dir)]; ok {
+		if existing.Type != NodeTypeDir {
+			return nil, errors.Errorf("Can't add entries to '%s' - it is not a directory.", dir)
+		}
+		return nil, errors.Errorf("Can't add entries to '%s' - its tree was already saved.", dir)
+	}
+
+	node := &Node{Name: path.Base(dir), Type: NodeTypeDir}
+	parent.tree.Insert(node)
+	parent.names[node.Name] = node
+	parent.pending++
+
+	d := &pendingDir{node: node, names: make(map[string]*Node)}
+	b.dirs[dir] = d
+	return d, nil
+}
+
+// release counts out a pending entry of the directory, saving its tree if it was the last one
+func (b *TreeBuilder) release(dir string) error {
+	b.dirs[dir].pending--
+	return b.trySave(dir)
+}
+
+// trySave saves the tree of the directory if the scan is done with it and it has no pending entries,
+// and so on up the hierarchy. The root tree is only saved by Save.
+func (b *TreeBuilder) trySave(dir string) error {
+	d := b.dirs[dir]
+	if dir == "/" || !d.finished || d.pending > 0 {
+		return nil
+	}
+
+	if err := b.saveDir(dir, d); err != nil {
+		return err
+	}
+	return b.release(path.Dir(dir))
+}
+
+// saveDir stores the tree of the directory and drops it from memory
+func (b *TreeBuilder) saveDir(dir string, d *pendingDir) error {
+	id, err := b.repo.SaveTree(&d.tree)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to save the tree of '%s'.", dir)
+	}
+	d.node.Subtree = &id
+	delete(b.dirs, dir)
+	return nil
+}
+
+// Save stores the trees of all the pending directories, deepest first, and returns the ID of the root tree
+func (b *TreeBuilder) Save() (ID, error) {
+	dirs := make([]string, 0, len(b.dirs))
+	for dir := range b.dirs {
+		if dir != "/" {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+
+	for _, dir := range dirs {
+		if err := b.saveDir(dir, b.dirs[dir]); err != nil {
+			return ID{}, err
+		}
+	}
+
+	return b.repo.SaveTree(&b.dirs["/"].tree)
+}
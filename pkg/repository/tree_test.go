@@ -0,0 +1,138 @@
+// +build unit
+
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+)
+
+func TestTreeHierarchy(tst *testing.T) {
+	repo, _, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	build := func(logLine string) ID {
+		tree := NewTreeBuilder(repo)
+		require.NoError(tst, tree.Add("/data/sub/b.csv", &Node{Type: NodeTypeFile, Size: 1, ModTime: modTime}))
+		require.NoError(tst, tree.Add("/data/a.csv", &Node{
+			Type:               NodeTypeFile,
+			Size:               2,
+			ModTime:            modTime,
+			ExtendedAttributes: map[string]interface{}{"__size": "2"},
+		}))
+		require.NoError(tst, tree.Add("/data", &Node{Type: NodeTypeDir, ModTime: modTime}))
+		require.NoError(tst, tree.Add("/logs/app.log", &Node{Type: NodeTypeFile, Size: uint64(len(logLine)), ModTime: modTime}))
+		assert.Error(tst, tree.Add("/data/a.csv/x", &Node{Type: NodeTypeFile}))
+
+		id, err := tree.Save()
+		require.NoError(tst, err)
+		return id
+	}
+
+	first := build("line")
+	second := build("longer line")
+	require.NoError(tst, repo.Flush())
+
+	var paths []string
+	require.NoError(tst, repo.WalkTree(first, func(p string, node *Node) error {
+		paths = append(paths, p)
+		return nil
+	}))
+	assert.Equal(tst, []string{"/data", "/data/a.csv", "/data/sub", "/data/sub/b.csv", "/logs", "/logs/app.log"}, paths)
+
+	root, err := repo.LoadTree(first)
+	require.NoError(tst, err)
+	data := root.Find("data")
+	require.NotNil(tst, data)
+	assert.Equal(tst, modTime, data.ModTime)
+
+	dataTree, err := repo.LoadTree(*data.Subtree)
+	require.NoError(tst, err)
+	assert.Equal(tst, "2", dataTree.Find("a.csv").ExtendedAttributes["__size"])
+
+	// Only the changed directory (and its ancestors) differ between the snapshots
+	otherRoot, err := repo.LoadTree(second)
+	require.NoError(tst, err)
+	assert.NotEqual(tst, first, second)
+	assert.Equal(tst, *data.Subtree, *otherRoot.Find("data").Subtree)
+	assert.NotEqual(tst, *root.Find("logs").Subtree, *otherRoot.Find("logs").Subtree)
+
+	// Skipping a directory skips its entries
+	paths = nil
+	require.NoError(tst, repo.WalkTree(first, func(p string, node *Node) error {
+		paths = append(paths, p)
+		if p == "/data" {
+			return ErrSkipDir
+		}
+		return nil
+	}))
+	assert.Equal(tst, []string{"/data", "/logs", "/logs/app.log"}, paths)
+
+	// Entries are found by path
+	node, err := repo.FindNode(first, "/data/sub/b.csv")
+	require.NoError(tst, err)
+	require.NotNil(tst, node)
+	assert.Equal(tst, uint64(1), node.Size)
+	node, err = repo.FindNode(first, "/data/a.csv/x")
+	require.NoError(tst, err)
+	assert.Nil(tst, node)
+	node, err = repo.FindNode(first, "/missing")
+	require.NoError(tst, err)
+	assert.Nil(tst, node)
+}
+
+func TestTreeBuilderSavesFinishedDirectories(tst *testing.T) {
+	repo, _, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	build := func(finish bool) ID {
+		tree := NewTreeBuilder(repo)
+		require.NoError(tst, tree.Add("/data", &Node{Type: NodeTypeDir, ModTime: modTime}))
+		require.NoError(tst, tree.Reserve("/data/a.csv"))
+		require.NoError(tst, tree.Reserve("/data/table"))
+		require.NoError(tst, tree.Add("/data/sub", &Node{Type: NodeTypeDir, ModTime: modTime}))
+		require.NoError(tst, tree.Add("/data/sub/b.csv", &Node{Type: NodeTypeFile, Size: 1}))
+		require.NoError(tst, tree.Reserve("/data/skipped.csv"))
+		require.NoError(tst, tree.Release("/data/skipped.csv"))
+		if finish {
+			require.NoError(tst, tree.Finish("/data/sub"))
+			require.NoError(tst, tree.Finish("/data"))
+			require.NoError(tst, tree.Finish("/data/table"))
+
+			// The finished directory without pending entries is saved, the other one waits for its entries
+			assert.Error(tst, tree.Add("/data/sub/late.csv", &Node{Type: NodeTypeFile}))
+			require.NoError(tst, tree.Add("/data/a.csv", &Node{Type: NodeTypeFile, Size: 2}))
+		} else {
+			require.NoError(tst, tree.Add("/data/a.csv", &Node{Type: NodeTypeFile, Size: 2}))
+		}
+		require.NoError(tst, tree.Add("/data/table", &Node{Type: NodeTypeDir, ItemCount: 3}))
+		if finish {
+			assert.NotContains(tst, tree.dirs, "/data")
+			assert.NotContains(tst, tree.dirs, "/data/table")
+		}
+		require.NoError(tst, tree.Add("/logs/app.log", &Node{Type: NodeTypeFile, Size: 3}))
+
+		id, err := tree.Save()
+		require.NoError(tst, err)
+		assert.Empty(tst, tree.reserved)
+		return id
+	}
+
+	// The trees saved early are the same as the trees saved at the end
+	finished := build(true)
+	assert.Equal(tst, build(false), finished)
+
+	require.NoError(tst, repo.Flush())
+	var paths []string
+	require.NoError(tst, repo.WalkTree(finished, func(p string, node *Node) error {
+		paths = append(paths, p)
+		return nil
+	}))
+	assert.Equal(tst, []string{"/data", "/data/a.csv", "/data/sub", "/data/sub/b.csv", "/data/table", "/logs", "/logs/app.log"}, paths)
+}
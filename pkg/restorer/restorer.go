@@ -0,0 +1,390 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package restorer
+
+import (
+	"io"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/backend"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/table"
+)
+
+// Target is where the snapshot entries are restored to
+type Target interface {
+	MkDir(path string) error
+	Create(path string) (io.WriteCloser, error)
+}
+
+// TableTarget is a target that can restore the items of NoSQL tables (e.g. V3IO).
+// The items are dropped when restoring to other targets.
+type TableTarget interface {
+	PutItems(path string, items []map[string]interface{}) error
+}
+
+// StreamTarget is a target that can restore streams (e.g. V3IO). The streams are created with the
+// original shard count and retention, and the records are appended to their original shards in order.
+// The streams are dropped when restoring to other targets.
+type StreamTarget interface {
+	CreateStream(path string, shardCount, retentionPeriodHours int) error
+	PutRecords(path string, shard int, records []backend.StreamRecord) error
+}
+
+// AttributeTarget is a target that can restore the extended attributes of the entries (e.g. V3IO items).
+// The attributes are dropped when restoring to other targets.
+type AttributeTarget interface {
+	SetAttributes(path string, attributes map[string]interface{}) error
+}
+
+// Options of a single restore run
+type Options struct {
+	TargetPath  string   // restored entries are placed under this path
+	Includes    []string // restore only the entries matching any of these patterns (all entries when empty)
+	Excludes    []string // skip the entries matching any of these patterns
+	Parallelism int      // number of entries restored concurrently
+	Incremental bool     // allow restoring an incremental snapshot on top of a previously restored snapshot
+}
+
+// Stats counts the entries restored
+type Stats struct {
+	Files       int64
+	Directories int64
+	Items       int64
+	Records     int64
+	Bytes       int64
+}
+
+// restoreEntry is a file to restore, along with its path within the snapshot.
+// Entries of NoSQL tables restore a single blob of the table items, and entries of streams a single shard.
+type restoreEntry struct {
+	path  string
+	node  *repository.Node
+	blob  *repository.ID
+	shard int
+}
+
+// Restorer recreates the entries of a snapshot in a target
+type Restorer struct {
+	repo   *repository.Repository
+	target Target
+	opts   Options
+	logger logger.Logger
+	stats  Stats
+}
+
+func New(repo *repository.Repository, target Target, opts Options, logger logger.Logger) (*Restorer, error) {
+	for _, pattern := range append(append([]string{}, opts.Includes...), opts.Excludes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "Invalid pattern '%s'.", pattern)
+		}
+	}
+
+	if opts.TargetPath == "" {
+		opts.TargetPath = "/"
+	}
+	if opts.Parallelism < 1 {
+		opts.Parallelism = 1
+	}
+
+	return &Restorer{
+		repo:   repo,
+		target: target,
+		opts:   opts,
+		logger: logger,
+	}, nil
+}
+
+// Stats returns the counters of the last run
+func (r *Restorer) Stats() Stats {
+	return Stats{
+		Files:       atomic.LoadInt64(&r.stats.Files),
+		Directories: atomic.LoadInt64(&r.stats.Directories),
+		Items:       atomic.LoadInt64(&r.stats.Items),
+		Records:     atomic.LoadInt64(&r.stats.Records),
+		Bytes:       atomic.LoadInt64(&r.stats.Bytes),
+	}
+}
+
+// Restore recreates the selected entries of the snapshot. The snapshot tree is walked and the directories
+// are created first, then the metadata files (e.g. the table schemas) and the other files are restored by
+// Parallelism concurrent workers. The items of the NoSQL tables and the records of the streams are restored
+// last, once the schemas are in place. Tables are not restored without their schema. Incremental snapshots
+// are only restored when Incremental is set, as they hold just the entries modified after their base time.
+func (r *Restorer) Restore(sn *repository.Snapshot) error {
+	if sn.Tree == nil {
+		return errors.Errorf("Snapshot %s has no tree.", sn.ID().Str())
+	}
+	if sn.Incremental() && !r.opts.Incremental {
+		return errors.Errorf("Snapshot %s is incremental, it only holds the entries modified after %s. "+
+			"Restore a complete snapshot first and then apply it with the incremental option.",
+			sn.ID().Str(), sn.ModifiedAfter.Format(time.RFC3339))
+	}
+
+	var metadata, files, tableItems, streamShards []restoreEntry
+	schemas := make(map[string]bool)
+	err := r.repo.WalkTree(*sn.Tree, func(p string, node *repository.Node) error {
+		if matchesAny(r.opts.Excludes, p) {
+			return repository.ErrSkipDir
+		}
+		// The entries of a directory may be included even if the directory itself isn't
+		if len(r.opts.Includes) > 0 && !matchesAny(r.opts.Includes, p) {
+			return nil
+		}
+
+		switch node.Type {
+		case repository.NodeTypeDir:
+			if err := r.target.MkDir(r.targetPath(p)); err != nil {
+				return errors.Wrapf(err, "Failed to create directory '%s'.", r.targetPath(p))
+			}
+			atomic.AddInt64(&r.stats.Directories, 1)
+
+			if len(node.Items) > 0 {
+				if _, ok := r.target.(TableTarget); !ok {
+					r.logger.WarnWith("Target doesn't support tables, items dropped", "path", p, "items", node.ItemCount)
+					break
+				}
+				for i := range node.Items {
+					tableItems = append(tableItems, restoreEntry{path: p, node: node, blob: &node.Items[i]})
+				}
+			}
+		case repository.NodeTypeFile:
+			files = append(files, restoreEntry{path: p, node: node})
+		case repository.NodeTypeMetadata:
+			metadata = append(metadata, restoreEntry{path: p, node: node})
+			if node.Schema != nil {
+				schemas[p] = true
+			}
+		case repository.NodeTypeStream:
+			streamTarget, ok := r.target.(StreamTarget)
+			if !ok || node.Stream == nil {
+				r.logger.WarnWith("Target doesn't support streams, stream dropped", "path", p)
+				break
+			}
+			if err := streamTarget.CreateStream(r.targetPath(p), node.Stream.ShardCount, node.Stream.RetentionPeriodHours); err != nil {
+				return errors.Wrapf(err, "Failed to create stream '%s'.", r.targetPath(p))
+			}
+			for shard := range node.Stream.Shards {
+				streamShards = append(streamShards, restoreEntry{path: p, node: node, shard: shard})
+			}
+		default:
+			r.logger.WarnWith("Skipping entry of unknown type", "path", p, "type", node.Type)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := checkSchemas(tableItems, schemas); err != nil {
+		return err
+	}
+
+	if err := r.restoreEntries(metadata); err != nil {
+		return err
+	}
+	if err := r.restoreEntries(files); err != nil {
+		return err
+	}
+	return r.restoreEntries(append(tableItems, streamShards...))
+}
+
+// checkSchemas verifies that the schema of every restored table is restored as well (the schema of a TSDB
+// partition is the schema of the TSDB table holding it)
+func checkSchemas(tableItems []restoreEntry, schemas map[string]bool) error {
+	for _, entry := range tableItems {
+		tablePath := entry.path
+		if entry.node.Partition != nil {
+			tablePath = path.Dir(tablePath)
+		}
+
+		if !schemas[path.Join(tablePath, repository.SchemaFileName)] {
+			return errors.Errorf("Can't restore table '%s' - its schema is missing from the snapshot or excluded.", tablePath)
+		}
+	}
+	return nil
+}
+
+// restoreEntries restores the entries by Parallelism concurrent workers, stopping on the first error
+func (r *Restorer) restoreEntries(entries []restoreEntry) error {
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+		jobs     = make(chan restoreEntry)
+	)
+
+	wg.Add(r.opts.Parallelism)
+	for i := 0; i < r.opts.Parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				var err error
+				if entry.node.Type == repository.NodeTypeStream {
+					err = r.restoreShard(entry.path, entry.node.Stream, entry.shard)
+				} else if entry.blob != nil {
+					err = r.restoreTableItems(entry.path, *entry.blob)
+				} else {
+					err = r.restoreFile(entry.path, entry.node)
+				}
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		lock.Lock()
+		failed := firstErr != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+		jobs <- entry
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+func (r *Restorer) restoreFile(p string, node *repository.Node) error {
+	targetPath := r.targetPath(p)
+	writer, err := r.target.Create(targetPath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create '%s'.", targetPath)
+	}
+
+	for _, id := range node.Content {
+		data, err := r.repo.LoadBlob(repository.DataBlob, id)
+		if err != nil {
+			writer.Close()
+			return errors.Wrapf(err, "Failed to restore '%s'.", p)
+		}
+		if _, err := writer.Write(data); err != nil {
+			writer.Close()
+			return errors.Wrapf(err, "Failed to write '%s'.", targetPath)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write '%s'.", targetPath)
+	}
+
+	if len(node.ExtendedAttributes) > 0 {
+		if attributeTarget, ok := r.target.(AttributeTarget); ok {
+			if err := attributeTarget.SetAttributes(targetPath, node.ExtendedAttributes); err != nil {
+				return errors.Wrapf(err, "Failed to restore the attributes of '%s'.", p)
+			}
+		} else {
+			r.logger.DebugWith("Target doesn't support attributes, dropped", "path", p, "attributes", len(node.ExtendedAttributes))
+		}
+	}
+
+	atomic.AddInt64(&r.stats.Files, 1)
+	atomic.AddInt64(&r.stats.Bytes, int64(node.Size))
+	r.logger.DebugWith("Restored", "path", p, "target", targetPath, "size", node.Size)
+	return nil
+}
+
+// restoreTableItems writes the items of a single table blob
+func (r *Restorer) restoreTableItems(p string, id repository.ID) error {
+	data, err := r.repo.LoadBlob(repository.DataBlob, id)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to restore the items of table '%s'.", p)
+	}
+	items, err := table.Decode(data)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to decode the items of table '%s'.", p)
+	}
+
+	targetPath := r.targetPath(p)
+	if err := r.target.(TableTarget).PutItems(targetPath, items); err != nil {
+		return errors.Wrapf(err, "Failed to write the items of table '%s'.", targetPath)
+	}
+
+	atomic.AddInt64(&r.stats.Items, int64(len(items)))
+	r.logger.DebugWith("Restored table items", "path", p, "target", targetPath, "items", len(items))
+	return nil
+}
+
+// restoreShard appends the records of a single stream shard, in sequence order
+func (r *Restorer) restoreShard(p string, stream *repository.Stream, shard int) error {
+	targetPath := r.targetPath(p)
+	for _, id := range stream.Shards[shard].Records {
+		data, err := r.repo.LoadBlob(repository.DataBlob, id)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to restore the records of stream '%s'.", p)
+		}
+		items, err := table.Decode(data)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to decode the records of stream '%s'.", p)
+		}
+
+		records := make([]backend.StreamRecord, 0, len(items))
+		for _, item := range items {
+			record, err := backend.StreamRecordFromItem(item)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to decode the records of stream '%s'.", p)
+			}
+			records = append(records, record)
+		}
+
+		if err := r.target.(StreamTarget).PutRecords(targetPath, shard, records); err != nil {
+			return errors.Wrapf(err, "Failed to write the records of stream '%s'.", targetPath)
+		}
+		atomic.AddInt64(&r.stats.Records, int64(len(records)))
+	}
+
+	r.logger.DebugWith("Restored stream shard", "path", p, "target", targetPath, "shard", shard,
+		"records", stream.Shards[shard].RecordCount)
+	return nil
+}
+
+func (r *Restorer) targetPath(p string) string {
+	return path.Join(r.opts.TargetPath, p)
+}
+
+// matchesAny returns true if any of the patterns matches the path or its base name.
+// A pattern matching a directory matches all the entries within it.
+func matchesAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		for current := p; current != "/" && current != "." && current != ""; current = path.Dir(current) {
+			if matched, _ := path.Match(pattern, current); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, path.Base(current)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
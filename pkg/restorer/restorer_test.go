@@ -0,0 +1,212 @@
+// +build unit
+
+package restorer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/repository"
+	"v3io-backup/pkg/storage/local"
+	"v3io-backup/pkg/utils"
+)
+
+type memoryTarget struct {
+	lock       sync.Mutex
+	dirs       []string
+	files      map[string][]byte
+	attributes map[string]map[string]interface{}
+}
+
+type memoryFile struct {
+	bytes.Buffer
+	target *memoryTarget
+	path   string
+}
+
+func (mt *memoryTarget) MkDir(path string) error {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+	mt.dirs = append(mt.dirs, path)
+	return nil
+}
+
+func (mt *memoryTarget) Create(path string) (io.WriteCloser, error) {
+	return &memoryFile{target: mt, path: path}, nil
+}
+
+func (mt *memoryTarget) SetAttributes(path string, attributes map[string]interface{}) error {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+	mt.attributes[path] = attributes
+	return nil
+}
+
+func (mt *memoryTarget) PutItems(path string, items []map[string]interface{}) error {
+	return nil
+}
+
+func (mf *memoryFile) Close() error {
+	mf.target.lock.Lock()
+	defer mf.target.lock.Unlock()
+	mf.target.files[mf.path] = mf.Bytes()
+	return nil
+}
+
+// newTestRepository initializes a repository with the given configuration (and the defaults) within a new
+// temporary directory, which is removed by the returned cleanup function
+func newTestRepository(tst *testing.T, cfg *config.Config) (*repository.Repository, func()) {
+	dir, err := ioutil.TempDir("", "v3io-backup-repo")
+	require.NoError(tst, err)
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	backend, err := local.New(dir)
+	require.NoError(tst, err)
+	repo, err := repository.Init(backend, config.WithDefaults(cfg), "secret")
+	require.NoError(tst, err)
+
+	return repo, cleanup
+}
+
+func TestRestore(tst *testing.T) {
+	repo, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	contents := map[string][][]byte{
+		"/data/a.csv":     {[]byte("a,b,c\n"), []byte("1,2,3\n")},
+		"/data/b.json":    {[]byte(`{"b": 1}`)},
+		"/data/empty":     nil,
+		"/logs/app.log":   {[]byte("log line")},
+		"/data/sub/c.csv": {[]byte("x,y\n")},
+	}
+
+	tree := repository.NewTreeBuilder(repo)
+	for path, parts := range contents {
+		node := &repository.Node{Type: repository.NodeTypeFile, ModTime: time.Now()}
+		for _, part := range parts {
+			id, err := repo.SaveBlob(repository.DataBlob, part)
+			require.NoError(tst, err)
+			node.Content = append(node.Content, id)
+			node.Size += uint64(len(part))
+		}
+		require.NoError(tst, tree.Add(path, node))
+	}
+	require.NoError(tst, tree.Add("/data/item", &repository.Node{
+		Type:               repository.NodeTypeFile,
+		ModTime:            time.Now(),
+		ExtendedAttributes: repository.Attributes{"count": int64(7), "ratio": 0.5, "name": "x", "flag": true},
+	}))
+	require.NoError(tst, tree.Add("/data", &repository.Node{Type: repository.NodeTypeDir, ModTime: time.Now()}))
+	treeID, err := tree.Save()
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	sn := &repository.Snapshot{Time: time.Now(), Tree: &treeID}
+	_, err = repo.SaveSnapshot(sn)
+	require.NoError(tst, err)
+
+	logger, err := utils.NewLogger("error")
+	require.NoError(tst, err)
+
+	target := &memoryTarget{files: make(map[string][]byte), attributes: make(map[string]map[string]interface{})}
+	res, err := New(repo, target, Options{
+		TargetPath:  "/restored",
+		Includes:    []string{"/data"},
+		Excludes:    []string{"*.json"},
+		Parallelism: 3,
+	}, logger)
+	require.NoError(tst, err)
+	require.NoError(tst, res.Restore(sn))
+
+	assert.Equal(tst, []byte("a,b,c\n1,2,3\n"), target.files["/restored/data/a.csv"])
+	assert.Equal(tst, []byte("x,y\n"), target.files["/restored/data/sub/c.csv"])
+	assert.Contains(tst, target.files, "/restored/data/empty")
+	assert.NotContains(tst, target.files, "/restored/data/b.json")
+	assert.NotContains(tst, target.files, "/restored/logs/app.log")
+	assert.Equal(tst, []string{"/restored/data", "/restored/data/sub"}, target.dirs)
+	assert.Equal(tst, map[string]interface{}{"count": int64(7), "ratio": 0.5, "name": "x", "flag": true},
+		target.attributes["/restored/data/item"])
+	assert.Equal(tst, int64(4), res.Stats().Files)
+}
+
+func TestRestoreRequiresSchema(tst *testing.T) {
+	repo, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	itemsID, err := repo.SaveBlob(repository.DataBlob, []byte("items"))
+	require.NoError(tst, err)
+	schemaID, err := repo.SaveBlob(repository.DataBlob, []byte(`{"key": "id"}`))
+	require.NoError(tst, err)
+
+	tree := repository.NewTreeBuilder(repo)
+	require.NoError(tst, tree.Add("/table", &repository.Node{Type: repository.NodeTypeDir, Items: []repository.ID{itemsID}}))
+	require.NoError(tst, tree.Add("/table/.#schema", &repository.Node{
+		Type:    repository.NodeTypeMetadata,
+		Content: []repository.ID{schemaID},
+		Schema:  &repository.Schema{Key: "id"},
+	}))
+	treeID, err := tree.Save()
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	logger, err := utils.NewLogger("error")
+	require.NoError(tst, err)
+
+	// The items can't be restored if the schema is excluded
+	target := &memoryTarget{files: make(map[string][]byte)}
+	res, err := New(repo, target, Options{Excludes: []string{"/table/.#*"}, Parallelism: 1}, logger)
+	require.NoError(tst, err)
+	err = res.Restore(&repository.Snapshot{Tree: &treeID})
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "schema")
+	assert.Empty(tst, target.files)
+}
+
+func TestRestoreIncremental(tst *testing.T) {
+	repo, cleanup := newTestRepository(tst, &config.Config{})
+	defer cleanup()
+
+	id, err := repo.SaveBlob(repository.DataBlob, []byte("modified"))
+	require.NoError(tst, err)
+	tree := repository.NewTreeBuilder(repo)
+	require.NoError(tst, tree.Add("/data/a.csv", &repository.Node{
+		Type:    repository.NodeTypeFile,
+		Content: []repository.ID{id},
+		Size:    uint64(len("modified")),
+	}))
+	treeID, err := tree.Save()
+	require.NoError(tst, err)
+	require.NoError(tst, repo.Flush())
+
+	modifiedAfter := time.Now().Add(-time.Hour)
+	sn := &repository.Snapshot{Time: time.Now(), Tree: &treeID, ModifiedAfter: &modifiedAfter}
+	_, err = repo.SaveSnapshot(sn)
+	require.NoError(tst, err)
+
+	logger, err := utils.NewLogger("error")
+	require.NoError(tst, err)
+
+	// An incremental snapshot only holds the modified entries, it's restored on explicit request
+	target := &memoryTarget{files: make(map[string][]byte)}
+	res, err := New(repo, target, Options{Parallelism: 1}, logger)
+	require.NoError(tst, err)
+	err = res.Restore(sn)
+	require.Error(tst, err)
+	assert.Contains(tst, err.Error(), "incremental")
+	assert.Empty(tst, target.files)
+
+	res, err = New(repo, target, Options{Parallelism: 1, Incremental: true}, logger)
+	require.NoError(tst, err)
+	require.NoError(tst, res.Restore(sn))
+	assert.Equal(tst, []byte("modified"), target.files["/data/a.csv"])
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package local
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/storage"
+)
+
+// Backend stores the repository files within a local directory
+type Backend struct {
+	root string
+}
+
+// New returns a backend of the repository at the given directory
+func New(dir string) (*Backend, error) {
+	if dir == "" {
+		return nil, errors.New("Repository directory must be set.")
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve directory '%s'.", dir)
+	}
+	return &Backend{root: root}, nil
+}
+
+func (b *Backend) Location() string {
+	return b.root
+}
+
+func (b *Backend) Create() error {
+	for _, dir := range storage.Dirs() {
+		if err := os.MkdirAll(filepath.Join(b.root, filepath.FromSlash(dir)), 0700); err != nil {
+			return errors.Wrapf(err, "Failed to create the '%s' directory of the repository.", dir)
+		}
+	}
+	return nil
+}
+
+// Save writes the file atomically - the data is written and synced to a temporary file,
+// which is then renamed over the target name
+func (b *Backend) Save(h storage.Handle, data []byte) error {
+	filename := b.filename(h)
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "Failed to create the directory of %s.", h)
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+"-tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create a temporary file for %s.", h)
+	}
+
+	if err := writeAndSync(tmp, data); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "Failed to write %s.", h)
+	}
+
+	// Stored files are never modified
+	if err := os.Chmod(tmp.Name(), 0400); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "Failed to set the permissions of %s.", h)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "Failed to rename the temporary file of %s.", h)
+	}
+
+	// Make the rename durable
+	return syncDir(dir)
+}
+
+func (b *Backend) Load(h storage.Handle, length int, offset int64) ([]byte, error) {
+	f, err := os.Open(b.filename(h))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open %s.", h)
+	}
+	defer f.Close()
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "Failed to seek %s to offset %d.", h, offset)
+		}
+	}
+
+	if length == 0 {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s.", h)
+		}
+		return data, nil
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %d bytes at offset %d of %s.", length, offset, h)
+	}
+	return data, nil
+}
+
+func (b *Backend) Stat(h storage.Handle) (storage.FileInfo, error) {
+	info, err := os.Stat(b.filename(h))
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Failed to stat %s.", h)
+	}
+	return storage.FileInfo{Name: h.Name, Size: info.Size()}, nil
+}
+
+func (b *Backend) List(t storage.FileType) ([]storage.FileInfo, error) {
+	if t == storage.ConfigFile {
+		info, err := b.Stat(storage.Handle{Type: t})
+		if err != nil {
+			if b.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []storage.FileInfo{info}, nil
+	}
+
+	dirs := []string{string(t)}
+	if t == storage.PackFile {
+		dirs = nil
+		for _, sub := range storage.PackSubDirs() {
+			dirs = append(dirs, filepath.Join(string(t), sub))
+		}
+	}
+
+	var infos []storage.FileInfo
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(filepath.Join(b.root, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "Failed to list '%s'.", dir)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || isTempFile(entry.Name()) {
+				continue
+			}
+			infos = append(infos, storage.FileInfo{Name: entry.Name(), Size: entry.Size()})
+		}
+	}
+	return infos, nil
+}
+
+func (b *Backend) Remove(h storage.Handle) error {
+	if err := os.Remove(b.filename(h)); err != nil {
+		return errors.Wrapf(err, "Failed to remove %s.", h)
+	}
+	return nil
+}
+
+func (b *Backend) IsNotExist(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
+
+func (b *Backend) Close() error {
+	return nil
+}
+
+func (b *Backend) filename(h storage.Handle) string {
+	return filepath.Join(b.root, filepath.FromSlash(storage.Filename(h)))
+}
+
+func writeAndSync(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open directory '%s'.", dir)
+	}
+	defer d.Close()
+
+	// Some file systems don't support syncing directories
+	if err := d.Sync(); err != nil {
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EINVAL {
+			return nil
+		}
+		return errors.Wrapf(err, "Failed to sync directory '%s'.", dir)
+	}
+	return nil
+}
+
+func isTempFile(name string) bool {
+	return len(name) > 0 && name[0] == '.'
+}
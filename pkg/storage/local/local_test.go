@@ -0,0 +1,67 @@
+// +build unit
+
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/storage"
+)
+
+func TestBackend(tst *testing.T) {
+	dir, err := ioutil.TempDir("", "v3io-backup-local")
+	require.NoError(tst, err)
+	defer os.RemoveAll(dir)
+
+	backend, err := New(dir)
+	require.NoError(tst, err)
+	require.NoError(tst, backend.Create())
+
+	for _, subDir := range storage.Dirs() {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(subDir)))
+		require.NoError(tst, err)
+		assert.True(tst, info.IsDir())
+	}
+
+	pack := storage.Handle{Type: storage.PackFile, Name: "abcdef"}
+	require.NoError(tst, backend.Save(pack, []byte("0123456789")))
+
+	_, err = os.Stat(filepath.Join(dir, "data", "ab", "abcdef"))
+	require.NoError(tst, err)
+
+	data, err := backend.Load(pack, 0, 0)
+	require.NoError(tst, err)
+	assert.Equal(tst, "0123456789", string(data))
+
+	data, err = backend.Load(pack, 3, 4)
+	require.NoError(tst, err)
+	assert.Equal(tst, "456", string(data))
+
+	_, err = backend.Load(pack, 3, 8)
+	assert.Error(tst, err)
+
+	info, err := backend.Stat(pack)
+	require.NoError(tst, err)
+	assert.Equal(tst, storage.FileInfo{Name: "abcdef", Size: 10}, info)
+
+	require.NoError(tst, backend.Save(storage.Handle{Type: storage.PackFile, Name: "012345"}, []byte("x")))
+	packs, err := backend.List(storage.PackFile)
+	require.NoError(tst, err)
+	assert.ElementsMatch(tst, []storage.FileInfo{{Name: "abcdef", Size: 10}, {Name: "012345", Size: 1}}, packs)
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "data", "ab"))
+	require.NoError(tst, err)
+	assert.Len(tst, entries, 1)
+
+	require.NoError(tst, backend.Remove(pack))
+	_, err = backend.Stat(pack)
+	assert.True(tst, backend.IsNotExist(err))
+	_, err = backend.Load(pack, 0, 0)
+	assert.True(tst, backend.IsNotExist(err))
+}
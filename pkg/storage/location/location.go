@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package location
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+	"v3io-backup/pkg/storage/local"
+	"v3io-backup/pkg/storage/rest"
+	"v3io-backup/pkg/storage/s3"
+	"v3io-backup/pkg/storage/sftp"
+	"v3io-backup/pkg/storage/v3io"
+	"v3io-backup/pkg/utils"
+)
+
+// Location is a parsed repository location of the form "<scheme>:<backend specific location>".
+// Plain paths are locations of the local backend.
+type Location struct {
+	Scheme string
+	Path   string
+}
+
+// Supported schemes
+const (
+	LocalScheme = "local"
+	S3Scheme    = "s3"
+	V3ioScheme  = "v3io"
+	SftpScheme  = "sftp"
+	RestScheme  = "rest"
+)
+
+var schemes = []string{LocalScheme, S3Scheme, V3ioScheme, SftpScheme, RestScheme}
+
+// Parse splits the repository location into the backend scheme and the backend specific part
+func Parse(s string) (Location, error) {
+	if s == "" {
+		return Location{}, errors.New("Repository location must be set.")
+	}
+
+	for _, scheme := range schemes {
+		if strings.HasPrefix(s, scheme+":") {
+			return Location{Scheme: scheme, Path: strings.TrimPrefix(s, scheme+":")}, nil
+		}
+	}
+
+	if scheme, ok := parseScheme(s); ok {
+		return Location{}, errors.Errorf("Unsupported repository scheme '%s' in '%s'.", scheme, s)
+	}
+
+	return Location{Scheme: LocalScheme, Path: s}, nil
+}
+
+// Open returns the backend of the repository at the given location
+func Open(s string, cfg *config.Config) (storage.Backend, error) {
+	loc, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch loc.Scheme {
+	case LocalScheme:
+		return local.New(loc.Path)
+	case S3Scheme:
+		s3Cfg, err := s3.ParseConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		s3Cfg.S3 = cfg.S3
+		return s3.New(s3Cfg)
+	case V3ioScheme:
+		v3ioCfg, err := v3io.ParseConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		logger, err := utils.NewLogger(cfg.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		return v3io.New(v3ioCfg, cfg, logger)
+	case SftpScheme:
+		sftpCfg, err := sftp.ParseConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		sftpCfg.Sftp = cfg.Sftp
+		return sftp.New(sftpCfg)
+	case RestScheme:
+		restCfg, err := rest.ParseConfig(s)
+		if err != nil {
+			return nil, err
+		}
+		return rest.New(restCfg), nil
+	default:
+		return nil, errors.Errorf("Unsupported repository scheme '%s'.", loc.Scheme)
+	}
+}
+
+// parseScheme returns the URL scheme of the location, if any.
+// Windows drive letters ("C:\backups") and relative paths containing a colon are not schemes.
+func parseScheme(s string) (string, bool) {
+	i := strings.Index(s, ":")
+	if i < 2 {
+		return "", false
+	}
+
+	scheme := s[:i]
+	for _, c := range scheme {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return "", false
+		}
+	}
+	return scheme, true
+}
@@ -0,0 +1,36 @@
+// +build unit
+
+package location
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(tst *testing.T) {
+	for _, test := range []struct {
+		location string
+		expected Location
+	}{
+		{"/backups/repo", Location{Scheme: LocalScheme, Path: "/backups/repo"}},
+		{"local:/backups/repo", Location{Scheme: LocalScheme, Path: "/backups/repo"}},
+		{"relative/repo", Location{Scheme: LocalScheme, Path: "relative/repo"}},
+		{`C:\backups\repo`, Location{Scheme: LocalScheme, Path: `C:\backups\repo`}},
+		{"s3:http://localhost:9000/bucket/repo", Location{Scheme: S3Scheme, Path: "http://localhost:9000/bucket/repo"}},
+		{"v3io://localhost:8081/backups/repo", Location{Scheme: V3ioScheme, Path: "//localhost:8081/backups/repo"}},
+		{"sftp:backup@bastion:/srv/repo", Location{Scheme: SftpScheme, Path: "backup@bastion:/srv/repo"}},
+		{"rest:http://localhost:8000/repo", Location{Scheme: RestScheme, Path: "http://localhost:8000/repo"}},
+	} {
+		loc, err := Parse(test.location)
+		require.NoError(tst, err, test.location)
+		assert.Equal(tst, test.expected, loc, test.location)
+	}
+
+	_, err := Parse("")
+	assert.Error(tst, err)
+
+	_, err = Parse("unknown:/backups/repo")
+	assert.Error(tst, err)
+}
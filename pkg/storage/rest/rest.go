@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/storage"
+)
+
+// errNotExist is the cause of the errors of missing files
+var errNotExist = errors.New("File does not exist.")
+
+// Config of a repository served over the REST protocol
+type Config struct {
+	// Server URL, possibly with the basic authentication credentials
+	URL *url.URL
+}
+
+// Backend accesses a repository served over the REST protocol (see Server)
+type Backend struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// ParseConfig parses a location of the form "rest:http[s]://[<user>:<password>@]<host>[:<port>][/<path>]".
+// The path must match the PathPrefix the repository is served under.
+func ParseConfig(location string) (Config, error) {
+	if !strings.HasPrefix(location, "rest:") {
+		return Config{}, errors.Errorf("Invalid REST location '%s'.", location)
+	}
+
+	u, err := url.Parse(strings.TrimPrefix(location, "rest:"))
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "Invalid REST location '%s'.", location)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return Config{}, errors.Errorf("Invalid REST location '%s' - expected 'rest:http[s]://<host>[:<port>][/<path>]'.", location)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return Config{URL: u}, nil
+}
+
+func New(cfg Config) *Backend {
+	return &Backend{url: cfg.URL, client: &http.Client{}}
+}
+
+// Location returns the URL of the repository without the password
+func (b *Backend) Location() string {
+	u := *b.url
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	return "rest:" + u.String()
+}
+
+func (b *Backend) Create() error {
+	response, err := b.do(http.MethodPost, "/?create=true", nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create the repository.")
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (b *Backend) Save(h storage.Handle, data []byte) error {
+	response, err := b.do(http.MethodPost, filePath(h), bytes.NewReader(data), nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to save %s.", h)
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (b *Backend) Load(h storage.Handle, length int, offset int64) ([]byte, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(length)-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	response, err := b.do(http.MethodGet, filePath(h), nil, header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load %s.", h)
+	}
+	defer response.Body.Close()
+
+	if header.Get("Range") != "" {
+		switch response.StatusCode {
+		case http.StatusPartialContent:
+			if err := checkContentRange(response.Header.Get("Content-Range"), length, offset); err != nil {
+				return nil, errors.Wrapf(err, "Failed to load %s.", h)
+			}
+		case http.StatusOK:
+			// The server ignored the range and sent the entire file, skip up to the requested part
+			if _, err := io.CopyN(ioutil.Discard, response.Body, offset); err != nil {
+				return nil, errors.Wrapf(err, "Failed to load %s - can't skip to offset %d.", h, offset)
+			}
+		default:
+			return nil, errors.Errorf("Failed to load %s - unexpected response '%s' to a range request.", h, response.Status)
+		}
+	}
+
+	if length == 0 {
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load %s.", h)
+		}
+		return data, nil
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(response.Body, data); err != nil {
+		return nil, errors.Wrapf(err, "Failed to load %d bytes at offset %d of %s.", length, offset, h)
+	}
+	return data, nil
+}
+
+// checkContentRange verifies that the Content-Range header of a partial response ("bytes <first>-<last>/<size>")
+// matches the requested range
+func checkContentRange(contentRange string, length int, offset int64) error {
+	var first, last int64
+	var size string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &size); err != nil {
+		return errors.Errorf("Invalid content range '%s'.", contentRange)
+	}
+	if first != offset || (length > 0 && last != offset+int64(length)-1) {
+		return errors.Errorf("Content range '%s' doesn't match the requested range.", contentRange)
+	}
+	return nil
+}
+
+func (b *Backend) Stat(h storage.Handle) (storage.FileInfo, error) {
+	response, err := b.do(http.MethodHead, filePath(h), nil, nil)
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Failed to stat %s.", h)
+	}
+	response.Body.Close()
+
+	size, err := strconv.ParseInt(response.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Invalid size of %s.", h)
+	}
+	return storage.FileInfo{Name: h.Name, Size: size}, nil
+}
+
+func (b *Backend) List(t storage.FileType) ([]storage.FileInfo, error) {
+	if t == storage.ConfigFile {
+		info, err := b.Stat(storage.Handle{Type: t})
+		if err != nil {
+			if b.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []storage.FileInfo{info}, nil
+	}
+
+	response, err := b.do(http.MethodGet, "/"+string(t)+"/", nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list '%s'.", t)
+	}
+	defer response.Body.Close()
+
+	var entries []listEntry
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the listing of '%s'.", t)
+	}
+
+	infos := make([]storage.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		infos = append(infos, storage.FileInfo{Name: entry.Name, Size: entry.Size})
+	}
+	return infos, nil
+}
+
+func (b *Backend) Remove(h storage.Handle) error {
+	response, err := b.do(http.MethodDelete, filePath(h), nil, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to remove %s.", h)
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (b *Backend) IsNotExist(err error) bool {
+	return errors.Cause(err) == errNotExist
+}
+
+func (b *Backend) Close() error {
+	return nil
+}
+
+// do sends the request and checks the response status. The body of successful responses must be closed by the caller.
+func (b *Backend) do(method string, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(method, b.url.Scheme+"://"+b.url.Host+b.url.Path+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if b.url.User != nil {
+		password, _ := b.url.User.Password()
+		request.SetBasicAuth(b.url.User.Username(), password)
+	}
+
+	response, err := b.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return response, nil
+	case http.StatusNotFound:
+		response.Body.Close()
+		return nil, errNotExist
+	default:
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, errors.Errorf("Server responded with '%s': %s", response.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+func filePath(h storage.Handle) string {
+	if h.Type == storage.ConfigFile {
+		return "/" + string(storage.ConfigFile)
+	}
+	return "/" + string(h.Type) + "/" + h.Name
+}
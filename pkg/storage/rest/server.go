@@ -0,0 +1,306 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package rest
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nuclio/logger"
+	"v3io-backup/pkg/storage"
+)
+
+// ServerOptions control the access to the served repository
+type ServerOptions struct {
+	// Reject the deletion and the overwriting of the repository files (except the locks)
+	AppendOnly bool
+	// Require HTTP basic authentication when set
+	Username string
+	Password string
+	// Path the repository is served under, e.g. "/repo" for the "rest:http://<host>/repo" clients (the root when empty)
+	PathPrefix string
+	// Reject the files larger than this, in bytes (no limit when zero)
+	MaxFileSize int64
+}
+
+// Server exposes a repository backend over the REST protocol:
+//
+//	POST   /?create=true      create the repository layout
+//	GET    /<type>/           list the files of the given type (JSON array of {"name", "size"})
+//	HEAD   /<type>/<name>     get the file size (Content-Length)
+//	GET    /<type>/<name>     read the file, a single byte range may be requested with the Range header
+//	POST   /<type>/<name>     store the file (the request body)
+//	DELETE /<type>/<name>     remove the file
+//
+// The repository config file is accessed as /config. All the paths are relative to the PathPrefix option.
+type Server struct {
+	backend storage.Backend
+	opts    ServerOptions
+	logger  logger.Logger
+}
+
+// listEntry is a single file of a listing
+type listEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func NewServer(backend storage.Backend, opts ServerOptions, logger logger.Logger) *Server {
+	opts.PathPrefix = strings.TrimRight(opts.PathPrefix, "/")
+	if opts.PathPrefix != "" && !strings.HasPrefix(opts.PathPrefix, "/") {
+		opts.PathPrefix = "/" + opts.PathPrefix
+	}
+	return &Server{backend: backend, opts: opts, logger: logger}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="v3io-backup"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	urlPath, ok := s.stripPrefix(r.URL.Path)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if urlPath == "/" && r.Method == http.MethodPost && r.URL.Query().Get("create") == "true" {
+		s.create(w)
+		return
+	}
+
+	h, isDir, ok := parsePath(urlPath)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case isDir && r.Method == http.MethodGet:
+		s.list(w, h.Type)
+	case isDir:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case r.Method == http.MethodHead:
+		s.stat(w, h)
+	case r.Method == http.MethodGet:
+		s.load(w, r, h)
+	case r.Method == http.MethodPost:
+		s.save(w, r, h)
+	case r.Method == http.MethodDelete:
+		s.remove(w, h)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// stripPrefix returns the request path relative to the PathPrefix, false if the path is outside of it
+func (s *Server) stripPrefix(urlPath string) (string, bool) {
+	if s.opts.PathPrefix == "" {
+		return urlPath, true
+	}
+	if urlPath == s.opts.PathPrefix {
+		return "/", true
+	}
+	if !strings.HasPrefix(urlPath, s.opts.PathPrefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(urlPath, s.opts.PathPrefix), true
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.opts.Username == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(s.opts.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.opts.Password)) == 1
+}
+
+func (s *Server) create(w http.ResponseWriter) {
+	if err := s.backend.Create(); err != nil {
+		s.fail(w, "create", err)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, t storage.FileType) {
+	infos, err := s.backend.List(t)
+	if err != nil {
+		s.fail(w, "list", err)
+		return
+	}
+
+	entries := make([]listEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, listEntry{Name: info.Name, Size: info.Size})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (s *Server) stat(w http.ResponseWriter, h storage.Handle) {
+	info, err := s.backend.Stat(h)
+	if err != nil {
+		s.fail(w, "stat", err)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+}
+
+func (s *Server) load(w http.ResponseWriter, r *http.Request, h storage.Handle) {
+	offset, length, err := parseRange(r.Header.Get("Range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	data, err := s.backend.Load(h, length, offset)
+	if err != nil {
+		s.fail(w, "load", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+int64(len(data))-1))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	w.Write(data)
+}
+
+func (s *Server) save(w http.ResponseWriter, r *http.Request, h storage.Handle) {
+	if s.opts.AppendOnly && h.Type != storage.LockFile {
+		if _, err := s.backend.Stat(h); err == nil {
+			http.Error(w, "Overwriting is forbidden in append-only mode", http.StatusForbidden)
+			return
+		}
+	}
+
+	body := r.Body
+	if s.opts.MaxFileSize > 0 {
+		if r.ContentLength > s.opts.MaxFileSize {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		// The content length isn't set for chunked requests
+		body = http.MaxBytesReader(w, r.Body, s.opts.MaxFileSize)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, "Failed to read the request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.backend.Save(h, data); err != nil {
+		s.fail(w, "save", err)
+	}
+}
+
+func (s *Server) remove(w http.ResponseWriter, h storage.Handle) {
+	if s.opts.AppendOnly && h.Type != storage.LockFile {
+		http.Error(w, "Deleting is forbidden in append-only mode", http.StatusForbidden)
+		return
+	}
+
+	if err := s.backend.Remove(h); err != nil {
+		s.fail(w, "remove", err)
+	}
+}
+
+func (s *Server) fail(w http.ResponseWriter, operation string, err error) {
+	if s.backend.IsNotExist(err) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	s.logger.WarnWith("Request failed", "operation", operation, "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// parsePath resolves the request path into a file handle. isDir is true for the listing of a file type.
+func parsePath(urlPath string) (h storage.Handle, isDir bool, ok bool) {
+	if urlPath == "/"+string(storage.ConfigFile) {
+		return storage.Handle{Type: storage.ConfigFile}, false, true
+	}
+
+	parts := strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	if len(parts) != 2 || !isDirFileType(storage.FileType(parts[0])) {
+		return storage.Handle{}, false, false
+	}
+
+	h.Type = storage.FileType(parts[0])
+	if parts[1] == "" {
+		return h, true, true
+	}
+
+	// Names are hex IDs, never paths
+	if parts[1] == "." || parts[1] == ".." || strings.ContainsAny(parts[1], `\`) {
+		return storage.Handle{}, false, false
+	}
+	h.Name = parts[1]
+	return h, false, true
+}
+
+func isDirFileType(t storage.FileType) bool {
+	for _, dirType := range storage.DirFileTypes {
+		if t == dirType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a single "bytes=<start>-[<end>]" range. An empty header selects the whole file.
+func parseRange(header string) (offset int64, length int, err error) {
+	if header == "" {
+		return 0, 0, nil
+	}
+
+	spec := strings.TrimPrefix(header, "bytes=")
+	bounds := strings.SplitN(spec, "-", 2)
+	if spec == header || len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("Invalid range '%s'.", header)
+	}
+
+	if offset, err = strconv.ParseInt(bounds[0], 10, 64); err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("Invalid range '%s'.", header)
+	}
+	if bounds[1] == "" {
+		return offset, 0, nil
+	}
+
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end < offset {
+		return 0, 0, fmt.Errorf("Invalid range '%s'.", header)
+	}
+	return offset, int(end - offset + 1), nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/pkg/errors"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+)
+
+// Minimal size of the parts of a multipart upload (S3 limit)
+const minPartSize = 5 * 1024 * 1024
+
+// Config locates the repository within an S3-compatible object store
+type Config struct {
+	Endpoint string // host[:port] of the object store
+	UseHTTP  bool   // use plain HTTP instead of HTTPS
+	Bucket   string
+	Prefix   string // path of the repository within the bucket
+	S3       config.S3Config
+}
+
+// ParseConfig parses a location of the form "s3:[http[s]://]<endpoint>/<bucket>[/<prefix>]",
+// e.g. "s3:s3.amazonaws.com/my-bucket/backups" or "s3:http://localhost:9000/my-bucket"
+func ParseConfig(location string) (Config, error) {
+	if !strings.HasPrefix(location, "s3:") {
+		return Config{}, errors.Errorf("Invalid S3 location '%s'.", location)
+	}
+
+	cfg := Config{}
+	rest := strings.TrimPrefix(location, "s3:")
+	switch {
+	case strings.HasPrefix(rest, "http://"):
+		cfg.UseHTTP = true
+		rest = strings.TrimPrefix(rest, "http://")
+	case strings.HasPrefix(rest, "https://"):
+		rest = strings.TrimPrefix(rest, "https://")
+	case strings.HasPrefix(rest, "//"):
+		rest = strings.TrimPrefix(rest, "//")
+	}
+
+	parts := strings.SplitN(strings.Trim(rest, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Config{}, errors.Errorf("Invalid S3 location '%s' - expected 's3:<endpoint>/<bucket>[/<prefix>]'.", location)
+	}
+
+	cfg.Endpoint = parts[0]
+	cfg.Bucket = parts[1]
+	if len(parts) == 3 {
+		cfg.Prefix = path.Clean(parts[2])
+	}
+	return cfg, nil
+}
+
+// Backend stores the repository files as objects of an S3 bucket
+type Backend struct {
+	client   minio.Core
+	cfg      Config
+	partSize int64
+}
+
+// New returns a backend of the repository located by the given configuration
+func New(cfg Config) (*Backend, error) {
+	creds := credentials.NewStaticV4(cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey, cfg.S3.SessionToken)
+
+	lookup := minio.BucketLookupAuto
+	if cfg.S3.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.NewWithOptions(cfg.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       !cfg.UseHTTP,
+		Region:       cfg.S3.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create an S3 client of '%s'.", cfg.Endpoint)
+	}
+
+	partSize := int64(cfg.S3.PartSize)
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+
+	return &Backend{client: minio.Core{Client: client}, cfg: cfg, partSize: partSize}, nil
+}
+
+func (b *Backend) Location() string {
+	scheme := "https"
+	if b.cfg.UseHTTP {
+		scheme = "http"
+	}
+	return "s3:" + scheme + "://" + path.Join(b.cfg.Endpoint, b.cfg.Bucket, b.cfg.Prefix)
+}
+
+// Create creates the bucket unless it exists. S3 has no directories, there is no other layout to prepare.
+func (b *Backend) Create() error {
+	exists, err := b.client.BucketExists(b.cfg.Bucket)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to check bucket '%s'.", b.cfg.Bucket)
+	}
+	if exists {
+		return nil
+	}
+
+	if err := b.client.MakeBucket(b.cfg.Bucket, b.cfg.S3.Region); err != nil {
+		return errors.Wrapf(err, "Failed to create bucket '%s'.", b.cfg.Bucket)
+	}
+	return nil
+}
+
+// Save uploads the file. Files larger than the configured part size are uploaded in parts.
+// An object only becomes visible once fully uploaded, so writes are atomic.
+func (b *Backend) Save(h storage.Handle, data []byte) error {
+	key := b.key(h)
+	if int64(len(data)) <= b.partSize {
+		_, err := b.client.PutObject(b.cfg.Bucket, key, bytes.NewReader(data), int64(len(data)), "", "", nil, nil)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to upload %s.", h)
+		}
+		return nil
+	}
+
+	if err := b.saveMultipart(key, data); err != nil {
+		return errors.Wrapf(err, "Failed to upload %s.", h)
+	}
+	return nil
+}
+
+func (b *Backend) saveMultipart(key string, data []byte) error {
+	uploadID, err := b.client.NewMultipartUpload(b.cfg.Bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	var parts []minio.CompletePart
+	for offset, partID := int64(0), 1; offset < int64(len(data)); offset, partID = offset+b.partSize, partID+1 {
+		end := offset + b.partSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+
+		part, err := b.client.PutObjectPart(b.cfg.Bucket, key, uploadID, partID, bytes.NewReader(data[offset:end]), end-offset, "", "", nil)
+		if err != nil {
+			b.client.AbortMultipartUpload(b.cfg.Bucket, key, uploadID)
+			return err
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	if _, err := b.client.CompleteMultipartUpload(b.cfg.Bucket, key, uploadID, parts); err != nil {
+		b.client.AbortMultipartUpload(b.cfg.Bucket, key, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) Load(h storage.Handle, length int, offset int64) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+	if length > 0 {
+		if err := opts.SetRange(offset, offset+int64(length)-1); err != nil {
+			return nil, err
+		}
+	} else if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	reader, _, err := b.client.GetObject(b.cfg.Bucket, b.key(h), opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to download %s.", h)
+	}
+	defer reader.Close()
+
+	if length == 0 {
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to download %s.", h)
+		}
+		return data, nil
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, errors.Wrapf(err, "Failed to download %d bytes at offset %d of %s.", length, offset, h)
+	}
+	return data, nil
+}
+
+func (b *Backend) Stat(h storage.Handle) (storage.FileInfo, error) {
+	info, err := b.client.StatObject(b.cfg.Bucket, b.key(h), minio.StatObjectOptions{})
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Failed to stat %s.", h)
+	}
+	return storage.FileInfo{Name: h.Name, Size: info.Size}, nil
+}
+
+func (b *Backend) List(t storage.FileType) ([]storage.FileInfo, error) {
+	prefix := b.key(storage.Handle{Type: t})
+	if t != storage.ConfigFile {
+		prefix += "/"
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var infos []storage.FileInfo
+	for object := range b.client.Client.ListObjectsV2(b.cfg.Bucket, prefix, true, done) {
+		if object.Err != nil {
+			return nil, errors.Wrapf(object.Err, "Failed to list '%s'.", prefix)
+		}
+		if t == storage.ConfigFile && object.Key != prefix {
+			continue
+		}
+		infos = append(infos, storage.FileInfo{Name: path.Base(object.Key), Size: object.Size})
+	}
+	return infos, nil
+}
+
+func (b *Backend) Remove(h storage.Handle) error {
+	if err := b.client.RemoveObject(b.cfg.Bucket, b.key(h)); err != nil {
+		return errors.Wrapf(err, "Failed to remove %s.", h)
+	}
+	return nil
+}
+
+func (b *Backend) IsNotExist(err error) bool {
+	response := minio.ToErrorResponse(errors.Cause(err))
+	return response.Code == "NoSuchKey" || response.StatusCode == http.StatusNotFound
+}
+
+func (b *Backend) Close() error {
+	return nil
+}
+
+// key returns the object key of the file
+func (b *Backend) key(h storage.Handle) string {
+	return path.Join(b.cfg.Prefix, storage.Filename(h))
+}
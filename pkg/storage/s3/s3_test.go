@@ -0,0 +1,241 @@
+// +build unit
+
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+)
+
+// fakeS3 is a minimal in-memory stand-in of an S3-compatible object store (path style addressing, no authentication)
+type fakeS3 struct {
+	lock       sync.Mutex
+	buckets    map[string]map[string][]byte
+	uploads    map[string]map[int][]byte
+	multiparts int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{buckets: make(map[string]map[string][]byte), uploads: make(map[string]map[int][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+	objects, bucketExists := f.buckets[bucket]
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		if !bucketExists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case key == "" && r.Method == http.MethodPut:
+		f.buckets[bucket] = make(map[string][]byte)
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, bucket, objects, query.Get("prefix"))
+	case !bucketExists:
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+	case r.Method == http.MethodPost && query.Get("uploads") != "" || r.Method == http.MethodPost && r.URL.RawQuery == "uploads=":
+		uploadID := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadID string `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: uploadID})
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		data, _ := ioutil.ReadAll(r.Body)
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][partNumber] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		uploaded := f.uploads[query.Get("uploadId")]
+		var numbers []int
+		for number := range uploaded {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, number := range numbers {
+			data = append(data, uploaded[number]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		objects[key] = data
+		f.multiparts++
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etag(data)})
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		objects[key] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+
+		status := http.StatusOK
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			var start, end int
+			if n, _ := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); n < 2 || end >= len(data) {
+				end = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data = data[start : end+1]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, objects map[string][]byte, prefix string) {
+	type content struct {
+		Key          string
+		Size         int
+		ETag         string
+		LastModified string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix}
+
+	for key, data := range objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				Size:         len(data),
+				ETag:         etag(data),
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, _ := xml.Marshal(v)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func TestParseConfig(tst *testing.T) {
+	cfg, err := ParseConfig("s3:http://localhost:9000/bucket/some/prefix/")
+	require.NoError(tst, err)
+	assert.Equal(tst, Config{Endpoint: "localhost:9000", UseHTTP: true, Bucket: "bucket", Prefix: "some/prefix"}, cfg)
+
+	cfg, err = ParseConfig("s3:s3.amazonaws.com/bucket")
+	require.NoError(tst, err)
+	assert.Equal(tst, Config{Endpoint: "s3.amazonaws.com", Bucket: "bucket"}, cfg)
+
+	_, err = ParseConfig("s3:s3.amazonaws.com")
+	assert.Error(tst, err)
+}
+
+func TestBackend(tst *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cfg, err := ParseConfig("s3:" + server.URL + "/repo-bucket/backups")
+	require.NoError(tst, err)
+	cfg.S3 = config.S3Config{Region: "us-east-1", PathStyle: true, PartSize: minPartSize}
+
+	backend, err := New(cfg)
+	require.NoError(tst, err)
+	require.NoError(tst, backend.Create())
+	require.Contains(tst, fake.buckets, "repo-bucket")
+
+	small := storage.Handle{Type: storage.IndexFile, Name: "0123"}
+	require.NoError(tst, backend.Save(small, []byte("0123456789")))
+	assert.Contains(tst, fake.buckets["repo-bucket"], "backups/index/0123")
+
+	data, err := backend.Load(small, 3, 4)
+	require.NoError(tst, err)
+	assert.Equal(tst, "456", string(data))
+
+	// Packs larger than the part size are uploaded in parts
+	large := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+100)/16)
+	pack := storage.Handle{Type: storage.PackFile, Name: "abcdef"}
+	require.NoError(tst, backend.Save(pack, large))
+	assert.Equal(tst, 1, fake.multiparts)
+	assert.Contains(tst, fake.buckets["repo-bucket"], "backups/data/ab/abcdef")
+
+	data, err = backend.Load(pack, 0, 0)
+	require.NoError(tst, err)
+	assert.True(tst, bytes.Equal(large, data))
+
+	info, err := backend.Stat(pack)
+	require.NoError(tst, err)
+	assert.Equal(tst, int64(len(large)), info.Size)
+
+	packs, err := backend.List(storage.PackFile)
+	require.NoError(tst, err)
+	assert.Equal(tst, []storage.FileInfo{{Name: "abcdef", Size: int64(len(large))}}, packs)
+
+	require.NoError(tst, backend.Remove(pack))
+	_, err = backend.Stat(pack)
+	assert.True(tst, backend.IsNotExist(err))
+	_, err = backend.Load(small, 0, 0)
+	assert.NoError(tst, err)
+	_, err = backend.Load(storage.Handle{Type: storage.ConfigFile}, 0, 0)
+	assert.True(tst, backend.IsNotExist(err))
+}
@@ -0,0 +1,436 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+)
+
+const (
+	defaultPort           = "22"
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// SFTP status codes of a broken connection
+const (
+	sshFxNoConnection   = 6
+	sshFxConnectionLost = 7
+)
+
+// Config locates the repository on an SFTP server
+type Config struct {
+	User string
+	Host string
+	Port string
+	Path string // absolute path of the repository on the server
+	Sftp config.SftpConfig
+}
+
+// ParseConfig parses a location of the form "sftp:[<user>@]<host>:<path>" or "sftp://[<user>@]<host>[:<port>]/<path>",
+// e.g. "sftp:backup@bastion:/srv/backups/repo". The user defaults to the current user.
+func ParseConfig(location string) (Config, error) {
+	var userHost, repoPath, port string
+	switch {
+	case strings.HasPrefix(location, "sftp://"):
+		rest := strings.TrimPrefix(location, "sftp://")
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return Config{}, errors.Errorf("Invalid SFTP location '%s' - the repository path is missing.", location)
+		}
+		userHost, repoPath = rest[:i], rest[i:]
+		if host, hostPort, err := net.SplitHostPort(userHost); err == nil {
+			userHost, port = host, hostPort
+		}
+	case strings.HasPrefix(location, "sftp:"):
+		rest := strings.TrimPrefix(location, "sftp:")
+		i := strings.Index(rest, ":")
+		if i < 0 {
+			return Config{}, errors.Errorf("Invalid SFTP location '%s' - expected 'sftp:[<user>@]<host>:<path>'.", location)
+		}
+		userHost, repoPath = rest[:i], rest[i+1:]
+	default:
+		return Config{}, errors.Errorf("Invalid SFTP location '%s'.", location)
+	}
+
+	cfg := Config{Host: userHost, Port: port, Path: path.Clean(repoPath)}
+	if i := strings.LastIndex(userHost, "@"); i >= 0 {
+		cfg.User, cfg.Host = userHost[:i], userHost[i+1:]
+	}
+	if cfg.Host == "" || repoPath == "" {
+		return Config{}, errors.Errorf("Invalid SFTP location '%s' - the host and the repository path must be set.", location)
+	}
+	if cfg.Port == "" {
+		cfg.Port = defaultPort
+	}
+	if cfg.User == "" {
+		if current, err := user.Current(); err == nil {
+			cfg.User = current.Username
+		}
+	}
+	return cfg, nil
+}
+
+// Backend stores the repository files on an SFTP server. A single SSH connection is shared by all the
+// operations, and it is re-established (once per operation) when it fails.
+type Backend struct {
+	cfg       Config
+	sshConfig *ssh.ClientConfig
+
+	lock   sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// New connects to the SFTP server, authenticating with the configured private key and
+// verifying the server against the known hosts
+func New(cfg Config) (*Backend, error) {
+	signer, err := loadIdentity(cfg.Sftp.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsFile := cfg.Sftp.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = homePath(".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to load the known hosts from '%s'.", knownHostsFile)
+	}
+
+	timeout := defaultConnectTimeout
+	if cfg.Sftp.ConnectTimeout != "" {
+		if timeout, err = time.ParseDuration(cfg.Sftp.ConnectTimeout); err != nil {
+			return nil, errors.Wrapf(err, "Invalid SFTP connect timeout '%s'.", cfg.Sftp.ConnectTimeout)
+		}
+	}
+
+	b := &Backend{
+		cfg: cfg,
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         timeout,
+		},
+	}
+
+	if _, err := b.getClient(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Backend) Location() string {
+	return fmt.Sprintf("sftp://%s@%s%s", b.cfg.User, net.JoinHostPort(b.cfg.Host, b.cfg.Port), b.cfg.Path)
+}
+
+func (b *Backend) Create() error {
+	return b.withClient(func(client *sftp.Client) error {
+		for _, dir := range storage.Dirs() {
+			if err := client.MkdirAll(path.Join(b.cfg.Path, dir)); err != nil {
+				return errors.Wrapf(err, "Failed to create the '%s' directory of the repository.", dir)
+			}
+		}
+		return nil
+	})
+}
+
+// Save writes the file to a temporary name and renames it once complete, so partially written files are never visible
+func (b *Backend) Save(h storage.Handle, data []byte) error {
+	filename := b.filename(h)
+	return b.withClient(func(client *sftp.Client) error {
+		tmpName := fmt.Sprintf("%s/.%s-tmp-%d", path.Dir(filename), path.Base(filename), time.Now().UnixNano())
+
+		f, err := client.Create(tmpName)
+		if os.IsNotExist(errors.Cause(err)) {
+			if err := client.MkdirAll(path.Dir(filename)); err != nil {
+				return errors.Wrapf(err, "Failed to create the directory of %s.", h)
+			}
+			f, err = client.Create(tmpName)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create a temporary file for %s.", h)
+		}
+
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			client.Remove(tmpName)
+			return errors.Wrapf(err, "Failed to write %s.", h)
+		}
+		if err := f.Close(); err != nil {
+			client.Remove(tmpName)
+			return errors.Wrapf(err, "Failed to write %s.", h)
+		}
+
+		if err := client.Rename(tmpName, filename); err != nil {
+			client.Remove(tmpName)
+
+			// SFTP (v3) doesn't overwrite on rename. Files are named by their contents, an existing file is the same file.
+			if _, statErr := client.Stat(filename); statErr == nil {
+				return nil
+			}
+			return errors.Wrapf(err, "Failed to rename the temporary file of %s.", h)
+		}
+		return nil
+	})
+}
+
+func (b *Backend) Load(h storage.Handle, length int, offset int64) ([]byte, error) {
+	var data []byte
+	err := b.withClient(func(client *sftp.Client) error {
+		f, err := client.Open(b.filename(h))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to open %s.", h)
+		}
+		defer f.Close()
+
+		if offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return errors.Wrapf(err, "Failed to seek %s to offset %d.", h, offset)
+			}
+		}
+
+		if length == 0 {
+			if data, err = ioutil.ReadAll(f); err != nil {
+				return errors.Wrapf(err, "Failed to read %s.", h)
+			}
+			return nil
+		}
+
+		data = make([]byte, length)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return errors.Wrapf(err, "Failed to read %d bytes at offset %d of %s.", length, offset, h)
+		}
+		return nil
+	})
+	return data, err
+}
+
+func (b *Backend) Stat(h storage.Handle) (storage.FileInfo, error) {
+	var info storage.FileInfo
+	err := b.withClient(func(client *sftp.Client) error {
+		fileInfo, err := client.Stat(b.filename(h))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to stat %s.", h)
+		}
+		info = storage.FileInfo{Name: h.Name, Size: fileInfo.Size()}
+		return nil
+	})
+	return info, err
+}
+
+func (b *Backend) List(t storage.FileType) ([]storage.FileInfo, error) {
+	if t == storage.ConfigFile {
+		info, err := b.Stat(storage.Handle{Type: t})
+		if err != nil {
+			if b.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []storage.FileInfo{info}, nil
+	}
+
+	dirs := []string{path.Join(b.cfg.Path, string(t))}
+	if t == storage.PackFile {
+		dirs = nil
+		for _, sub := range storage.PackSubDirs() {
+			dirs = append(dirs, path.Join(b.cfg.Path, string(t), sub))
+		}
+	}
+
+	var infos []storage.FileInfo
+	err := b.withClient(func(client *sftp.Client) error {
+		infos = infos[:0]
+		for _, dir := range dirs {
+			entries, err := client.ReadDir(dir)
+			if err != nil {
+				if os.IsNotExist(errors.Cause(err)) {
+					continue
+				}
+				return errors.Wrapf(err, "Failed to list '%s'.", dir)
+			}
+
+			for _, entry := range entries {
+				if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+				infos = append(infos, storage.FileInfo{Name: entry.Name(), Size: entry.Size()})
+			}
+		}
+		return nil
+	})
+	return infos, err
+}
+
+func (b *Backend) Remove(h storage.Handle) error {
+	return b.withClient(func(client *sftp.Client) error {
+		if err := client.Remove(b.filename(h)); err != nil {
+			return errors.Wrapf(err, "Failed to remove %s.", h)
+		}
+		return nil
+	})
+}
+
+func (b *Backend) IsNotExist(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
+
+func (b *Backend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.closeConnection()
+}
+
+// withClient runs the operation, reconnecting and retrying it once if the connection failed
+func (b *Backend) withClient(operation func(client *sftp.Client) error) error {
+	client, err := b.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = operation(client)
+	if err == nil || !isConnectionError(err) {
+		return err
+	}
+
+	b.resetClient(client)
+	if client, err = b.getClient(); err != nil {
+		return err
+	}
+	return operation(client)
+}
+
+// getClient returns the current client, connecting to the server if needed
+func (b *Backend) getClient() (*sftp.Client, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	address := net.JoinHostPort(b.cfg.Host, b.cfg.Port)
+	conn, err := ssh.Dial("tcp", address, b.sshConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to '%s'.", address)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Failed to start the SFTP session with '%s'.", address)
+	}
+
+	b.conn, b.client = conn, client
+	return client, nil
+}
+
+// resetClient drops the failed client, unless it was already replaced by another operation
+func (b *Backend) resetClient(failed *sftp.Client) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.client == failed {
+		b.closeConnection()
+	}
+}
+
+func (b *Backend) closeConnection() error {
+	if b.client == nil {
+		return nil
+	}
+
+	b.client.Close()
+	err := b.conn.Close()
+	b.conn, b.client = nil, nil
+	return err
+}
+
+func (b *Backend) filename(h storage.Handle) string {
+	return path.Join(b.cfg.Path, storage.Filename(h))
+}
+
+// isConnectionError returns true unless the error was reported by the server or is one of the expected I/O errors
+func isConnectionError(err error) bool {
+	err = errors.Cause(err)
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+
+	if status, ok := err.(*sftp.StatusError); ok {
+		return status.Code == sshFxNoConnection || status.Code == sshFxConnectionLost
+	}
+	return err != os.ErrNotExist && err != os.ErrPermission && err != io.ErrUnexpectedEOF
+}
+
+// loadIdentity reads the private key used to authenticate
+func loadIdentity(identityFile string) (ssh.Signer, error) {
+	candidates := []string{identityFile}
+	if identityFile == "" {
+		candidates = []string{homePath(".ssh", "id_ed25519"), homePath(".ssh", "id_ecdsa"), homePath(".ssh", "id_rsa")}
+	}
+
+	for _, candidate := range candidates {
+		data, err := ioutil.ReadFile(candidate)
+		if err != nil {
+			if os.IsNotExist(err) && identityFile == "" {
+				continue
+			}
+			return nil, errors.Wrapf(err, "Failed to read the private key '%s'.", candidate)
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse the private key '%s'.", candidate)
+		}
+		return signer, nil
+	}
+
+	return nil, errors.New("No private key found for the SFTP authentication (set the sftp.identityFile configuration).")
+}
+
+func homePath(elements ...string) string {
+	home := os.Getenv("HOME")
+	if current, err := user.Current(); home == "" && err == nil {
+		home = current.HomeDir
+	}
+	return filepath.Join(append([]string{home}, elements...)...)
+}
@@ -0,0 +1,213 @@
+// +build unit
+
+package sftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+)
+
+// testServer is an in-process SSH server serving the SFTP subsystem, accepting a single client key
+type testServer struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	hostKey     ssh.PublicKey
+	lock        sync.Mutex
+	conns       []net.Conn
+	connections int
+}
+
+func newTestServer(tst *testing.T, clientKey ssh.PublicKey) *testServer {
+	hostSigner, _ := newKey(tst)
+
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	sshConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tst, err)
+
+	server := &testServer{listener: listener, config: sshConfig, hostKey: hostSigner.PublicKey()}
+	go server.serve()
+	return server
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		s.conns = append(s.conns, conn)
+		s.connections++
+		s.lock.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for request := range channelRequests {
+				isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(isSftp, nil)
+				if isSftp {
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						channel.Close()
+						return
+					}
+					go func() {
+						server.Serve()
+						channel.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// dropConnections breaks the established connections
+func (s *testServer) dropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+	s.dropConnections()
+}
+
+func newKey(tst *testing.T) (ssh.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(tst, err)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(tst, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(tst, err)
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestParseConfig(tst *testing.T) {
+	cfg, err := ParseConfig("sftp:backup@bastion:/srv/backups/repo/")
+	require.NoError(tst, err)
+	assert.Equal(tst, Config{User: "backup", Host: "bastion", Port: "22", Path: "/srv/backups/repo"}, cfg)
+
+	cfg, err = ParseConfig("sftp://backup@bastion:2222/srv/backups/repo")
+	require.NoError(tst, err)
+	assert.Equal(tst, Config{User: "backup", Host: "bastion", Port: "2222", Path: "/srv/backups/repo"}, cfg)
+
+	_, err = ParseConfig("sftp:bastion")
+	assert.Error(tst, err)
+}
+
+func TestBackend(tst *testing.T) {
+	dir, err := ioutil.TempDir("", "v3io-backup-sftp")
+	require.NoError(tst, err)
+	defer os.RemoveAll(dir)
+
+	clientSigner, clientKey := newKey(tst)
+	identityFile := filepath.Join(dir, "id_ecdsa")
+	require.NoError(tst, ioutil.WriteFile(identityFile, clientKey, 0600))
+
+	server := newTestServer(tst, clientSigner.PublicKey())
+	defer server.Close()
+
+	address := server.listener.Addr().String()
+	host, port, err := net.SplitHostPort(address)
+	require.NoError(tst, err)
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	require.NoError(tst, ioutil.WriteFile(knownHostsFile,
+		[]byte(knownhosts.Line([]string{knownhosts.Normalize(address)}, server.hostKey)+"\n"), 0600))
+
+	cfg, err := ParseConfig("sftp://tester@" + net.JoinHostPort(host, port) + filepath.ToSlash(filepath.Join(dir, "repo")))
+	require.NoError(tst, err)
+	cfg.Sftp = config.SftpConfig{IdentityFile: identityFile, KnownHostsFile: knownHostsFile}
+
+	backend, err := New(cfg)
+	require.NoError(tst, err)
+	defer backend.Close()
+	require.NoError(tst, backend.Create())
+
+	pack := storage.Handle{Type: storage.PackFile, Name: "abcdef"}
+	require.NoError(tst, backend.Save(pack, []byte("0123456789")))
+	// Saving the same file again is harmless
+	require.NoError(tst, backend.Save(pack, []byte("0123456789")))
+
+	data, err := backend.Load(pack, 3, 4)
+	require.NoError(tst, err)
+	assert.Equal(tst, "456", string(data))
+
+	packs, err := backend.List(storage.PackFile)
+	require.NoError(tst, err)
+	assert.Equal(tst, []storage.FileInfo{{Name: "abcdef", Size: 10}}, packs)
+
+	// The connection is re-established after a failure
+	server.dropConnections()
+	data, err = backend.Load(pack, 0, 0)
+	require.NoError(tst, err)
+	assert.Equal(tst, "0123456789", string(data))
+	assert.Equal(tst, 2, server.connections)
+
+	require.NoError(tst, backend.Remove(pack))
+	_, err = backend.Stat(pack)
+	assert.True(tst, backend.IsNotExist(err))
+
+	// Servers missing from the known hosts are rejected
+	otherSigner, _ := newKey(tst)
+	require.NoError(tst, ioutil.WriteFile(knownHostsFile,
+		[]byte(knownhosts.Line([]string{knownhosts.Normalize(address)}, otherSigner.PublicKey())+"\n"), 0600))
+	_, err = New(cfg)
+	assert.Error(tst, err)
+}
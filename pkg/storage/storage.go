@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// FileType is the type of a file stored in the repository
+type FileType string
+
+// Repository file types. Each type (but the config) is stored within a directory of the same name.
+const (
+	ConfigFile   FileType = "config"
+	PackFile     FileType = "data"
+	IndexFile    FileType = "index"
+	SnapshotFile FileType = "snapshots"
+	KeyFile      FileType = "keys"
+	LockFile     FileType = "locks"
+)
+
+// DirFileTypes lists the file types stored within directories
+var DirFileTypes = []FileType{PackFile, IndexFile, SnapshotFile, KeyFile, LockFile}
+
+// Handle identifies a file within the repository
+type Handle struct {
+	Type FileType
+	Name string
+}
+
+func (h Handle) String() string {
+	if h.Type == ConfigFile {
+		return string(h.Type)
+	}
+	return fmt.Sprintf("%s/%s", h.Type, h.Name)
+}
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Name string
+	Size int64
+}
+
+// Backend stores the repository files. Implementations must be safe for concurrent use.
+type Backend interface {
+	// Location returns a description of where the files are stored
+	Location() string
+
+	// Create prepares the layout of a new repository
+	Create() error
+
+	// Save stores the file. Once Save returns, the file is either fully stored or not stored at all.
+	Save(h Handle, data []byte) error
+
+	// Load reads 'length' bytes of the file starting at 'offset'. A zero length reads up to the end of the file.
+	Load(h Handle, length int, offset int64) ([]byte, error)
+
+	// Stat returns the information of the file
+	Stat(h Handle) (FileInfo, error)
+
+	// List returns the files of the given type
+	List(t FileType) ([]FileInfo, error)
+
+	// Remove deletes the file
+	Remove(h Handle) error
+
+	// IsNotExist returns true if the error was caused by a missing file
+	IsNotExist(err error) bool
+
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// Filename returns the path of the file relative to the repository root, using forward slashes.
+// Packs are spread across 256 sub-directories named by the first two hex digits of their name.
+func Filename(h Handle) string {
+	switch h.Type {
+	case ConfigFile:
+		return string(ConfigFile)
+	case PackFile:
+		if len(h.Name) > 2 {
+			return path.Join(string(h.Type), h.Name[:2], h.Name)
+		}
+	}
+	return path.Join(string(h.Type), h.Name)
+}
+
+// Dirs returns all the directories of the repository layout, relative to the repository root
+func Dirs() []string {
+	var dirs []string
+	for _, t := range DirFileTypes {
+		dirs = append(dirs, string(t))
+	}
+	for _, sub := range PackSubDirs() {
+		dirs = append(dirs, path.Join(string(PackFile), sub))
+	}
+	return dirs
+}
+
+// PackSubDirs returns the names of the pack sub-directories ("00" to "ff")
+func PackSubDirs() []string {
+	dirs := make([]string, 256)
+	for i := range dirs {
+		dirs[i] = hex.EncodeToString([]byte{byte(i)})
+	}
+	return dirs
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package v3io
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/pkg/errors"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	v3iobackend "v3io-backup/pkg/backend/v3io"
+	v3ioUtils "v3io-backup/pkg/backend/v3io/utils"
+	"v3io-backup/pkg/config"
+	"v3io-backup/pkg/storage"
+	"v3io-backup/pkg/utils"
+)
+
+const defaultHttpTimeout = 30 * time.Second
+
+// Config locates the repository within a V3IO container
+type Config struct {
+	WebApiEndpoint string // e.g. "http://192.168.1.100:8081"
+	Container      string
+	Path           string // path of the repository within the container
+}
+
+// ParseConfig parses a location of the form "v3io://<endpoint>/<container>[/<path>]",
+// e.g. "v3io://192.168.1.100:8081/backups/my-repo"
+func ParseConfig(location string) (Config, error) {
+	if !strings.HasPrefix(location, "v3io://") {
+		return Config{}, errors.Errorf("Invalid V3IO location '%s'.", location)
+	}
+
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(location, "v3io://"), "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Config{}, errors.Errorf("Invalid V3IO location '%s' - expected 'v3io://<endpoint>/<container>[/<path>]'.", location)
+	}
+
+	cfg := Config{WebApiEndpoint: "http://" + parts[0], Container: parts[1], Path: "/"}
+	if len(parts) == 3 {
+		cfg.Path = path.Clean("/" + parts[2])
+	}
+	return cfg, nil
+}
+
+// Backend stores the repository files as objects of a V3IO container
+type Backend struct {
+	cfg       Config
+	container v3io.Container
+	logger    logger.Logger
+}
+
+// New connects to the repository container. The container is accessed with the credentials of
+// the V3ioRepository configuration - the V3IO credentials of appCfg are those of the backed up container.
+func New(cfg Config, appCfg *config.Config, logger logger.Logger) (*Backend, error) {
+	containerCfg := &config.Config{
+		WebApiEndpoint:     cfg.WebApiEndpoint,
+		Container:          cfg.Container,
+		Username:           appCfg.V3ioRepository.Username,
+		Password:           appCfg.V3ioRepository.Password,
+		AccessKey:          appCfg.V3ioRepository.AccessKey,
+		ScannerParallelism: appCfg.ScannerParallelism,
+	}
+
+	httpTimeout := defaultHttpTimeout
+	if appCfg.HttpTimeout != "" {
+		timeout, err := time.ParseDuration(appCfg.HttpTimeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid httpTimeout '%s'.", appCfg.HttpTimeout)
+		}
+		httpTimeout = timeout
+	}
+
+	container, err := utils.CreateContainer(logger, containerCfg, httpTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create the repository container '%s' at '%s'.", cfg.Container, cfg.WebApiEndpoint)
+	}
+
+	return newBackend(cfg, container, logger), nil
+}
+
+func newBackend(cfg Config, container v3io.Container, logger logger.Logger) *Backend {
+	return &Backend{cfg: cfg, container: container, logger: logger}
+}
+
+func (b *Backend) Location() string {
+	return fmt.Sprintf("v3io://%s/%s%s", strings.TrimPrefix(b.cfg.WebApiEndpoint, "http://"), b.cfg.Container, b.cfg.Path)
+}
+
+// Create checks that the container is accessible. V3IO directories are created implicitly along with the objects.
+func (b *Backend) Create() error {
+	_, err := b.listPage("/", "")
+	return err
+}
+
+// Save writes the object with a single request, so it is either fully written or not at all
+func (b *Backend) Save(h storage.Handle, data []byte) error {
+	err := b.container.PutObjectSync(&v3io.PutObjectInput{
+		Path: b.objectPath(h),
+		Body: data,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write %s.", h)
+	}
+	return nil
+}
+
+func (b *Backend) Load(h storage.Handle, length int, offset int64) ([]byte, error) {
+	if length == 0 && offset > 0 {
+		info, err := b.Stat(h)
+		if err != nil {
+			return nil, err
+		}
+		length = int(info.Size - offset)
+		if length <= 0 {
+			return []byte{}, nil
+		}
+	}
+
+	response, err := b.container.GetObjectSync(&v3io.GetObjectInput{
+		Path:     b.objectPath(h),
+		Offset:   int(offset),
+		NumBytes: length,
+	})
+	if response != nil {
+		defer response.Release()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s.", h)
+	}
+
+	body := response.Body()
+	if length > 0 && len(body) != length {
+		return nil, errors.Errorf("Failed to read %d bytes at offset %d of %s (got %d).", length, offset, h, len(body))
+	}
+
+	// The response is released once read, keep a copy of the data
+	return append([]byte(nil), body...), nil
+}
+
+func (b *Backend) Stat(h storage.Handle) (storage.FileInfo, error) {
+	response, err := b.container.GetItemSync(&v3io.GetItemInput{
+		Path:           b.objectPath(h),
+		AttributeNames: []string{"__size"},
+	})
+	if response != nil {
+		defer response.Release()
+	}
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Failed to stat %s.", h)
+	}
+
+	output, ok := response.Output.(*v3io.GetItemOutput)
+	if !ok {
+		return storage.FileInfo{}, errors.Errorf("Unexpected response while getting the size of %s.", h)
+	}
+
+	size, err := parseSize(output.Item["__size"])
+	if err != nil {
+		return storage.FileInfo{}, errors.Wrapf(err, "Failed to stat %s.", h)
+	}
+	return storage.FileInfo{Name: h.Name, Size: size}, nil
+}
+
+func (b *Backend) List(t storage.FileType) ([]storage.FileInfo, error) {
+	dir := path.Join(b.cfg.Path, string(t))
+	if t == storage.ConfigFile {
+		info, err := b.Stat(storage.Handle{Type: t})
+		if err != nil {
+			if b.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []storage.FileInfo{info}, nil
+	}
+
+	dirs := []string{dir}
+	if t == storage.PackFile {
+		// Packs are stored within sub-directories, only the non empty ones exist
+		subDirs, err := b.listDir(dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		dirs = subDirs
+	}
+
+	var infos []storage.FileInfo
+	for _, dir := range dirs {
+		if _, err := b.listDir(dir, &infos); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+func (b *Backend) Remove(h storage.Handle) error {
+	if err := b.container.DeleteObjectSync(&v3io.DeleteObjectInput{Path: b.objectPath(h)}); err != nil {
+		return errors.Wrapf(err, "Failed to remove %s.", h)
+	}
+	return nil
+}
+
+func (b *Backend) IsNotExist(err error) bool {
+	return v3ioUtils.IsNotExistsError(errors.Cause(err))
+}
+
+func (b *Backend) Close() error {
+	return nil
+}
+
+// listDir reads all the pages of the directory listing - the objects are appended to infos (when set),
+// and the sub-directories are returned. A missing directory is empty.
+func (b *Backend) listDir(dir string, infos *[]storage.FileInfo) ([]string, error) {
+	var subDirs []string
+	marker := ""
+	for {
+		result, err := b.listPage(dir, marker)
+		if err != nil {
+			if b.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		for _, prefix := range result.CommonPrefixes {
+			subDirs = append(subDirs, "/"+strings.Trim(prefix.Prefix, "/"))
+		}
+		if infos != nil {
+			for _, content := range result.Contents {
+				*infos = append(*infos, storage.FileInfo{Name: path.Base(content.Key), Size: content.Size})
+			}
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(result.IsTruncated), "true") || result.NextMarker == "" {
+			return subDirs, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (b *Backend) listPage(dir string, marker string) (*v3iobackend.ListBucketResult, error) {
+	listingPath := dir
+	if dir != "/" {
+		listingPath += "/"
+	}
+
+	response, err := b.container.GetContainerContentsSync(&v3io.GetContainerContentsInput{
+		Path:   listingPath,
+		Marker: marker,
+	})
+	if response != nil {
+		defer response.Release()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list '%s' of container '%s'.", dir, b.cfg.Container)
+	}
+
+	result := v3iobackend.ListBucketResult{}
+	if err := xml.Unmarshal(response.Body(), &result); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse the listing of '%s' of container '%s'.", dir, b.cfg.Container)
+	}
+	return &result, nil
+}
+
+func (b *Backend) objectPath(h storage.Handle) string {
+	return path.Join(b.cfg.Path, storage.Filename(h))
+}
+
+// parseSize converts the value of the "__size" system attribute
+func parseSize(value interface{}) (int64, error) {
+	switch typed := value.(type) {
+	case int:
+		return int64(typed), nil
+	case int64:
+		return typed, nil
+	case float64:
+		return int64(typed), nil
+	case string:
+		return strconv.ParseInt(typed, 10, 64)
+	default:
+		return 0, errors.Errorf("Unexpected object size '%v'.", value)
+	}
+}
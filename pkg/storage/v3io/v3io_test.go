@@ -0,0 +1,230 @@
+// +build unit
+
+package v3io
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v3io "github.com/v3io/v3io-go/pkg/dataplane"
+	v3ioerrors "github.com/v3io/v3io-go/pkg/errors"
+	"github.com/valyala/fasthttp"
+	v3iobackend "v3io-backup/pkg/backend/v3io"
+	"v3io-backup/pkg/storage"
+)
+
+// fakeContainer is a minimal in-memory stand-in of a V3IO container, serving the object requests of the backend.
+// The directory listings are split into pages of pageSize entries.
+type fakeContainer struct {
+	v3io.Container // the other requests are not used by the backend
+	lock           sync.Mutex
+	objects        map[string][]byte
+	pageSize       int
+	pages          int
+}
+
+func newFakeContainer(pageSize int) *fakeContainer {
+	return &fakeContainer{objects: make(map[string][]byte), pageSize: pageSize}
+}
+
+func newResponse(output interface{}, body []byte) *v3io.Response {
+	response := &v3io.Response{Output: output, HTTPResponse: fasthttp.AcquireResponse()}
+	response.HTTPResponse.SetBody(body)
+	return response
+}
+
+func notFound(p string) error {
+	return v3ioerrors.NewErrorWithStatusCode(errors.Errorf("'%s' not found", p), http.StatusNotFound)
+}
+
+func (fc *fakeContainer) PutObjectSync(input *v3io.PutObjectInput) error {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.objects[input.Path] = append([]byte{}, input.Body...)
+	return nil
+}
+
+func (fc *fakeContainer) GetObjectSync(input *v3io.GetObjectInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	data, ok := fc.objects[input.Path]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	if input.Offset > len(data) {
+		data = nil
+	} else {
+		data = data[input.Offset:]
+	}
+	if input.NumBytes > 0 && input.NumBytes < len(data) {
+		data = data[:input.NumBytes]
+	}
+	return newResponse(nil, data), nil
+}
+
+func (fc *fakeContainer) GetItemSync(input *v3io.GetItemInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	data, ok := fc.objects[input.Path]
+	if !ok {
+		return nil, notFound(input.Path)
+	}
+	return newResponse(&v3io.GetItemOutput{Item: v3io.Item{"__size": len(data)}}, nil), nil
+}
+
+func (fc *fakeContainer) DeleteObjectSync(input *v3io.DeleteObjectInput) error {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	if _, ok := fc.objects[input.Path]; !ok {
+		return notFound(input.Path)
+	}
+	delete(fc.objects, input.Path)
+	return nil
+}
+
+// GetContainerContentsSync lists the objects and the sub-directories of the directory, in name order,
+// starting after the marker
+func (fc *fakeContainer) GetContainerContentsSync(input *v3io.GetContainerContentsInput) (*v3io.Response, error) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	dir := strings.TrimPrefix(input.Path, "/")
+	isDir := make(map[string]bool)
+	for objectPath := range fc.objects {
+		key := strings.TrimPrefix(objectPath, "/")
+		if !strings.HasPrefix(key, dir) {
+			continue
+		}
+		if i := strings.Index(key[len(dir):], "/"); i >= 0 {
+			isDir[key[:len(dir)+i+1]] = true
+		} else {
+			isDir[key] = false
+		}
+	}
+	if len(isDir) == 0 && dir != "" {
+		return nil, notFound(input.Path)
+	}
+
+	var keys []string
+	for key := range isDir {
+		if key > input.Marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := v3iobackend.ListBucketResult{IsTruncated: "false"}
+	if len(keys) > fc.pageSize {
+		keys = keys[:fc.pageSize]
+		result.IsTruncated = "true"
+		result.NextMarker = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		if isDir[key] {
+			result.CommonPrefixes = append(result.CommonPrefixes, v3iobackend.CommonPrefixes{Prefix: key})
+		} else {
+			result.Contents = append(result.Contents, v3iobackend.Contents{Key: key, Size: int64(len(fc.objects["/"+key]))})
+		}
+	}
+	fc.pages++
+
+	body, err := xml.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(nil, body), nil
+}
+
+func TestParseConfig(tst *testing.T) {
+	cfg, err := ParseConfig("v3io://192.168.1.100:8081/backups/nightly/repo/")
+	require.NoError(tst, err)
+	assert.Equal(tst, Config{WebApiEndpoint: "http://192.168.1.100:8081", Container: "backups", Path: "/nightly/repo"}, cfg)
+
+	backend := newBackend(cfg, nil, nil)
+	assert.Equal(tst, "v3io://192.168.1.100:8081/backups/nightly/repo", backend.Location())
+	assert.Equal(tst, "/nightly/repo/data/ab/abcdef", backend.objectPath(storage.Handle{Type: storage.PackFile, Name: "abcdef"}))
+
+	cfg, err = ParseConfig("v3io://localhost:8081/backups")
+	require.NoError(tst, err)
+	assert.Equal(tst, "/", cfg.Path)
+	assert.Equal(tst, "/config", newBackend(cfg, nil, nil).objectPath(storage.Handle{Type: storage.ConfigFile}))
+
+	_, err = ParseConfig("v3io://localhost:8081")
+	assert.Error(tst, err)
+}
+
+func TestBackend(tst *testing.T) {
+	container := newFakeContainer(2)
+	backend := newBackend(Config{WebApiEndpoint: "http://localhost:8081", Container: "backups", Path: "/repo"}, container, nil)
+	require.NoError(tst, backend.Create())
+
+	config := storage.Handle{Type: storage.ConfigFile}
+	_, err := backend.Stat(config)
+	assert.True(tst, backend.IsNotExist(err))
+	infos, err := backend.List(storage.ConfigFile)
+	require.NoError(tst, err)
+	assert.Empty(tst, infos)
+	require.NoError(tst, backend.Save(config, []byte("config")))
+
+	pack := storage.Handle{Type: storage.PackFile, Name: "ab0001"}
+	require.NoError(tst, backend.Save(pack, []byte("0123456789")))
+	assert.Contains(tst, container.objects, "/repo/data/ab/ab0001")
+
+	data, err := backend.Load(pack, 0, 0)
+	require.NoError(tst, err)
+	assert.Equal(tst, "0123456789", string(data))
+
+	data, err = backend.Load(pack, 4, 3)
+	require.NoError(tst, err)
+	assert.Equal(tst, "3456", string(data))
+
+	data, err = backend.Load(pack, 0, 7)
+	require.NoError(tst, err)
+	assert.Equal(tst, "789", string(data))
+
+	_, err = backend.Load(pack, 4, 8)
+	assert.Error(tst, err)
+
+	info, err := backend.Stat(pack)
+	require.NoError(tst, err)
+	assert.Equal(tst, storage.FileInfo{Name: "ab0001", Size: 10}, info)
+
+	// Both the pack sub-directories and their objects span several listing pages
+	expected := []storage.FileInfo{{Name: "ab0001", Size: 10}}
+	for _, name := range []string{"ab0002", "ab0003", "cd0001", "ef0001"} {
+		require.NoError(tst, backend.Save(storage.Handle{Type: storage.PackFile, Name: name}, []byte(name)))
+		expected = append(expected, storage.FileInfo{Name: name, Size: int64(len(name))})
+	}
+	container.pages = 0
+	infos, err = backend.List(storage.PackFile)
+	require.NoError(tst, err)
+	assert.Equal(tst, expected, infos)
+	assert.Equal(tst, 6, container.pages)
+
+	infos, err = backend.List(storage.ConfigFile)
+	require.NoError(tst, err)
+	assert.Equal(tst, []storage.FileInfo{{Name: "", Size: 6}}, infos)
+
+	// Missing directories are empty
+	infos, err = backend.List(storage.IndexFile)
+	require.NoError(tst, err)
+	assert.Empty(tst, infos)
+
+	require.NoError(tst, backend.Remove(pack))
+	_, err = backend.Load(pack, 0, 0)
+	assert.True(tst, backend.IsNotExist(err))
+	_, err = backend.Stat(pack)
+	assert.True(tst, backend.IsNotExist(err))
+	err = backend.Remove(pack)
+	assert.True(tst, backend.IsNotExist(err))
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2018 Iguazio Systems Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License") with
+an addition restriction as set forth herein. You may not use this
+file except in compliance with the License. You may obtain a copy of
+the License at http://www.apache.org/licenses/LICENSE-2.0.
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing
+permissions and limitations under the License.
+
+In addition, you may not use the software for any purposes that are
+illegal under applicable law, and the grant of the foregoing license
+under the Apache 2.0 license is conditioned upon your compliance with
+such restriction.
+*/
+
+package table
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Format of the encoded items - the values of every attribute are stored together (column by column):
+//
+//	magic | version | uvarint item count | uvarint column count | column...
+//	column: uvarint name length | name | value (one per item)
+//	value: type | payload
+//
+// Integers are zig-zag varints, floats are little-endian IEEE 754 and strings/blobs are length-prefixed.
+// Items without the attribute have an absent value.
+const (
+	magic   = "V3TB"
+	version = 1
+)
+
+// Value types
+const (
+	typeAbsent byte = iota
+	typeInt
+	typeFloat
+	typeString
+	typeBlob
+	typeFalse
+	typeTrue
+)
+
+type column struct {
+	name   string
+	values []byte
+	count  int // number of encoded values
+}
+
+// Encoder accumulates table items and encodes them into a compact columnar blob.
+// The items are maps of attribute names to int, int64, float64, string, []byte or bool values.
+type Encoder struct {
+	columns map[string]*column
+	items   int
+	size    int
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{columns: make(map[string]*column)}
+}
+
+// Add appends the item to the encoded items
+func (e *Encoder) Add(item map[string]interface{}) error {
+	// Validate first, so a bad item doesn't leave the columns misaligned
+	for name, value := range item {
+		switch value.(type) {
+		case int, int64, int32, float64, float32, string, []byte, bool:
+		default:
+			return errors.Errorf("Unsupported type %T of attribute '%s'.", value, name)
+		}
+	}
+
+	for name, value := range item {
+		col, ok := e.columns[name]
+		if !ok {
+			col = &column{name: name}
+			e.columns[name] = col
+			e.size += binary.MaxVarintLen64 + len(name)
+		}
+		e.size -= len(col.values)
+		col.pad(e.items)
+		col.values = appendValue(col.values, value)
+		col.count++
+		e.size += len(col.values)
+	}
+
+	e.items++
+	return nil
+}
+
+// Len returns the number of items added since the last reset
+func (e *Encoder) Len() int {
+	return e.items
+}
+
+// Size returns the approximate size of the encoded items
+func (e *Encoder) Size() int {
+	// Pending absent values take a byte each
+	return e.size + e.items
+}
+
+// Encode returns the encoded items. Columns are ordered by name, so the same items encode the same.
+func (e *Encoder) Encode() []byte {
+	names := make([]string, 0, len(e.columns))
+	for name, col := range e.columns {
+		col.pad(e.items)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data := make([]byte, 0, e.Size()+len(magic)+1+2*binary.MaxVarintLen64)
+	data = append(data, magic...)
+	data = append(data, version)
+	data = appendUvarint(data, uint64(e.items))
+	data = appendUvarint(data, uint64(len(names)))
+	for _, name := range names {
+		col := e.columns[name]
+		data = appendUvarint(data, uint64(len(name)))
+		data = append(data, name...)
+		data = append(data, col.values...)
+	}
+	return data
+}
+
+// Reset drops the items, so the encoder can be reused
+func (e *Encoder) Reset() {
+	e.columns = make(map[string]*column)
+	e.items = 0
+	e.size = 0
+}
+
+// pad adds absent values up to the given number of items
+func (col *column) pad(items int) {
+	for ; col.count < items; col.count++ {
+		col.values = append(col.values, typeAbsent)
+	}
+}
+
+func appendValue(data []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		return appendVarint(append(data, typeInt), int64(v))
+	case int64:
+		return appendVarint(append(data, typeInt), v)
+	case int32:
+		return appendVarint(append(data, typeInt), int64(v))
+	case float64:
+		return appendFloat(append(data, typeFloat), v)
+	case float32:
+		return appendFloat(append(data, typeFloat), float64(v))
+	case string:
+		data = appendUvarint(append(data, typeString), uint64(len(v)))
+		return append(data, v...)
+	case []byte:
+		data = appendUvarint(append(data, typeBlob), uint64(len(v)))
+		return append(data, v...)
+	case bool:
+		if v {
+			return append(data, typeTrue)
+		}
+		return append(data, typeFalse)
+	}
+	return data
+}
+
+func appendUvarint(data []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(data, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(data []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(data, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendFloat(data []byte, v float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(data, buf[:]...)
+}
+
+// Decode returns the items of the encoded blob. Integers are decoded as int64 values.
+func Decode(data []byte) ([]map[string]interface{}, error) {
+	rd := &reader{data: data}
+	if string(rd.bytes(len(magic))) != magic {
+		return nil, errors.New("Not an encoded table blob.")
+	}
+	if v := rd.byte(); v != version {
+		return nil, errors.Errorf("Unsupported table blob version %d.", v)
+	}
+
+	numItems := rd.uvarint()
+	numColumns := rd.uvarint()
+	if rd.err != nil || numItems > uint64(len(data)) || numColumns > uint64(len(data)) {
+		return nil, errors.New("Corrupt table blob header.")
+	}
+
+	items := make([]map[string]interface{}, numItems)
+	for i := range items {
+		items[i] = make(map[string]interface{})
+	}
+
+	for c := uint64(0); c < numColumns && rd.err == nil; c++ {
+		name := string(rd.bytes(int(rd.uvarint())))
+		for i := 0; i < len(items) && rd.err == nil; i++ {
+			if value, present := rd.value(); present {
+				items[i][name] = value
+			}
+		}
+	}
+
+	if rd.err != nil {
+		return nil, errors.Wrap(rd.err, "Corrupt table blob.")
+	}
+	if len(rd.data) > 0 {
+		return nil, errors.Errorf("Corrupt table blob - %d trailing bytes.", len(rd.data))
+	}
+	return items, nil
+}
+
+// reader consumes the encoded data, remembering the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+var errTruncated = errors.New("Unexpected end of data.")
+
+func (rd *reader) bytes(n int) []byte {
+	if rd.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(rd.data) {
+		rd.err = errTruncated
+		return nil
+	}
+	b := rd.data[:n]
+	rd.data = rd.data[n:]
+	return b
+}
+
+func (rd *reader) byte() byte {
+	if b := rd.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (rd *reader) uvarint() uint64 {
+	if rd.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(rd.data)
+	if n <= 0 {
+		rd.err = errTruncated
+		return 0
+	}
+	rd.data = rd.data[n:]
+	return v
+}
+
+func (rd *reader) varint() int64 {
+	if rd.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(rd.data)
+	if n <= 0 {
+		rd.err = errTruncated
+		return 0
+	}
+	rd.data = rd.data[n:]
+	return v
+}
+
+func (rd *reader) value() (interface{}, bool) {
+	switch t := rd.byte(); t {
+	case typeAbsent:
+		return nil, false
+	case typeInt:
+		return rd.varint(), true
+	case typeFloat:
+		b := rd.bytes(8)
+		if b == nil {
+			return nil, false
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), true
+	case typeString:
+		return string(rd.bytes(int(rd.uvarint()))), true
+	case typeBlob:
+		return append([]byte{}, rd.bytes(int(rd.uvarint()))...), true
+	case typeFalse:
+		return false, true
+	case typeTrue:
+		return true, true
+	default:
+		if rd.err == nil {
+			rd.err = errors.Errorf("Unknown value type %d.", t)
+		}
+		return nil, false
+	}
+}
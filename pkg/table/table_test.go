@@ -0,0 +1,58 @@
+// +build unit
+
+package table
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(tst *testing.T) {
+	items := []map[string]interface{}{
+		{"__name": "a", "count": 1, "ratio": 0.25, "tag": "x", "raw": []byte{0, 255}, "ok": true},
+		{"__name": "b", "count": int64(-7), "ok": false},
+		{"__name": "c", "extra": "late column"},
+	}
+
+	enc := NewEncoder()
+	for _, item := range items {
+		require.NoError(tst, enc.Add(item))
+	}
+	assert.Equal(tst, 3, enc.Len())
+
+	data := enc.Encode()
+	assert.True(tst, enc.Size() > 0)
+
+	decoded, err := Decode(data)
+	require.NoError(tst, err)
+	assert.Equal(tst, []map[string]interface{}{
+		{"__name": "a", "count": int64(1), "ratio": 0.25, "tag": "x", "raw": []byte{0, 255}, "ok": true},
+		{"__name": "b", "count": int64(-7), "ok": false},
+		{"__name": "c", "extra": "late column"},
+	}, decoded)
+
+	// Deterministic regardless of the map iteration order
+	enc.Reset()
+	for _, item := range items {
+		require.NoError(tst, enc.Add(item))
+	}
+	assert.Equal(tst, data, enc.Encode())
+}
+
+func TestEncodeErrors(tst *testing.T) {
+	enc := NewEncoder()
+	assert.Error(tst, enc.Add(map[string]interface{}{"list": []string{"x"}}))
+	assert.Equal(tst, 0, enc.Len())
+
+	require.NoError(tst, enc.Add(map[string]interface{}{"a": "value"}))
+	data := enc.Encode()
+
+	for i := 0; i < len(data); i++ {
+		_, err := Decode(data[:i])
+		assert.Error(tst, err, "truncated at %d", i)
+	}
+	_, err := Decode(append(data, 0))
+	assert.Error(tst, err)
+}